@@ -0,0 +1,194 @@
+package archive
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Entry describe una grabación ya descargada y verificada.
+type Entry struct {
+	RecordingID    string    `json:"recordingId"`
+	ConversationID string    `json:"conversationId,omitempty"`
+	Path           string    `json:"path,omitempty"` // clave en el storage (relativa a su raíz)
+	Size           int64     `json:"size,omitempty"`
+	SHA256         string    `json:"sha256,omitempty"`
+	DownloadedAt   time.Time `json:"downloadedAt,omitempty"`
+	Removed        bool      `json:"removed,omitempty"` // baja lógica de la entrada
+	// Bundle es el paquete (tar.gz o zip) que contiene la grabación, si ya
+	// se empaquetó; Path sigue siendo su ruta dentro del paquete.
+	Bundle string `json:"bundle,omitempty"`
+	// PurgedAt es cuándo se borró la grabación por la política de retención.
+	// La entrada queda para no volver a descargarla; su ruta queda libre.
+	PurgedAt *time.Time `json:"purgedAt,omitempty"`
+	// ConversationStart es el inicio de la conversación, si se conocía al
+	// descargarla (lo usan las rutas de SFTP, ver storage.Scope).
+	ConversationStart *time.Time `json:"conversationStart,omitempty"`
+}
+
+// Index es el índice local de grabaciones descargadas. Se guarda como un
+// archivo JSONL append-only: la última línea de cada recordingId gana.
+// Todos los métodos aceptan un *Index nil (sin índice).
+type Index struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	entries map[string]Entry
+	paths   map[string]string // ruta -> recordingId
+}
+
+// Open carga el índice en path (si existe) y lo deja abierto para agregar
+// entradas.
+func Open(path string) (*Index, error) {
+	index := &Index{path: path, entries: make(map[string]Entry), paths: make(map[string]string)}
+
+	if file, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var entry Entry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.RecordingID == "" {
+				continue
+			}
+			if entry.Removed {
+				delete(index.entries, entry.RecordingID)
+				continue
+			}
+			index.entries[entry.RecordingID] = entry
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("reading archive index %s: %w", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	for _, entry := range index.entries {
+		if entry.Path != "" && entry.PurgedAt == nil {
+			index.paths[entry.Path] = entry.RecordingID
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	index.file = file
+	return index, nil
+}
+
+// Lookup devuelve la entrada de recordingID si está en el índice.
+func (ix *Index) Lookup(recordingID string) (Entry, bool) {
+	if ix == nil {
+		return Entry{}, false
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	entry, ok := ix.entries[recordingID]
+	return entry, ok
+}
+
+// Add agrega (o reemplaza) una entrada y la persiste.
+func (ix *Index) Add(entry Entry) error {
+	if ix == nil {
+		return nil
+	}
+	if entry.DownloadedAt.IsZero() {
+		entry.DownloadedAt = time.Now().UTC()
+	}
+	return ix.write(entry, func() {
+		ix.forgetPath(entry.RecordingID)
+		ix.entries[entry.RecordingID] = entry
+		if entry.Path != "" && entry.PurgedAt == nil {
+			ix.paths[entry.Path] = entry.RecordingID
+		}
+	})
+}
+
+// Remove da de baja una entrada del índice.
+func (ix *Index) Remove(recordingID string) error {
+	if ix == nil {
+		return nil
+	}
+	return ix.write(Entry{RecordingID: recordingID, Removed: true}, func() {
+		ix.forgetPath(recordingID)
+		delete(ix.entries, recordingID)
+	})
+}
+
+// Owner devuelve el recordingId indexado en la ruta path, o "" si no hay
+// ninguno.
+func (ix *Index) Owner(path string) string {
+	if ix == nil {
+		return ""
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	return ix.paths[path]
+}
+
+// forgetPath quita la ruta actual de recordingID. Se llama con ix.mu tomado.
+func (ix *Index) forgetPath(recordingID string) {
+	if old, ok := ix.entries[recordingID]; ok && ix.paths[old.Path] == recordingID {
+		delete(ix.paths, old.Path)
+	}
+}
+
+func (ix *Index) write(entry Entry, apply func()) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if _, err := ix.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	apply()
+	return nil
+}
+
+// Entries devuelve todas las entradas ordenadas por ruta.
+func (ix *Index) Entries() []Entry {
+	if ix == nil {
+		return nil
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	entries := make([]Entry, 0, len(ix.entries))
+	for _, entry := range ix.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].Path < entries[b].Path })
+	return entries
+}
+
+// Len devuelve la cantidad de grabaciones indexadas.
+func (ix *Index) Len() int {
+	if ix == nil {
+		return 0
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	return len(ix.entries)
+}
+
+// Close sincroniza y cierra el archivo del índice.
+func (ix *Index) Close() error {
+	if ix == nil {
+		return nil
+	}
+	if err := ix.file.Sync(); err != nil {
+		ix.file.Close()
+		return err
+	}
+	return ix.file.Close()
+}
@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// cacheVersion identifica el formato del archivo de cache.
+const cacheVersion = 1
+
+// cachedToken es lo que se guarda (cifrado) en la cache.
+type cachedToken struct {
+	Environment string    `json:"environment"`
+	ClientID    string    `json:"clientId"`
+	AccessToken string    `json:"accessToken"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+	RenewAt     time.Time `json:"renewAt"`
+}
+
+// cacheFile es el archivo en disco: el token va cifrado con AES-256-GCM y
+// una clave derivada de CacheKey con scrypt y un salt aleatorio.
+type cacheFile struct {
+	Version    int    `json:"version"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// cacheAEAD deriva la clave de la cache y arma el cifrador.
+func cacheAEAD(key string, salt []byte) (cipher.AEAD, error) {
+	if key == "" {
+		return nil, errors.New("token cache requires a cache key")
+	}
+	derived, err := scrypt.Key([]byte(key), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// loadCache lee el token de la cache. Devuelve nil sin error si no hay
+// cache o si es de otro cliente/entorno.
+func loadCache(opts Options) (*cachedToken, error) {
+	data, err := os.ReadFile(opts.CachePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file cacheFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid token cache: %w", err)
+	}
+	if file.Version != cacheVersion {
+		return nil, fmt.Errorf("unsupported token cache version %d", file.Version)
+	}
+	aead, err := cacheAEAD(opts.CacheKey, file.Salt)
+	if err != nil {
+		return nil, err
+	}
+	// El cliente y el entorno van como datos asociados: una cache de otro
+	// cliente no se puede descifrar con estos valores.
+	plain, err := aead.Open(nil, file.Nonce, file.Ciphertext, associatedData(opts))
+	if err != nil {
+		return nil, nil
+	}
+
+	var token cachedToken
+	if err := json.Unmarshal(plain, &token); err != nil {
+		return nil, fmt.Errorf("invalid token cache: %w", err)
+	}
+	if token.ClientID != opts.ClientID || token.Environment != opts.Environment {
+		return nil, nil
+	}
+	return &token, nil
+}
+
+// saveCache cifra y guarda el token, reemplazando el archivo de forma
+// atómica y sólo legible por el usuario.
+func saveCache(opts Options, token cachedToken) error {
+	token.ClientID = opts.ClientID
+	token.Environment = opts.Environment
+	plain, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	file := cacheFile{Version: cacheVersion, Salt: make([]byte, 16)}
+	if _, err := rand.Read(file.Salt); err != nil {
+		return err
+	}
+	aead, err := cacheAEAD(opts.CacheKey, file.Salt)
+	if err != nil {
+		return err
+	}
+	file.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return err
+	}
+	file.Ciphertext = aead.Seal(nil, file.Nonce, plain, associatedData(opts))
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(opts.CachePath), 0700); err != nil {
+		return err
+	}
+	tmp := opts.CachePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, opts.CachePath)
+}
+
+func removeCache(path string) {
+	os.Remove(path)
+}
+
+func associatedData(opts Options) []byte {
+	return []byte(opts.Environment + "\x00" + opts.ClientID)
+}
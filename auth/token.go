@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goDownloadRecording/logger"
+	"go.uber.org/zap"
+)
+
+// Options configura la obtención de tokens con client credentials.
+type Options struct {
+	Environment  string // p.ej. mypurecloud.com
+	ClientID     string
+	ClientSecret string
+	// RenewBefore es el margen con el que se renueva el token antes de que
+	// venza, para que ningún request salga con un token a punto de expirar.
+	// Con tokens de vida corta se limita a la mitad de su duración.
+	RenewBefore time.Duration
+	// CachePath y CacheKey activan la cache cifrada en disco (ver cache.go).
+	CachePath string
+	CacheKey  string
+}
+
+// DefaultRenewBefore es el margen de renovación por defecto.
+const DefaultRenewBefore = 5 * time.Minute
+
+// Manager mantiene vigente el token OAuth de la corrida: lo pide (o lo lee
+// de la cache) la primera vez, lo renueva antes de que venza y lo descarta
+// cuando la API responde 401. Es seguro para uso concurrente.
+type Manager struct {
+	opts     Options
+	loginURL string
+	client   *http.Client
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+	renewAt   time.Time
+}
+
+// New crea un Manager. No hace ningún request hasta el primer Token().
+func New(opts Options) *Manager {
+	if opts.RenewBefore <= 0 {
+		opts.RenewBefore = DefaultRenewBefore
+	}
+	return &Manager{
+		opts:     opts,
+		loginURL: "https://login." + opts.Environment + "/oauth/token",
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Token devuelve un token vigente por al menos RenewBefore, pidiendo uno
+// nuevo si hace falta.
+func (m *Manager) Token() (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.token != "" && time.Now().Before(m.renewAt) {
+		return m.token, nil
+	}
+	if m.token == "" && m.opts.CachePath != "" {
+		if cached, err := loadCache(m.opts); err != nil {
+			logger.Log.Warn("Ignoring token cache", zap.String("Path", m.opts.CachePath), zap.Error(err))
+		} else if cached != nil && time.Now().Before(cached.RenewAt) {
+			logger.Log.Info("Using cached access token", zap.Time("ExpiresAt", cached.ExpiresAt))
+			m.token, m.expiresAt, m.renewAt = cached.AccessToken, cached.ExpiresAt, cached.RenewAt
+			return m.token, nil
+		}
+	}
+
+	if m.token != "" {
+		logger.Log.Info("Renewing access token", zap.Time("ExpiresAt", m.expiresAt))
+	}
+	if err := m.login(); err != nil {
+		return "", err
+	}
+	return m.token, nil
+}
+
+// Invalidate descarta token si sigue siendo el actual (la API lo rechazó
+// con 401), para que el próximo Token() pida otro.
+func (m *Manager) Invalidate(token string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.token == token {
+		logger.Log.Warn("Access token rejected, requesting a new one")
+		m.token = ""
+		m.expiresAt, m.renewAt = time.Time{}, time.Time{}
+		// Un token rechazado tampoco sirve en la cache
+		if m.opts.CachePath != "" {
+			removeCache(m.opts.CachePath)
+		}
+	}
+}
+
+// tokenResponse es la respuesta de /oauth/token.
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// login pide un token con client credentials. Se llama con m.mu tomado.
+func (m *Manager) login() error {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	req, err := http.NewRequest(http.MethodPost, m.loginURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(m.opts.ClientID, m.opts.ClientSecret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	requested := time.Now()
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("requesting access token: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading access token: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("requesting access token: status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var token tokenResponse
+	if err := json.Unmarshal(body, &token); err != nil {
+		return fmt.Errorf("decoding access token: %w", err)
+	}
+	if token.AccessToken == "" {
+		return fmt.Errorf("requesting access token: response has no access_token")
+	}
+
+	// El vencimiento se cuenta desde que se pidió, no desde que llegó
+	lifetime := time.Duration(token.ExpiresIn) * time.Second
+	m.token = token.AccessToken
+	m.expiresAt = requested.Add(lifetime)
+	m.renewAt = m.expiresAt.Add(-min(m.opts.RenewBefore, lifetime/2))
+	logger.Log.Info("Access token obtained", zap.Time("ExpiresAt", m.expiresAt), zap.Time("RenewAt", m.renewAt))
+
+	if m.opts.CachePath != "" {
+		err := saveCache(m.opts, cachedToken{AccessToken: m.token, ExpiresAt: m.expiresAt, RenewAt: m.renewAt})
+		if err != nil {
+			logger.Log.Warn("Failed to write token cache", zap.String("Path", m.opts.CachePath), zap.Error(err))
+		}
+	}
+	return nil
+}
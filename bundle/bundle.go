@@ -0,0 +1,358 @@
+// Package bundle escribe y verifica paquetes (tar.gz o zip) de grabaciones
+// descargadas, cada uno con un manifest.json que lista sus archivos con
+// tamaño y SHA-256.
+package bundle
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Formatos de paquete.
+const (
+	FormatTarGz = "tar.gz"
+	FormatZip   = "zip"
+)
+
+// ManifestName es el nombre del manifiesto dentro de cada paquete.
+const ManifestName = "manifest.json"
+
+// ManifestSchemaVersion es la versión del formato del manifiesto; sigue la
+// misma regla que los sidecars (agregar campos no la cambia).
+const ManifestSchemaVersion = 1
+
+// Manifest describe el contenido de un paquete.
+type Manifest struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	Kind          string    `json:"kind"` // "package"
+	CreatedAt     time.Time `json:"createdAt"`
+	Archive       string    `json:"archive"`
+	Group         string    `json:"group"`
+	Part          int       `json:"part"`
+	Parts         int       `json:"parts"`
+	Recordings    int       `json:"recordings"`
+	Files         []File    `json:"files"`
+}
+
+// File es un archivo del paquete. Path es su clave en el storage, que se
+// conserva como ruta dentro del paquete.
+type File struct {
+	Path           string `json:"path"`
+	Size           int64  `json:"size"`
+	SHA256         string `json:"sha256"`
+	RecordingID    string `json:"recordingId,omitempty"`
+	ConversationID string `json:"conversationId,omitempty"`
+	// source es la ruta en disco del archivo a empaquetar
+	source string
+}
+
+// NewFile describe un archivo en disco (source) que se guarda como path.
+// Size y SHA256 se completan al escribir el paquete.
+func NewFile(source, path, recordingID, conversationID string) File {
+	return File{Path: path, RecordingID: recordingID, ConversationID: conversationID, source: source}
+}
+
+// Extension devuelve la extensión de los paquetes de format.
+func Extension(format string) (string, error) {
+	switch format {
+	case FormatTarGz:
+		return ".tar.gz", nil
+	case FormatZip:
+		return ".zip", nil
+	}
+	return "", fmt.Errorf("unknown package format %q (use tar.gz or zip)", format)
+}
+
+// FormatOf devuelve el formato de un paquete según su extensión.
+func FormatOf(path string) (string, error) {
+	switch {
+	case strings.HasSuffix(path, ".tar.gz"):
+		return FormatTarGz, nil
+	case strings.HasSuffix(path, ".zip"):
+		return FormatZip, nil
+	}
+	return "", fmt.Errorf("unknown package format for %s", filepath.Base(path))
+}
+
+// Write escribe el paquete path con los archivos de manifest.Files y, al
+// final, el manifiesto con el tamaño y hash de cada uno calculados al
+// copiarlos. Escribe en "<path>.part" y renombra al terminar.
+func Write(path, format string, manifest *Manifest) error {
+	part := path + ".part"
+	out, err := os.Create(part)
+	if err != nil {
+		return err
+	}
+	err = write(out, format, manifest)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(part)
+		return err
+	}
+	return os.Rename(part, path)
+}
+
+func write(out io.Writer, format string, manifest *Manifest) error {
+	var (
+		add   func(name string, size int64, modTime time.Time) (io.Writer, error)
+		close func() error
+	)
+	switch format {
+	case FormatTarGz:
+		gz := gzip.NewWriter(out)
+		tw := tar.NewWriter(gz)
+		add = func(name string, size int64, modTime time.Time) (io.Writer, error) {
+			return tw, tw.WriteHeader(&tar.Header{Name: name, Size: size, Mode: 0644, ModTime: modTime, Typeflag: tar.TypeReg})
+		}
+		close = func() error {
+			if err := tw.Close(); err != nil {
+				return err
+			}
+			return gz.Close()
+		}
+	case FormatZip:
+		zw := zip.NewWriter(out)
+		add = func(name string, size int64, modTime time.Time) (io.Writer, error) {
+			return zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime})
+		}
+		close = zw.Close
+	default:
+		return fmt.Errorf("unknown package format %q", format)
+	}
+
+	for i := range manifest.Files {
+		file := &manifest.Files[i]
+		if err := copyFile(file, add); err != nil {
+			return fmt.Errorf("adding %s: %w", file.Path, err)
+		}
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	w, err := add(ManifestName, int64(len(data)), manifest.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return close()
+}
+
+func copyFile(file *File, add func(string, int64, time.Time) (io.Writer, error)) error {
+	in, err := os.Open(file.source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	w, err := add(file.Path, info.Size(), info.ModTime())
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, hash), in)
+	if err != nil {
+		return err
+	}
+	if n != info.Size() {
+		return fmt.Errorf("file changed while packaging (%d of %d bytes)", n, info.Size())
+	}
+	file.Size, file.SHA256 = n, hex.EncodeToString(hash.Sum(nil))
+	return nil
+}
+
+// Verify relee el paquete path y comprueba que tenga exactamente los
+// archivos de su manifiesto, con el tamaño y hash registrados. Devuelve el
+// manifiesto leído.
+func Verify(path, format string) (*Manifest, error) {
+	seen := make(map[string]File)
+	var manifestData []byte
+	visit := func(name string, r io.Reader) error {
+		if name == ManifestName {
+			data, err := io.ReadAll(r)
+			manifestData = data
+			return err
+		}
+		hash := sha256.New()
+		n, err := io.Copy(hash, r)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", name, err)
+		}
+		if _, dup := seen[name]; dup {
+			return fmt.Errorf("duplicate entry %s", name)
+		}
+		seen[name] = File{Path: name, Size: n, SHA256: hex.EncodeToString(hash.Sum(nil))}
+		return nil
+	}
+
+	var err error
+	switch format {
+	case FormatTarGz:
+		err = walkTarGz(path, visit)
+	case FormatZip:
+		err = walkZip(path, visit)
+	default:
+		err = fmt.Errorf("unknown package format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if manifestData == nil {
+		return nil, errors.New("package has no " + ManifestName)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", ManifestName, err)
+	}
+	if len(manifest.Files) != len(seen) {
+		return nil, fmt.Errorf("package has %d files, manifest lists %d", len(seen), len(manifest.Files))
+	}
+	for _, file := range manifest.Files {
+		got, ok := seen[file.Path]
+		if !ok {
+			return nil, fmt.Errorf("%s is missing from the package", file.Path)
+		}
+		if got.Size != file.Size || got.SHA256 != file.SHA256 {
+			return nil, fmt.Errorf("%s does not match the manifest", file.Path)
+		}
+	}
+	return &manifest, nil
+}
+
+// ReadFiles devuelve el contenido de los archivos names del paquete path.
+// Los que no están en el paquete no aparecen en el resultado.
+func ReadFiles(path string, names []string) (map[string][]byte, error) {
+	format, err := FormatOf(path)
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+	files := make(map[string][]byte)
+	visit := func(name string, r io.Reader) error {
+		if !wanted[name] {
+			return nil
+		}
+		data, err := io.ReadAll(r)
+		files[name] = data
+		return err
+	}
+	if format == FormatZip {
+		err = walkZip(path, visit)
+	} else {
+		err = walkTarGz(path, visit)
+	}
+	return files, err
+}
+
+func walkTarGz(path string, visit func(string, io.Reader) error) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := visit(header.Name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func walkZip(path string, visit func(string, io.Reader) error) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	for _, file := range zr.File {
+		if strings.HasSuffix(file.Name, "/") {
+			continue
+		}
+		r, err := file.Open()
+		if err != nil {
+			return err
+		}
+		err = visit(file.Name, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UniquePath devuelve path o, si ya existe, path con -2, -3... antes de la
+// extensión ext.
+func UniquePath(path, ext string) string {
+	base := strings.TrimSuffix(path, ext)
+	for i := 2; ; i++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+		path = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+}
+
+// WriteManifest guarda una copia del manifiesto junto al paquete
+// (<paquete>.manifest.json) para consultarlo sin abrirlo.
+func WriteManifest(path string, manifest *Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+".manifest.json", append(data, '\n'), 0644)
+}
+
+// SHA256 calcula el hash del paquete y lo guarda en "<paquete>.sha256" en
+// formato sha256sum.
+func SHA256(path string) (string, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, in); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	line := fmt.Sprintf("%s  %s\n", sum, filepath.Base(path))
+	return sum, os.WriteFile(path+".sha256", []byte(line), 0644)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/goDownloadRecording/config"
+	query "github.com/goDownloadRecording/conversation_query"
+	"github.com/goDownloadRecording/journal"
+	"github.com/goDownloadRecording/logger"
+	"github.com/goDownloadRecording/watermark"
+	sdk "github.com/mypurecloud/platform-client-sdk-go/v157/platformclientv2"
+	"go.uber.org/zap"
+)
+
+// runDaemon descarga en forma continua las grabaciones nuevas: cada
+// cfg.DaemonInterval consulta el intervalo que va desde el watermark (menos
+// el solapamiento) hasta ahora (menos la demora) y descarga lo que no esté
+// en el índice. El watermark sólo avanza cuando el ciclo termina sin nada
+// pendiente; si falla, el ciclo siguiente vuelve a cubrir el mismo tramo. Lo
+// que sigue pendiente después de cfg.MaxBlockedCycles ciclos se saltea.
+func runDaemon(ctx context.Context, args []string) error {
+	fs, cfg, err := newFlagSet("daemon")
+	if err != nil {
+		return err
+	}
+	var queryOpts query.QueryOptions
+	queryOpts.RegisterFlags(fs)
+	since := fs.String("since", "24h", "start of the first sync when there is no watermark yet: a time (RFC 3339) or a duration before now")
+	once := fs.Bool("once", false, "run a single sync cycle and exit (e.g. from cron)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if queryOpts.StartTime != "" || queryOpts.EndTime != "" || queryOpts.RawFile != "" {
+		return fmt.Errorf("the daemon takes the interval from the watermark: -start, -end and -query-raw are not allowed")
+	}
+	if !*once && cfg.DaemonInterval <= 0 {
+		return fmt.Errorf("daemon-interval must be positive")
+	}
+	if cfg.MaxBlockedCycles < 0 {
+		return fmt.Errorf("max-blocked-cycles cannot be negative")
+	}
+	initial, err := parseSince(*since, time.Now())
+	if err != nil {
+		return err
+	}
+
+	// Los filtros se validan una vez al arrancar y no en cada ciclo
+	check := queryOpts
+	check.StartTime, check.EndTime = initial.Format(time.RFC3339), time.Now().Format(time.RFC3339)
+	if _, err := query.BuildConversationQuery(check); err != nil {
+		return err
+	}
+
+	if err := authorize(ctx, cfg); err != nil {
+		return err
+	}
+
+	path := cfg.Watermark()
+	// El daemon lleva su propio journal (y summary.json), junto al watermark:
+	// cada ciclo lo reemplaza, y el de download puede tener una corrida
+	// pendiente de resume
+	if cfg.JournalPath == "" {
+		cfg.JournalPath = filepath.Join(filepath.Dir(path), "daemon", "journal.jsonl")
+	}
+	logger.Log.Info("Starting daemon",
+		zap.String("Watermark", path),
+		zap.String("Journal", cfg.Journal()),
+		zap.Duration("Interval", cfg.DaemonInterval),
+		zap.Duration("Overlap", cfg.WatermarkOverlap),
+		zap.Duration("Delay", cfg.WatermarkDelay))
+	for {
+		err := syncOnce(ctx, cfg, queryOpts, path, initial)
+		if *once || ctx.Err() != nil {
+			return err
+		}
+		if err != nil {
+			logger.Log.Error("Sync cycle failed, watermark not advanced", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(cfg.DaemonInterval):
+		}
+	}
+}
+
+// syncOnce ejecuta un ciclo de sincronización incremental contra el
+// watermark en path (initial si todavía no existe) y, si no quedó nada
+// pendiente, lo avanza hasta el fin del intervalo consultado. Requiere el
+// SDK ya autorizado.
+func syncOnce(ctx context.Context, cfg *config.Config, queryOpts query.QueryOptions, path string, initial time.Time) error {
+	state, err := watermark.Load(path)
+	if err != nil {
+		return err
+	}
+	start := initial
+	if state != nil {
+		start = state.Watermark.Add(-cfg.WatermarkOverlap)
+	}
+	end := time.Now().Add(-cfg.WatermarkDelay).UTC().Truncate(time.Second)
+	if !end.After(start) {
+		logger.Log.Info("Nothing to sync yet", zap.Time("Start", start), zap.Time("End", end))
+		return nil
+	}
+
+	queryOpts.StartTime, queryOpts.EndTime = start.UTC().Format(time.RFC3339), end.Format(time.RFC3339)
+	queryConversation, err := query.BuildConversationQuery(queryOpts)
+	if err != nil {
+		return err
+	}
+	logger.Log.Info("Starting sync cycle", zap.Time("Start", start), zap.Time("End", end), zap.String("Watermark", path))
+
+	j, err := startJournal(cfg, queryConversation)
+	if err != nil {
+		return err
+	}
+	defer j.Close()
+	opts, err := downloadOptions(ctx, cfg, j)
+	if err != nil {
+		return err
+	}
+	defer opts.Index.Close()
+
+	cycleStart := time.Now()
+	err = runPipeline(ctx, sdk.NewAnalyticsApi(), sdk.NewRecordingApi(), cfg, queryConversation, opts)
+	finishRun(ctx, cfg, j, cycleStart)
+	if err != nil {
+		return err
+	}
+
+	// Sólo un ciclo completo avanza el watermark: lo que quedó pendiente se
+	// vuelve a consultar en el próximo, y lo ya descargado lo saltea el índice
+	run, err := journal.Load(j.Path())
+	if err != nil {
+		return fmt.Errorf("loading journal: %w", err)
+	}
+	summary := run.Summary()
+	if !run.QueryComplete {
+		return fmt.Errorf("the query did not complete")
+	}
+	next := watermark.State{Watermark: start}
+	if state != nil {
+		next = *state
+	}
+	if pending := pendingItems(run); len(pending) > 0 {
+		// Una grabación que falla siempre no puede frenar el watermark para
+		// siempre: cada una se saltea después de cfg.MaxBlockedCycles ciclos
+		skipped, blocking := next.Block(pending, cfg.MaxBlockedCycles, time.Now().UTC())
+		for _, item := range skipped {
+			logger.Log.Warn("Skipping item that kept the watermark back",
+				zap.String("RecordingID", item.RecordingID),
+				zap.String("ConversationID", item.ConversationID),
+				zap.Int("Cycles", item.Cycles),
+				zap.String("Error", item.Error))
+		}
+		if len(blocking) > 0 {
+			// El watermark avanza hasta la conversación pendiente más vieja,
+			// así una falla nueva en cada ciclo no lo frena para siempre
+			next.UpdatedAt = time.Time{}
+			limit, ok := watermark.Earliest(blocking)
+			if !ok || !limit.After(next.Watermark) {
+				// Sin watermark previo se guarda el inicio del ciclo, para
+				// llevar la cuenta de los ciclos bloqueados
+				if err := watermark.Save(path, next); err != nil {
+					return fmt.Errorf("saving watermark: %w", err)
+				}
+				return fmt.Errorf("%d of %d recordings and %d conversations were left pending",
+					summary.Recordings-summary.Stages[journal.StageVerified], summary.Recordings, summary.PendingMetadata)
+			}
+			next.Watermark, next.Interval = limit, queryOpts.StartTime+"/"+queryOpts.EndTime
+			next.Conversations, next.Recordings = summary.Conversations, summary.Recordings
+			if err := watermark.Save(path, next); err != nil {
+				return fmt.Errorf("saving watermark: %w", err)
+			}
+			logger.Log.Warn("Watermark held back by pending items",
+				zap.Time("Watermark", limit),
+				zap.Int("Pending", len(blocking)),
+				zap.Int("Conversations", summary.Conversations),
+				zap.Int("Recordings", summary.Recordings))
+			return nil
+		}
+	}
+	if state != nil && state.Watermark.After(end) {
+		// Con una demora mayor que la del ciclo anterior el watermark no retrocede
+		end = state.Watermark
+	}
+	next.Watermark, next.Interval = end, queryOpts.StartTime+"/"+queryOpts.EndTime
+	next.Conversations, next.Recordings = summary.Conversations, summary.Recordings
+	next.UpdatedAt, next.Blocked = time.Time{}, nil
+	if err := watermark.Save(path, next); err != nil {
+		return fmt.Errorf("saving watermark: %w", err)
+	}
+	logger.Log.Info("Watermark advanced",
+		zap.Time("Watermark", end),
+		zap.Int("Conversations", summary.Conversations),
+		zap.Int("Recordings", summary.Recordings))
+	return nil
+}
+
+// pendingItems devuelve lo que quedó sin terminar en la corrida: las
+// conversaciones sin metadata y las grabaciones sin verificar.
+func pendingItems(run *journal.State) []watermark.Skipped {
+	var pending []watermark.Skipped
+	for _, id := range run.PendingMetadata() {
+		pending = append(pending, watermark.Skipped{ConversationID: id, Start: run.ConversationStarts[id], Error: "metadata not fetched"})
+	}
+	for _, rec := range run.Recordings {
+		if rec.Stage != journal.StageVerified {
+			pending = append(pending, watermark.Skipped{RecordingID: rec.RecordingID, ConversationID: rec.ConversationID, Start: run.ConversationStarts[rec.ConversationID], Error: rec.LastError})
+		}
+	}
+	sort.Slice(pending, func(a, b int) bool {
+		return pending[a].ConversationID+pending[a].RecordingID < pending[b].ConversationID+pending[b].RecordingID
+	})
+	return pending
+}
+
+// parseSince interpreta -since: un instante RFC 3339 o una duración hacia
+// atrás desde now (p.ej. 24h).
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return time.Time{}, fmt.Errorf("invalid -since %q (use a time like 2025-01-01T00:00:00-03:00 or a duration like 24h)", value)
+	}
+	return now.Add(-d), nil
+}
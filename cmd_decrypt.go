@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/goDownloadRecording/crypt"
+	"github.com/goDownloadRecording/logger"
+	"go.uber.org/zap"
+)
+
+// runDecrypt descifra las grabaciones guardadas con cifrado en reposo
+// (archivos *.enc) de las rutas indicadas, por defecto la carpeta de
+// descargas. Cada archivo se descifra con la clave de su KeyID, de modo que
+// se pueden pasar las claves anteriores a una rotación junto con la actual.
+func runDecrypt(args []string) error {
+	fs, cfg, err := newFlagSet("decrypt")
+	if err != nil {
+		return err
+	}
+	keys := fs.String("key", "", "comma-separated key files: 32-byte keys or RSA private keys in PEM (default the encryption-key)")
+	out := fs.String("out", "", "directory for the decrypted files, keeping the folder structure (default next to each encrypted file)")
+	list := fs.Bool("list", false, "only print the key ID of each encrypted file")
+	remove := fs.Bool("remove", false, "delete each encrypted file after decrypting it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	roots := fs.Args()
+	if len(roots) == 0 {
+		roots = []string{cfg.DownloadPath}
+	}
+
+	var keyring *crypt.Keyring
+	if !*list {
+		paths := splitList(*keys)
+		if len(paths) == 0 && cfg.EncryptionKeyFile != "" {
+			paths = []string{cfg.EncryptionKeyFile}
+		}
+		if len(paths) == 0 {
+			return fmt.Errorf("-key is required to decrypt")
+		}
+		if keyring, err = crypt.LoadKeyring(paths); err != nil {
+			return err
+		}
+		logger.Log.Info("Loaded decryption keys", zap.Strings("KeyIDs", keyring.KeyIDs()))
+	}
+
+	var decrypted, failed int
+	for _, root := range roots {
+		err := filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() || !strings.HasSuffix(path, crypt.Extension) {
+				return nil
+			}
+			if *list {
+				info, err := readInfo(path)
+				if err != nil {
+					return fmt.Errorf("%s: %w", path, err)
+				}
+				fmt.Printf("%s\t%s\t%s\n", info.KeyID, info.Wrap, path)
+				return nil
+			}
+
+			target := strings.TrimSuffix(path, crypt.Extension)
+			if *out != "" {
+				rel, err := filepath.Rel(root, target)
+				if err != nil {
+					return err
+				}
+				target = filepath.Join(*out, rel)
+			}
+			if err := decryptFile(keyring, path, target); err != nil {
+				logger.Log.Error("Failed to decrypt file", zap.String("File", path), zap.Error(err))
+				failed++
+				return nil
+			}
+			decrypted++
+			if *remove {
+				if err := os.Remove(path); err != nil {
+					logger.Log.Warn("Failed to remove encrypted file", zap.String("File", path), zap.Error(err))
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if *list {
+		return nil
+	}
+	logger.Log.Info("Decryption finished", zap.Int("Decrypted", decrypted), zap.Int("Failed", failed))
+	if failed > 0 {
+		return fmt.Errorf("%d files could not be decrypted", failed)
+	}
+	return nil
+}
+
+// decryptFile descifra path en target. El resultado se escribe en
+// "<target>.part" y sólo se renombra si el archivo completo se autenticó.
+func decryptFile(keyring *crypt.Keyring, path, target string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	plain, _, err := keyring.NewReader(in)
+	if errors.Is(err, crypt.ErrUnknownKey) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("reading encrypted file: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+	part := target + ".part"
+	outFile, err := os.Create(part)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(outFile, plain); err != nil {
+		outFile.Close()
+		os.Remove(part)
+		return err
+	}
+	if err := outFile.Close(); err != nil {
+		os.Remove(part)
+		return err
+	}
+	return os.Rename(part, target)
+}
+
+func readInfo(path string) (crypt.Info, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return crypt.Info{}, err
+	}
+	defer in.Close()
+	return crypt.ReadInfo(in)
+}
+
+// splitList separa una lista separada por comas, sin elementos vacíos.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
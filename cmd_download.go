@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/goDownloadRecording/archive"
+	"github.com/goDownloadRecording/config"
+	query "github.com/goDownloadRecording/conversation_query"
+	"github.com/goDownloadRecording/crypt"
+	"github.com/goDownloadRecording/functions"
+	"github.com/goDownloadRecording/journal"
+	"github.com/goDownloadRecording/layout"
+	"github.com/goDownloadRecording/logger"
+	sdk "github.com/mypurecloud/platform-client-sdk-go/v157/platformclientv2"
+	"go.uber.org/zap"
+)
+
+// runDownload ejecuta el flujo completo: query, metadata, batch y descarga.
+func runDownload(ctx context.Context, args []string) error {
+	fs, cfg, err := newFlagSet("download")
+	if err != nil {
+		return err
+	}
+	var queryOpts query.QueryOptions
+	queryOpts.RegisterFlags(fs)
+	noPrompt := fs.Bool("no-prompt", false, "never prompt on stdin for missing values")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	logger.Log.Info("Starting Genesys Download Recording")
+
+	// Construir la query
+	logger.Log.Info("Building conversation query")
+	queryConversation, err := buildQuery(fs, &queryOpts, *noPrompt)
+	if err != nil {
+		return err
+	}
+
+	// Configuración y autorización del SDK
+	if err := authorize(ctx, cfg); err != nil {
+		return err
+	}
+
+	// Instanciar APIs
+	analyticsApi := sdk.NewAnalyticsApi()
+	recordApi := sdk.NewRecordingApi()
+
+	// Journal de la corrida: permite retomarla con "resume" si se corta
+	j, err := startJournal(cfg, queryConversation)
+	if err != nil {
+		return err
+	}
+	defer j.Close()
+
+	opts, err := downloadOptions(ctx, cfg, j)
+	if err != nil {
+		return err
+	}
+	defer opts.Index.Close()
+
+	start := time.Now() // Marca el inicio justo después de ingresar datos
+	defer finishRun(ctx, cfg, j, start)
+
+	if err := runPipeline(ctx, analyticsApi, recordApi, cfg, queryConversation, opts); err != nil {
+		return err
+	}
+
+	elapsed := time.Since(start)
+	logger.Log.Info("Tiempo total de ejecución", zap.Duration("Duración:", elapsed))
+	logger.Log.Info("Process completed successfully")
+	return nil
+}
+
+// startJournal crea el journal de una corrida nueva y registra la query.
+func startJournal(cfg *config.Config, queryConversation sdk.Conversationquery) (*journal.Journal, error) {
+	path := cfg.Journal()
+	if previous, err := journal.Load(path); err == nil && previous.HasPending() {
+		logger.Log.Warn("Previous run did not finish; its journal is replaced (use resume to continue it instead)", zap.String("Journal", path))
+	}
+
+	j, err := journal.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("creating journal: %w", err)
+	}
+	queryJSON, err := json.Marshal(queryConversation)
+	if err != nil {
+		j.Close()
+		return nil, err
+	}
+	if err := j.Record(journal.Entry{Stage: journal.StageRun, Query: queryJSON}); err != nil {
+		j.Close()
+		return nil, fmt.Errorf("writing journal: %w", err)
+	}
+	return j, nil
+}
+
+// runConversationQuery obtiene todas las conversaciones (sync paginado o
+// job asincrónico según cfg.QueryMode) y las registra en el journal.
+func runConversationQuery(ctx context.Context, analyticsApi *sdk.AnalyticsApi, cfg *config.Config, queryConversation sdk.Conversationquery, j *journal.Journal) ([]string, error) {
+	// Obtener todas las conversaciones paginadas
+	logger.Log.Info("Starting paginated query", zap.String("Mode", cfg.QueryMode))
+	results, err := query.GetConversations(ctx, analyticsApi, queryConversation, queryExecOptions(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("conversation query: %w", err)
+	}
+	logger.Log.Info("Successfully retrieved conversation data", zap.Int("TotalConversations", len(results)))
+
+	// Extraer IDs de conversación
+	var conversationIDs []string
+	for _, conv := range results {
+		if conv.ConversationId != nil {
+			conversationIDs = append(conversationIDs, *conv.ConversationId)
+			if err := j.Record(journal.Entry{Stage: journal.StageQueried, ConversationID: *conv.ConversationId, ConversationStart: conv.ConversationStart}); err != nil {
+				return nil, fmt.Errorf("writing journal: %w", err)
+			}
+		}
+	}
+	if err := j.Record(journal.Entry{Stage: journal.StageQueryComplete}); err != nil {
+		return nil, fmt.Errorf("writing journal: %w", err)
+	}
+	return conversationIDs, nil
+}
+
+// queryExecOptions arma las opciones de ejecución de la consulta.
+func queryExecOptions(cfg *config.Config) query.ExecOptions {
+	return query.ExecOptions{
+		Mode:    cfg.QueryMode,
+		Timeout: cfg.QueryJobTimeout,
+		Window:  cfg.QueryWindow,
+		Workers: cfg.QueryWorkers,
+	}
+}
+
+// downloadOptions abre el storage y el índice del archivo y arma las
+// opciones de descarga comunes a los comandos. El llamador cierra Index.
+// Requiere el SDK ya autorizado.
+func downloadOptions(ctx context.Context, cfg *config.Config, j *journal.Journal) (functions.DownloadOptions, error) {
+	pathLayout, err := layout.New(cfg.FolderTemplate, cfg.FileTemplate, cfg.PathTimezone)
+	if err != nil {
+		return functions.DownloadOptions{}, err
+	}
+	format, err := functions.ValidateFormat(cfg.RecordingFormat)
+	if err != nil {
+		return functions.DownloadOptions{}, err
+	}
+	encrypter, err := crypt.NewEncrypter(cfg.EncryptionKeyFile, cfg.EncryptionRecipient)
+	if err != nil {
+		return functions.DownloadOptions{}, fmt.Errorf("loading encryption key: %w", err)
+	}
+	if info := encrypter.Info(); info != nil {
+		logger.Log.Info("Recordings are encrypted at rest", zap.String("KeyID", info.KeyID), zap.String("Wrap", info.Wrap))
+	}
+	backend, err := openStorage(ctx, cfg)
+	if err != nil {
+		return functions.DownloadOptions{}, err
+	}
+	index, err := archive.Open(cfg.Index())
+	if err != nil {
+		return functions.DownloadOptions{}, fmt.Errorf("opening archive index: %w", err)
+	}
+	var speechApi *sdk.SpeechTextAnalyticsApi
+	if cfg.SpeechTranscripts {
+		speechApi = sdk.NewSpeechTextAnalyticsApi()
+	}
+	exclude := make(map[string]bool)
+	if !cfg.ScreenRecordings {
+		exclude["screen"] = true
+	}
+	return functions.DownloadOptions{
+		Storage:       backend,
+		MaxWorkers:    cfg.MaxDownloadWorkers,
+		Retries:       cfg.DownloadRetries,
+		RetryBackoff:  cfg.DownloadBackoff,
+		Journal:       j,
+		Index:         index,
+		Details:       functions.NewDetails(sdk.NewAnalyticsApi(), sdk.NewRecordingApi()),
+		Paths:         functions.NewPaths(pathLayout, functions.NewNames(sdk.NewRoutingApi(), sdk.NewAuthorizationApi()), backend, index),
+		Format:        format,
+		FormatRetries: cfg.PollRetries,
+		RecordingApi:  sdk.NewRecordingApi(),
+		SpeechApi:     speechApi,
+		Encryption:    encrypter,
+		ExcludeMedia:  exclude,
+	}, nil
+}
+
+// downloadBatchJobs hace polling de cada job en paralelo y descarga sus
+// grabaciones a medida que quedan listas. Si only no es nil, sólo se
+// descargan los recordingId presentes en ese conjunto. Devuelve los jobs que
+// no se pudieron consultar; los que se cortaron por cancelar ctx no cuentan.
+func downloadBatchJobs(ctx context.Context, recordApi *sdk.RecordingApi, cfg *config.Config, jobIDs []string, only map[string]bool, opts functions.DownloadOptions) []string {
+	var (
+		failedJobs []string
+		mu         sync.Mutex
+	)
+	var wg sync.WaitGroup
+
+	for _, jobID := range jobIDs {
+		wg.Add(1)
+
+		go func(jobID string) {
+			defer wg.Done()
+			if err := downloadBatchJob(ctx, recordApi, cfg, jobID, only, opts); err != nil {
+				mu.Lock()
+				failedJobs = append(failedJobs, jobID)
+				mu.Unlock()
+			}
+		}(jobID)
+	}
+
+	wg.Wait()
+	return failedJobs
+}
+
+// downloadBatchJob espera a que termine un batch job y descarga sus
+// grabaciones (sólo las de only, si no es nil). Devuelve error si el job no
+// se pudo consultar o no devolvió resultados, salvo que se haya cancelado ctx.
+func downloadBatchJob(ctx context.Context, recordApi *sdk.RecordingApi, cfg *config.Config, jobID string, only map[string]bool, opts functions.DownloadOptions) error {
+	batchStatus, err := functions.PollBatchJobUntilReady(ctx, recordApi, jobID, cfg.PollRetries, cfg.PollInterval, opts.Journal)
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err != nil {
+		logger.Log.Error("Error polling batch job status", zap.String("BatchID", jobID), zap.Error(err))
+		return err
+	}
+	if batchStatus == nil || batchStatus.Results == nil {
+		logger.Log.Warn("No results available in batch job", zap.String("BatchID", jobID))
+		return fmt.Errorf("batch job %s returned no results", jobID)
+	}
+
+	if only != nil {
+		var pending []sdk.Batchdownloadjobresult
+		for _, item := range *batchStatus.Results {
+			if item.RecordingId != nil && only[*item.RecordingId] {
+				pending = append(pending, item)
+			}
+		}
+		batchStatus.Results = &pending
+	}
+
+	logger.Log.Info("Descargando grabaciones del batch", zap.String("BatchID", jobID))
+
+	err = functions.DownloadAllReadyRecordings(ctx, batchStatus, opts)
+	if err != nil && ctx.Err() == nil {
+		logger.Log.Error("Error downloading recordings", zap.String("BatchID", jobID), zap.Error(err))
+	}
+	return nil
+}
+
+// finishRun cierra una corrida de download o resume: si se canceló ctx lo
+// registra en el journal, y deja el resumen del estado en el log y en
+// summary.json junto al journal.
+func finishRun(ctx context.Context, cfg *config.Config, j *journal.Journal, start time.Time) {
+	if ctx.Err() != nil {
+		if err := j.Record(journal.Entry{Stage: journal.StageInterrupted, Error: context.Cause(ctx).Error()}); err != nil {
+			logger.Log.Warn("Failed to write journal entry", zap.String("Stage", string(journal.StageInterrupted)), zap.Error(err))
+		}
+	}
+
+	state, err := journal.Load(j.Path())
+	if err != nil {
+		logger.Log.Warn("Failed to load journal for run summary", zap.Error(err))
+		return
+	}
+	summary := state.Summary()
+	logger.Log.Info("Run summary",
+		zap.Int("Conversations", summary.Conversations),
+		zap.Int("PendingMetadata", summary.PendingMetadata),
+		zap.Int("Recordings", summary.Recordings),
+		zap.Any("Stages", summary.Stages),
+		zap.Int("Failed", summary.Failed),
+		zap.Bool("Done", summary.Done),
+		zap.Bool("Interrupted", summary.Interrupted),
+		zap.Duration("Duration", time.Since(start)))
+
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err == nil {
+		err = os.WriteFile(cfg.Summary(), data, 0644)
+	}
+	if err != nil {
+		logger.Log.Warn("Failed to write run summary", zap.String("Path", cfg.Summary()), zap.Error(err))
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goDownloadRecording/archive"
+	"github.com/goDownloadRecording/bundle"
+	"github.com/goDownloadRecording/functions"
+	"github.com/goDownloadRecording/logger"
+	"go.uber.org/zap"
+)
+
+// runPackage empaqueta las grabaciones descargadas (las del índice que
+// todavía no están en un paquete) en tar.gz o zip con su manifiesto.
+func runPackage(args []string) error {
+	fs, cfg, err := newFlagSet("package")
+	if err != nil {
+		return err
+	}
+	format := fs.String("package-format", bundle.FormatTarGz, "package format: tar.gz or zip")
+	groupBy := fs.String("group", functions.GroupByDay, "one package per conversation day, per queue, or one for the whole run: day, queue or run")
+	maxSize := fs.String("max-size", "", "split packages larger than this (uncompressed), e.g. 2GB or 500MB (default no limit)")
+	out := fs.String("out", "", "directory for the packages (default <output>/packages)")
+	remove := fs.Bool("remove", false, "delete the loose files of each package once it is verified")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if cfg.Storage != "" && cfg.Storage != "local" {
+		return fmt.Errorf("package is only supported with local storage")
+	}
+	limit, err := parseSize(*maxSize)
+	if err != nil {
+		return err
+	}
+	location := time.Local
+	if cfg.PathTimezone != "" {
+		if location, err = time.LoadLocation(cfg.PathTimezone); err != nil {
+			return fmt.Errorf("invalid path timezone %q: %w", cfg.PathTimezone, err)
+		}
+	}
+	if *out == "" {
+		*out = filepath.Join(cfg.DownloadPath, "packages")
+	}
+
+	index, err := archive.Open(cfg.Index())
+	if err != nil {
+		return fmt.Errorf("opening archive index: %w", err)
+	}
+	defer index.Close()
+
+	report, err := functions.PackageDownloads(index, functions.PackageOptions{
+		Root:     cfg.DownloadPath,
+		OutDir:   *out,
+		Format:   *format,
+		GroupBy:  *groupBy,
+		MaxSize:  limit,
+		Remove:   *remove,
+		Location: location,
+	})
+	if report != nil {
+		logger.Log.Info("Packaging finished",
+			zap.Int("Packages", len(report.Packages)),
+			zap.Int("Recordings", report.Recordings),
+			zap.Int("RemovedFiles", report.Removed))
+	}
+	return err
+}
+
+// parseSize interpreta un tamaño en bytes con sufijo opcional KB, MB, GB o
+// TB (potencias de 1024). Vacío o 0 es sin límite.
+func parseSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return 0, nil
+	}
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(value, unit.suffix) {
+			value, multiplier = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix)), unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q (e.g. 2GB or 500MB)", value)
+	}
+	return int64(n * float64(multiplier)), nil
+}
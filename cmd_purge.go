@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/goDownloadRecording/archive"
+	"github.com/goDownloadRecording/functions"
+	"github.com/goDownloadRecording/logger"
+	"github.com/goDownloadRecording/retention"
+	"go.uber.org/zap"
+)
+
+// runPurge aplica la política de retención al archivo local: borra las
+// grabaciones vencidas que no están bajo legal hold y registra cada borrado
+// en el log de auditoría. Con -dry-run sólo imprime qué se borraría.
+func runPurge(args []string) error {
+	fs, cfg, err := newFlagSet("purge")
+	if err != nil {
+		return err
+	}
+	dryRun := fs.Bool("dry-run", false, "only print the recordings that would be deleted")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if cfg.Storage != "" && cfg.Storage != "local" {
+		return fmt.Errorf("purge is only supported with local storage")
+	}
+	if cfg.RetentionPolicy == "" {
+		return fmt.Errorf("-retention-policy is required")
+	}
+	policy, err := retention.Load(cfg.RetentionPolicy)
+	if err != nil {
+		return err
+	}
+
+	index, err := archive.Open(cfg.Index())
+	if err != nil {
+		return fmt.Errorf("opening archive index: %w", err)
+	}
+	defer index.Close()
+
+	opts := functions.PurgeOptions{Root: cfg.DownloadPath, Policy: policy, DryRun: *dryRun}
+	if !*dryRun {
+		audit, err := retention.OpenAudit(cfg.RetentionAudit())
+		if err != nil {
+			return fmt.Errorf("opening retention audit log: %w", err)
+		}
+		defer audit.Close()
+		opts.Audit = audit
+	}
+
+	report, err := functions.PurgeDownloads(index, opts)
+	if report == nil {
+		return err
+	}
+	if *dryRun {
+		for _, item := range report.Items {
+			reason := item.Decision.Rule
+			switch item.Action {
+			case retention.ActionHold:
+				reason = item.Decision.Hold
+			case retention.ActionUnknown:
+				reason = "queues/divisions unknown"
+			}
+			location := item.Entry.Path
+			if item.Entry.Bundle != "" {
+				location = item.Entry.Bundle + ":" + location
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\n", item.Action, item.Decision.ExpiresAt.Format(time.DateOnly), reason, location)
+		}
+	}
+	logger.Log.Info("Purge finished",
+		zap.Bool("DryRun", *dryRun),
+		zap.Int("Deleted", report.Deleted),
+		zap.Int64("Bytes", report.Bytes),
+		zap.Int("Held", report.Held),
+		zap.Int("Unknown", report.Unknown),
+		zap.Int("InPackage", report.InPackage),
+		zap.Int("Kept", report.Kept),
+		zap.Int("Failed", report.Failed))
+	if err == nil && report.Failed > 0 {
+		err = fmt.Errorf("%d recordings could not be deleted", report.Failed)
+	}
+	return err
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	query "github.com/goDownloadRecording/conversation_query"
+	"github.com/goDownloadRecording/logger"
+	sdk "github.com/mypurecloud/platform-client-sdk-go/v157/platformclientv2"
+	"go.uber.org/zap"
+)
+
+// runQuery ejecuta sólo la consulta de analytics e imprime el resultado en
+// stdout según -format: un conversationId por línea (ids), el detalle
+// completo (json) o nada (none, sólo el total en el log).
+func runQuery(ctx context.Context, args []string) error {
+	fs, cfg, err := newFlagSet("query")
+	if err != nil {
+		return err
+	}
+	var queryOpts query.QueryOptions
+	queryOpts.RegisterFlags(fs)
+	noPrompt := fs.Bool("no-prompt", false, "never prompt on stdin for missing values")
+	format := fs.String("format", "ids", "stdout output: ids (one conversationId per line), json (full details) or none")
+	asJSON := fs.Bool("json", false, "same as -format json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *asJSON {
+		*format = "json"
+	}
+	if *format != "ids" && *format != "json" && *format != "none" {
+		return fmt.Errorf("invalid -format %q (use ids, json or none)", *format)
+	}
+
+	queryConversation, err := buildQuery(fs, &queryOpts, *noPrompt)
+	if err != nil {
+		return err
+	}
+	if err := authorize(ctx, cfg); err != nil {
+		return err
+	}
+
+	results, err := query.GetConversations(ctx, sdk.NewAnalyticsApi(), queryConversation, queryExecOptions(cfg))
+	if err != nil {
+		return fmt.Errorf("conversation query: %w", err)
+	}
+	logger.Log.Info("Successfully retrieved conversation data", zap.Int("TotalConversations", len(results)))
+
+	switch *format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(results)
+	case "ids":
+		for _, conv := range results {
+			if conv.ConversationId != nil {
+				fmt.Fprintln(os.Stdout, *conv.ConversationId)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/goDownloadRecording/functions"
+	"github.com/goDownloadRecording/journal"
+	"github.com/goDownloadRecording/logger"
+	sdk "github.com/mypurecloud/platform-client-sdk-go/v157/platformclientv2"
+	"go.uber.org/zap"
+)
+
+// runResume retoma la última corrida a partir de su journal: termina la
+// query si quedó a medias, completa la metadata pendiente, vuelve a
+// enganchar los batch jobs todavía válidos y reenvía el resto.
+func runResume(ctx context.Context, args []string) error {
+	fs, cfg, err := newFlagSet("resume")
+	if err != nil {
+		return err
+	}
+	jobTTL := fs.Duration("job-ttl", 24*time.Hour, "batch jobs older than this are re-submitted instead of re-attached")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	path := cfg.Journal()
+	state, err := journal.Load(path)
+	if err != nil {
+		return fmt.Errorf("loading journal: %w", err)
+	}
+	if !state.HasPending() {
+		logger.Log.Info("Nothing to resume, previous run completed", zap.String("Journal", path))
+		return nil
+	}
+
+	if err := authorize(ctx, cfg); err != nil {
+		return err
+	}
+	analyticsApi := sdk.NewAnalyticsApi()
+	recordApi := sdk.NewRecordingApi()
+
+	j, err := journal.Append(path)
+	if err != nil {
+		return fmt.Errorf("opening journal: %w", err)
+	}
+	defer j.Close()
+
+	opts, err := downloadOptions(ctx, cfg, j)
+	if err != nil {
+		return err
+	}
+	defer opts.Index.Close()
+
+	start := time.Now()
+	defer finishRun(ctx, cfg, j, start)
+	logger.Log.Info("Resuming previous run", zap.String("Journal", path))
+
+	// 1. Query incompleta: se repite con la query registrada
+	if !state.QueryComplete {
+		var queryConversation sdk.Conversationquery
+		if err := json.Unmarshal(state.Query, &queryConversation); err != nil {
+			return fmt.Errorf("journal has no valid query: %w", err)
+		}
+		conversationIDs, err := runConversationQuery(ctx, analyticsApi, cfg, queryConversation, j)
+		if err != nil {
+			return err
+		}
+		for _, id := range conversationIDs {
+			if _, ok := state.Conversations[id]; !ok {
+				state.Conversations[id] = false
+			}
+		}
+	}
+
+	// 2. Conversaciones sin metadata
+	requests, err := functions.AddConversationRecordingsToBatch(ctx, state.PendingMetadata(), recordApi, cfg.BatchWorkers, j, opts.Details, opts.ExcludeMedia)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		logger.Log.Warn("Continuing despite some metadata fetch errors", zap.Error(err))
+	}
+
+	// 3. Grabaciones con metadata que nunca se enviaron
+	for _, rec := range state.InStage(journal.StageMetadata) {
+		requests = append(requests, batchRequest(rec))
+	}
+
+	// 4. Descargadas sin verificar: se verifican o se vuelven a pedir
+	for _, rec := range state.InStage(journal.StageDownloaded) {
+		if err := functions.VerifyObject(opts.Storage, rec.Path, 0, rec.SHA256); err != nil {
+			logger.Log.Warn("Downloaded recording failed verification, re-submitting", zap.String("RecordingID", rec.RecordingID), zap.Error(err))
+			requests = append(requests, batchRequest(rec))
+			continue
+		}
+		j.Record(journal.Entry{Stage: journal.StageVerified, ConversationID: rec.ConversationID, RecordingID: rec.RecordingID, Path: rec.Path, SHA256: rec.SHA256})
+	}
+
+	// 5. Enviadas sin descargar: se re-engancha el job si sigue vigente
+	jobs := make(map[string][]*journal.RecordingState)
+	only := make(map[string]bool)
+	for _, rec := range state.InStage(journal.StageSubmitted, journal.StageURL) {
+		if rec.JobID == "" || time.Since(rec.SubmittedAt) > *jobTTL {
+			requests = append(requests, batchRequest(rec))
+			continue
+		}
+		jobs[rec.JobID] = append(jobs[rec.JobID], rec)
+		only[rec.RecordingID] = true
+	}
+	if len(jobs) > 0 {
+		var jobIDs []string
+		for jobID := range jobs {
+			jobIDs = append(jobIDs, jobID)
+		}
+		logger.Log.Info("Re-attaching batch jobs", zap.Int("Jobs", len(jobIDs)), zap.Int("Recordings", len(only)))
+		for _, jobID := range downloadBatchJobs(ctx, recordApi, cfg, jobIDs, only, opts) {
+			logger.Log.Warn("Batch job could not be re-attached, re-submitting its recordings", zap.String("BatchID", jobID))
+			for _, rec := range jobs[jobID] {
+				requests = append(requests, batchRequest(rec))
+			}
+		}
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	// 6. Todo lo que no se pudo re-enganchar va en batch nuevos, salvo las
+	// interacciones de texto, que se exportan directamente
+	requests = functions.FilterArchived(requests, opts.Storage, opts.Index, j)
+	media := make(map[string]string)
+	for id, rec := range state.Recordings {
+		if rec.Media != "" {
+			media[id] = rec.Media
+		}
+	}
+	requests, text := functions.SplitTextRecordings(requests, media, opts.Details)
+	if len(text) > 0 {
+		textCh := make(chan sdk.Batchdownloadrequest, len(text))
+		for _, request := range text {
+			textCh <- request
+		}
+		close(textCh)
+		functions.ExportTranscripts(ctx, textCh, nil, opts)
+	}
+	if len(requests) > 0 {
+		results, err := functions.SendBatchRequests(ctx, recordApi, requests, j)
+		if err != nil {
+			return fmt.Errorf("sending batch requests: %w", err)
+		}
+		var jobIDs []string
+		for _, result := range results {
+			jobIDs = append(jobIDs, *result.Id)
+		}
+		downloadBatchJobs(ctx, recordApi, cfg, jobIDs, nil, opts)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if err := j.Record(journal.Entry{Stage: journal.StageDone}); err != nil {
+		return err
+	}
+	logger.Log.Info("Resume completed", zap.Duration("Duration", time.Since(start)))
+	return nil
+}
+
+func batchRequest(rec *journal.RecordingState) sdk.Batchdownloadrequest {
+	return sdk.Batchdownloadrequest{
+		ConversationId: sdk.String(rec.ConversationID),
+		RecordingId:    sdk.String(rec.RecordingID),
+	}
+}
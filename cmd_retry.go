@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/goDownloadRecording/logger"
+	sdk "github.com/mypurecloud/platform-client-sdk-go/v157/platformclientv2"
+	"go.uber.org/zap"
+)
+
+// runRetry retoma batch jobs ya enviados (por ejemplo tras un corte) sin
+// volver a consultar ni a enviar nada a Genesys.
+func runRetry(ctx context.Context, args []string) error {
+	fs, cfg, err := newFlagSet("retry")
+	if err != nil {
+		return err
+	}
+	jobs := fs.String("jobs", "", "comma-separated batch job IDs to poll and download")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var jobIDs []string
+	for _, id := range strings.Split(*jobs, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			jobIDs = append(jobIDs, id)
+		}
+	}
+	if len(jobIDs) == 0 {
+		return fmt.Errorf("at least one batch job ID is required (use -jobs)")
+	}
+
+	if err := authorize(ctx, cfg); err != nil {
+		return err
+	}
+
+	opts, err := downloadOptions(ctx, cfg, nil)
+	if err != nil {
+		return err
+	}
+	defer opts.Index.Close()
+
+	start := time.Now()
+	logger.Log.Info("Retrying batch jobs", zap.Strings("BatchIDs", jobIDs))
+	downloadBatchJobs(ctx, sdk.NewRecordingApi(), cfg, jobIDs, nil, opts)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	logger.Log.Info("Retry completed", zap.Duration("Duration", time.Since(start)))
+	return nil
+}
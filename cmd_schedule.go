@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/goDownloadRecording/config"
+	query "github.com/goDownloadRecording/conversation_query"
+	"github.com/goDownloadRecording/logger"
+	"github.com/goDownloadRecording/schedule"
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+)
+
+// runSchedule ejecuta en un solo proceso los trabajos de exportación del
+// archivo de trabajos, cada uno según su expresión cron. Cada ejecución es un
+// ciclo incremental con el watermark del trabajo (ver runDaemon). Una
+// ejecución que llega mientras la anterior del mismo trabajo sigue corriendo
+// se saltea (cron.SkipIfStillRunning). Con -run se ejecuta una vez el trabajo indicado y se sale.
+func runSchedule(ctx context.Context, args []string) error {
+	fs, cfg, err := newFlagSet("schedule")
+	if err != nil {
+		return err
+	}
+	runJob := fs.String("run", "", "run this job once now and exit")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if cfg.ScheduleFile == "" {
+		return fmt.Errorf("-jobs is required")
+	}
+	jobs, err := schedule.Load(cfg.ScheduleFile)
+	if err != nil {
+		return err
+	}
+	if err := schedule.CheckDestinations(jobs, cfg); err != nil {
+		return fmt.Errorf("jobs file %s: %w", cfg.ScheduleFile, err)
+	}
+	if cfg.MaxBlockedCycles < 0 {
+		return fmt.Errorf("max-blocked-cycles cannot be negative")
+	}
+
+	// Consultas y -since se validan al arrancar y no en cada ejecución
+	for _, job := range jobs {
+		initial, err := parseSince(jobSince(job), time.Now())
+		if err != nil {
+			return fmt.Errorf("job %q: %w", job.Name, err)
+		}
+		check := job.QueryOptions()
+		check.StartTime, check.EndTime = initial.Format(time.RFC3339), time.Now().Format(time.RFC3339)
+		if _, err := query.BuildConversationQuery(check); err != nil {
+			return fmt.Errorf("job %q: %w", job.Name, err)
+		}
+	}
+
+	if err := authorize(ctx, cfg); err != nil {
+		return err
+	}
+
+	if *runJob != "" {
+		for _, job := range jobs {
+			if job.Name == *runJob {
+				return executeJob(ctx, cfg, job)
+			}
+		}
+		return fmt.Errorf("unknown job %q", *runJob)
+	}
+
+	scheduler := cron.New(cron.WithChain(cron.Recover(cronLogger{})), cron.WithLogger(cronLogger{}))
+	names := make(map[cron.EntryID]string)
+	for _, job := range jobs {
+		run := cron.FuncJob(func() {
+			if err := executeJob(ctx, cfg, job); err != nil && ctx.Err() == nil {
+				logger.Log.Error("Scheduled job failed, watermark not advanced", zap.String("Job", job.Name), zap.Error(err))
+			}
+		})
+		id, err := scheduler.AddJob(job.Spec(), cron.NewChain(cron.SkipIfStillRunning(cronLogger{job: job.Name})).Then(run))
+		if err != nil {
+			return fmt.Errorf("job %q: %w", job.Name, err)
+		}
+		names[id] = job.Name
+	}
+
+	scheduler.Start()
+	for _, entry := range scheduler.Entries() {
+		logger.Log.Info("Job scheduled", zap.String("Job", names[entry.ID]), zap.Time("Next", entry.Next))
+	}
+
+	// Con la señal no se lanzan más ejecuciones y se espera a que terminen
+	// las que están en curso (ctx ya las está cortando)
+	<-ctx.Done()
+	logger.Log.Info("Stopping scheduler, waiting for running jobs")
+	<-scheduler.Stop().Done()
+	return nil
+}
+
+// executeJob ejecuta una vez el trabajo job con su configuración, su
+// consulta y su watermark.
+func executeJob(ctx context.Context, base *config.Config, job schedule.Job) error {
+	cfg := job.Config(base)
+	initial, err := parseSince(jobSince(job), time.Now())
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	logger.Log.Info("Starting job", zap.String("Job", job.Name), zap.String("Output", cfg.DownloadPath))
+	if err := syncOnce(ctx, cfg, job.QueryOptions(), cfg.Watermark(), initial); err != nil {
+		return err
+	}
+	logger.Log.Info("Job finished", zap.String("Job", job.Name), zap.Duration("Duration", time.Since(start)))
+	return nil
+}
+
+// jobSince devuelve el -since del trabajo (default 24h).
+func jobSince(job schedule.Job) string {
+	if job.Since == "" {
+		return "24h"
+	}
+	return job.Since
+}
+
+// cronLogger manda los mensajes del scheduler al logger de la aplicación.
+// Los informativos de cron (wake, run...) van en debug, salvo el "skip" de
+// SkipIfStillRunning, que se avisa con el nombre del trabajo.
+type cronLogger struct {
+	job string
+}
+
+func (l cronLogger) Info(msg string, keysAndValues ...interface{}) {
+	if msg == "skip" {
+		logger.Log.Warn("Previous execution still running, skipping", zap.String("Job", l.job))
+		return
+	}
+	logger.Log.Sugar().Debugw("cron: "+msg, keysAndValues...)
+}
+
+func (cronLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	logger.Log.Sugar().Errorw("cron: "+msg, append(keysAndValues, "error", err)...)
+}
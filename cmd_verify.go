@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/goDownloadRecording/archive"
+	"github.com/goDownloadRecording/functions"
+	"github.com/goDownloadRecording/logger"
+	"github.com/goDownloadRecording/storage"
+	"go.uber.org/zap"
+)
+
+// runVerify revisa la carpeta de grabaciones y el índice del archivo y
+// termina con error si encuentra archivos vacíos, dañados o carpetas sin
+// metadata. Con storage remoto sólo se revisa el índice contra el bucket.
+func runVerify(ctx context.Context, args []string) error {
+	fs, cfg, err := newFlagSet("verify")
+	if err != nil {
+		return err
+	}
+	reindex := fs.Bool("reindex", false, "add recordings found on disk but missing from the archive index")
+	quick := fs.Bool("quick", false, "only check sizes, skip re-hashing every recording")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	backend, err := openStorage(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	_, local := backend.(*storage.Local)
+	if *reindex && !local {
+		return fmt.Errorf("-reindex is only supported with local storage")
+	}
+
+	index, err := archive.Open(cfg.Index())
+	if err != nil {
+		return fmt.Errorf("opening archive index: %w", err)
+	}
+	defer index.Close()
+
+	if *reindex {
+		added, err := functions.ReindexDownloads(cfg.DownloadPath, index)
+		if err != nil {
+			return fmt.Errorf("reindexing %s: %w", cfg.DownloadPath, err)
+		}
+		logger.Log.Info("Archive index updated", zap.Int("Added", added), zap.Int("Total", index.Len()))
+	}
+
+	report := &functions.VerifyReport{}
+	if local {
+		if report, err = functions.VerifyDownloads(cfg.DownloadPath); err != nil {
+			return err
+		}
+	}
+	report.Problems = append(report.Problems, functions.VerifyIndex(backend, index, !*quick)...)
+
+	logger.Log.Info("Verification finished",
+		zap.Int("Folders", report.Folders),
+		zap.Int("Recordings", report.Recordings),
+		zap.Int("Problems", len(report.Problems)))
+	for _, problem := range report.Problems {
+		logger.Log.Warn("Verification problem", zap.String("Path", problem.Path), zap.String("Reason", problem.Reason))
+	}
+	if len(report.Problems) > 0 {
+		return fmt.Errorf("%d problems found in %s storage", len(report.Problems), backend.Name())
+	}
+	return nil
+}
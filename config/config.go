@@ -1,8 +1,10 @@
 package config
 
 import (
+	"flag"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
 	"time"
 
@@ -16,6 +18,74 @@ type Config struct {
 	MaxDownloadWorkers      int
 	PollRetries             int
 	PollInterval            time.Duration
+	BatchWorkers            int
+	MaxActiveJobs           int
+	DownloadRetries         int
+	DownloadBackoff         time.Duration
+	DownloadPath            string
+	JournalPath             string
+	IndexPath               string
+	// Plantillas de carpeta y nombre de archivo (ver layout)
+	FolderTemplate string
+	FileTemplate   string
+	PathTimezone   string
+	// RecordingFormat es el formato de audio pedido (vacío = el del batch)
+	RecordingFormat string
+	// SpeechTranscripts guarda la transcripción de Speech & Text Analytics
+	// junto a cada grabación de audio
+	SpeechTranscripts bool
+	// ScreenRecordings incluye las grabaciones de pantalla
+	ScreenRecordings bool
+	// Cifrado en reposo (ver crypt): archivo de clave simétrica o clave
+	// pública de un destinatario
+	EncryptionKeyFile   string
+	EncryptionRecipient string
+	// Sincronización incremental (daemon): archivo de watermark, cada
+	// cuánto se consulta, solapamiento con el intervalo anterior y demora
+	// respecto de ahora para que analytics tenga los datos completos.
+	// MaxBlockedCycles es cuántos ciclos seguidos puede retener el watermark
+	// una grabación que falla antes de saltearla (0 = nunca)
+	WatermarkPath    string
+	DaemonInterval   time.Duration
+	WatermarkOverlap time.Duration
+	WatermarkDelay   time.Duration
+	MaxBlockedCycles int
+	// ScheduleFile es el archivo de trabajos programados (schedule)
+	ScheduleFile string
+	// Política de retención (YAML/JSON) y log de auditoría de purge
+	RetentionPolicy    string
+	RetentionAuditPath string
+	// Cache cifrada del token OAuth (vacío = sin cache)
+	TokenCachePath string
+	TokenCacheKey  string
+	// Límites de las llamadas a la API de Genesys (ver governor)
+	APIRate    float64
+	APIBurst   int
+	APIRetries int
+	// QueryMode elige el endpoint de la consulta: auto, sync o async.
+	QueryMode       string
+	QueryJobTimeout time.Duration
+	// QueryWindow divide el intervalo en ventanas (0 = una sola consulta).
+	QueryWindow  time.Duration
+	QueryWorkers int
+	// Storage elige el destino de las grabaciones: "local" (DownloadPath), "s3" o "sftp".
+	Storage     string
+	S3Endpoint  string
+	S3Region    string
+	S3Bucket    string
+	S3Prefix    string
+	S3AccessKey string
+	S3SecretKey string
+	S3UseSSL    bool
+	// Entrega por SFTP
+	SFTPAddress         string
+	SFTPUser            string
+	SFTPKeyFile         string
+	SFTPKeyPassphrase   string
+	SFTPKnownHosts      string
+	SFTPInsecureHostKey bool
+	SFTPPathTemplate    string
+	SFTPRetries         int
 }
 
 func LoadConfig() (*Config, error) {
@@ -39,6 +109,120 @@ func LoadConfig() (*Config, error) {
 		interval = 25 // default seconds
 	}
 
+	batchWorkers, err := strconv.Atoi(os.Getenv("BATCH_WORKERS"))
+	if err != nil {
+		batchWorkers = 5 // default
+	}
+
+	activeJobs, err := strconv.Atoi(os.Getenv("ACTIVE_JOBS"))
+	if err != nil {
+		activeJobs = 4 // default
+	}
+
+	downloadRetries, err := strconv.Atoi(os.Getenv("DOWNLOAD_RETRIES"))
+	if err != nil {
+		downloadRetries = 5 // default
+	}
+
+	downloadBackoff, err := strconv.Atoi(os.Getenv("DOWNLOAD_BACKOFF"))
+	if err != nil {
+		downloadBackoff = 2 // default seconds
+	}
+
+	downloadPath := os.Getenv("DOWNLOAD_PATH")
+	if downloadPath == "" {
+		downloadPath = "./recordings/"
+	}
+
+	folderTemplate := os.Getenv("FOLDER_TEMPLATE")
+	if folderTemplate == "" {
+		folderTemplate = "{date}-{conversationId}"
+	}
+
+	fileTemplate := os.Getenv("FILE_TEMPLATE")
+	if fileTemplate == "" {
+		fileTemplate = "{recordingId}"
+	}
+
+	apiRate, err := strconv.ParseFloat(os.Getenv("API_RATE"), 64)
+	if err != nil {
+		apiRate = 5 // default requests/second
+	}
+
+	apiBurst, err := strconv.Atoi(os.Getenv("API_BURST"))
+	if err != nil {
+		apiBurst = 10 // default
+	}
+
+	apiRetries, err := strconv.Atoi(os.Getenv("API_RETRIES"))
+	if err != nil {
+		apiRetries = 5 // default
+	}
+
+	queryMode := os.Getenv("QUERY_MODE")
+	if queryMode == "" {
+		queryMode = "auto"
+	}
+
+	queryJobTimeout, err := strconv.Atoi(os.Getenv("QUERY_JOB_TIMEOUT"))
+	if err != nil {
+		queryJobTimeout = 3600 // default seconds
+	}
+
+	queryWindow, err := strconv.Atoi(os.Getenv("QUERY_WINDOW"))
+	if err != nil {
+		queryWindow = 0 // default: sin ventanas
+	}
+
+	queryWorkers, err := strconv.Atoi(os.Getenv("QUERY_WORKERS"))
+	if err != nil {
+		queryWorkers = 4 // default
+	}
+
+	daemonInterval, err := strconv.Atoi(os.Getenv("DAEMON_INTERVAL"))
+	if err != nil {
+		daemonInterval = 15 // default minutes
+	}
+
+	watermarkOverlap, err := strconv.Atoi(os.Getenv("WATERMARK_OVERLAP"))
+	if err != nil {
+		watermarkOverlap = 60 // default minutes
+	}
+
+	watermarkDelay, err := strconv.Atoi(os.Getenv("WATERMARK_DELAY"))
+	if err != nil {
+		watermarkDelay = 5 // default minutes
+	}
+
+	maxBlockedCycles, err := strconv.Atoi(os.Getenv("MAX_BLOCKED_CYCLES"))
+	if err != nil {
+		maxBlockedCycles = 3 // default
+	}
+
+	storage := os.Getenv("STORAGE")
+	if storage == "" {
+		storage = "local"
+	}
+
+	s3UseSSL, err := strconv.ParseBool(os.Getenv("S3_USE_SSL"))
+	if err != nil {
+		s3UseSSL = true // default
+	}
+
+	sftpRetries, err := strconv.Atoi(os.Getenv("SFTP_RETRIES"))
+	if err != nil {
+		sftpRetries = 3 // default
+	}
+
+	sftpInsecure, _ := strconv.ParseBool(os.Getenv("SFTP_INSECURE_HOST_KEY"))
+
+	speechTranscripts, _ := strconv.ParseBool(os.Getenv("SPEECH_TRANSCRIPTS"))
+
+	screenRecordings, err := strconv.ParseBool(os.Getenv("SCREEN_RECORDINGS"))
+	if err != nil {
+		screenRecordings = true // default
+	}
+
 	cfg := &Config{
 		GenesysCloudEnvironment: os.Getenv("GENESYS_ENVIRONMENT"),
 		ClientID:                os.Getenv("CLIENT_ID"),
@@ -46,7 +230,154 @@ func LoadConfig() (*Config, error) {
 		MaxDownloadWorkers:      maxWorkers,
 		PollRetries:             retries,
 		PollInterval:            time.Duration(interval) * time.Second,
+		BatchWorkers:            batchWorkers,
+		MaxActiveJobs:           activeJobs,
+		DownloadRetries:         downloadRetries,
+		DownloadBackoff:         time.Duration(downloadBackoff) * time.Second,
+		DownloadPath:            downloadPath,
+		FolderTemplate:          folderTemplate,
+		FileTemplate:            fileTemplate,
+		PathTimezone:            os.Getenv("PATH_TIMEZONE"),
+		RecordingFormat:         os.Getenv("RECORDING_FORMAT"),
+		SpeechTranscripts:       speechTranscripts,
+		ScreenRecordings:        screenRecordings,
+		JournalPath:             os.Getenv("JOURNAL_PATH"),
+		IndexPath:               os.Getenv("INDEX_PATH"),
+		EncryptionKeyFile:       os.Getenv("ENCRYPTION_KEY_FILE"),
+		EncryptionRecipient:     os.Getenv("ENCRYPTION_RECIPIENT"),
+		WatermarkPath:           os.Getenv("WATERMARK_PATH"),
+		DaemonInterval:          time.Duration(daemonInterval) * time.Minute,
+		WatermarkOverlap:        time.Duration(watermarkOverlap) * time.Minute,
+		WatermarkDelay:          time.Duration(watermarkDelay) * time.Minute,
+		MaxBlockedCycles:        maxBlockedCycles,
+		ScheduleFile:            os.Getenv("SCHEDULE_FILE"),
+		RetentionPolicy:         os.Getenv("RETENTION_POLICY"),
+		RetentionAuditPath:      os.Getenv("RETENTION_AUDIT_LOG"),
+		TokenCachePath:          os.Getenv("TOKEN_CACHE"),
+		TokenCacheKey:           os.Getenv("TOKEN_CACHE_KEY"),
+		APIRate:                 apiRate,
+		APIBurst:                apiBurst,
+		APIRetries:              apiRetries,
+		QueryMode:               queryMode,
+		QueryJobTimeout:         time.Duration(queryJobTimeout) * time.Second,
+		QueryWindow:             time.Duration(queryWindow) * time.Hour,
+		QueryWorkers:            queryWorkers,
+		Storage:                 storage,
+		S3Endpoint:              os.Getenv("S3_ENDPOINT"),
+		S3Region:                os.Getenv("S3_REGION"),
+		S3Bucket:                os.Getenv("S3_BUCKET"),
+		S3Prefix:                os.Getenv("S3_PREFIX"),
+		S3AccessKey:             os.Getenv("S3_ACCESS_KEY"),
+		S3SecretKey:             os.Getenv("S3_SECRET_KEY"),
+		S3UseSSL:                s3UseSSL,
+		SFTPAddress:             os.Getenv("SFTP_HOST"),
+		SFTPUser:                os.Getenv("SFTP_USER"),
+		SFTPKeyFile:             os.Getenv("SFTP_KEY_FILE"),
+		SFTPKeyPassphrase:       os.Getenv("SFTP_KEY_PASSPHRASE"),
+		SFTPKnownHosts:          os.Getenv("SFTP_KNOWN_HOSTS"),
+		SFTPInsecureHostKey:     sftpInsecure,
+		SFTPPathTemplate:        os.Getenv("SFTP_PATH_TEMPLATE"),
+		SFTPRetries:             sftpRetries,
 	}
 
 	return cfg, nil
 }
+
+// RegisterFlags expone cada valor de Config como flag de línea de comandos.
+// Los valores cargados del entorno (.env) quedan como default, de modo que
+// un flag explícito siempre tiene prioridad sobre la variable de entorno.
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.GenesysCloudEnvironment, "environment", c.GenesysCloudEnvironment, "Genesys Cloud environment (e.g. mypurecloud.com) [GENESYS_ENVIRONMENT]")
+	fs.StringVar(&c.ClientID, "client-id", c.ClientID, "OAuth client ID [CLIENT_ID]")
+	fs.StringVar(&c.ClientSecret, "client-secret", c.ClientSecret, "OAuth client secret [CLIENT_SECRET]")
+	fs.IntVar(&c.MaxDownloadWorkers, "download-workers", c.MaxDownloadWorkers, "concurrent downloads per batch job [MAX_DOWNLOAD_WORKERS]")
+	fs.IntVar(&c.PollRetries, "poll-retries", c.PollRetries, "max polls per batch job [POLL_RETRIES]")
+	fs.DurationVar(&c.PollInterval, "poll-interval", c.PollInterval, "time between batch job polls [POLL_INTERVAL, seconds]")
+	fs.IntVar(&c.BatchWorkers, "batch-workers", c.BatchWorkers, "concurrent recording metadata fetches [BATCH_WORKERS]")
+	fs.IntVar(&c.MaxActiveJobs, "active-jobs", c.MaxActiveJobs, "batch jobs polled or downloaded at the same time; more jobs wait [ACTIVE_JOBS]")
+	fs.IntVar(&c.DownloadRetries, "download-retries", c.DownloadRetries, "retries per file when a transfer fails [DOWNLOAD_RETRIES]")
+	fs.DurationVar(&c.DownloadBackoff, "download-backoff", c.DownloadBackoff, "initial wait between download retries, doubled each time [DOWNLOAD_BACKOFF, seconds]")
+	fs.StringVar(&c.DownloadPath, "output", c.DownloadPath, "directory where recordings are written with local storage [DOWNLOAD_PATH]")
+	fs.StringVar(&c.FolderTemplate, "folder-template", c.FolderTemplate, "folder of each recording using {date}, {date:layout}, {conversationId}, {queue}, {queueId}, {agent}, {agentId}, {direction}, {division}, {divisionId}; / creates subfolders [FOLDER_TEMPLATE]")
+	fs.StringVar(&c.FileTemplate, "file-template", c.FileTemplate, "recording file name without extension, same variables plus {recordingId} [FILE_TEMPLATE]")
+	fs.StringVar(&c.PathTimezone, "path-timezone", c.PathTimezone, "IANA time zone for {date}, e.g. America/Argentina/Buenos_Aires (default local) [PATH_TIMEZONE]")
+	fs.StringVar(&c.RecordingFormat, "format", c.RecordingFormat, "audio format requested for each recording: WAV, WAV_ULAW, WEBM, OGG_OPUS, OGG_VORBIS or MP3 (default as returned by the batch) [RECORDING_FORMAT]")
+	fs.BoolVar(&c.SpeechTranscripts, "speech-transcripts", c.SpeechTranscripts, "also save the speech analytics transcript (JSON and text) next to each audio recording [SPEECH_TRANSCRIPTS]")
+	fs.BoolVar(&c.ScreenRecordings, "screen-recordings", c.ScreenRecordings, "download screen recordings (video) besides audio; -screen-recordings=false skips them [SCREEN_RECORDINGS]")
+	fs.StringVar(&c.JournalPath, "journal", c.JournalPath, "run journal file used by resume (default <output>/journal.jsonl) [JOURNAL_PATH]")
+	fs.StringVar(&c.IndexPath, "index", c.IndexPath, "archive index of downloaded recordings (default <output>/index.jsonl) [INDEX_PATH]")
+	fs.StringVar(&c.EncryptionKeyFile, "encryption-key", c.EncryptionKeyFile, "file with a 32-byte key (hex or base64) that wraps the per-file keys of encrypted recordings [ENCRYPTION_KEY_FILE]")
+	fs.StringVar(&c.EncryptionRecipient, "encryption-recipient", c.EncryptionRecipient, "RSA public key (PEM) that wraps the per-file keys; only its private key can decrypt [ENCRYPTION_RECIPIENT]")
+	fs.StringVar(&c.WatermarkPath, "watermark", c.WatermarkPath, "file with the end of the last interval synced by daemon (default <output>/watermark.json) [WATERMARK_PATH]")
+	fs.DurationVar(&c.DaemonInterval, "daemon-interval", c.DaemonInterval, "time between daemon sync cycles [DAEMON_INTERVAL, minutes]")
+	fs.DurationVar(&c.WatermarkOverlap, "overlap", c.WatermarkOverlap, "each sync re-queries this much before the watermark to catch late recordings [WATERMARK_OVERLAP, minutes]")
+	fs.DurationVar(&c.WatermarkDelay, "delay", c.WatermarkDelay, "each sync ends this long before now so analytics data is complete [WATERMARK_DELAY, minutes]")
+	fs.IntVar(&c.MaxBlockedCycles, "max-blocked-cycles", c.MaxBlockedCycles, "consecutive sync cycles a failing recording can hold back the watermark before it is skipped (0 = never skip) [MAX_BLOCKED_CYCLES]")
+	fs.StringVar(&c.ScheduleFile, "jobs", c.ScheduleFile, "YAML/JSON file with the export jobs run by schedule [SCHEDULE_FILE]")
+	fs.StringVar(&c.RetentionPolicy, "retention-policy", c.RetentionPolicy, "YAML/JSON retention policy applied by purge [RETENTION_POLICY]")
+	fs.StringVar(&c.RetentionAuditPath, "retention-audit", c.RetentionAuditPath, "audit log of every recording deleted by purge (default <output>/retention-audit.jsonl) [RETENTION_AUDIT_LOG]")
+	fs.StringVar(&c.TokenCachePath, "token-cache", c.TokenCachePath, "encrypted file to reuse the access token between runs (empty disables) [TOKEN_CACHE]")
+	fs.StringVar(&c.TokenCacheKey, "token-cache-key", c.TokenCacheKey, "passphrase that encrypts the token cache [TOKEN_CACHE_KEY]")
+	fs.Float64Var(&c.APIRate, "api-rate", c.APIRate, "max Genesys API requests per second across all stages (0 = unlimited) [API_RATE]")
+	fs.IntVar(&c.APIBurst, "api-burst", c.APIBurst, "requests allowed in a burst above api-rate [API_BURST]")
+	fs.IntVar(&c.APIRetries, "api-retries", c.APIRetries, "retries per API request on 429, 5xx or network errors [API_RETRIES]")
+	fs.StringVar(&c.QueryMode, "query-mode", c.QueryMode, "conversation query endpoint: auto, sync or async details jobs [QUERY_MODE]")
+	fs.DurationVar(&c.QueryJobTimeout, "query-job-timeout", c.QueryJobTimeout, "max wait for an async conversation details job [QUERY_JOB_TIMEOUT, seconds]")
+	fs.DurationVar(&c.QueryWindow, "query-window", c.QueryWindow, "split the query interval into windows of this size, e.g. 24h or 1h (0 disables) [QUERY_WINDOW, hours]")
+	fs.IntVar(&c.QueryWorkers, "query-workers", c.QueryWorkers, "query windows run concurrently [QUERY_WORKERS]")
+	fs.StringVar(&c.Storage, "storage", c.Storage, "where recordings are stored: local, s3 or sftp [STORAGE]")
+	fs.StringVar(&c.S3Endpoint, "s3-endpoint", c.S3Endpoint, "S3-compatible endpoint host[:port], e.g. s3.amazonaws.com or localhost:9000 [S3_ENDPOINT]")
+	fs.StringVar(&c.S3Region, "s3-region", c.S3Region, "S3 region [S3_REGION]")
+	fs.StringVar(&c.S3Bucket, "s3-bucket", c.S3Bucket, "S3 bucket (must exist) [S3_BUCKET]")
+	fs.StringVar(&c.S3Prefix, "s3-prefix", c.S3Prefix, "key prefix for every object in the bucket [S3_PREFIX]")
+	fs.StringVar(&c.S3AccessKey, "s3-access-key", c.S3AccessKey, "S3 access key [S3_ACCESS_KEY]")
+	fs.StringVar(&c.S3SecretKey, "s3-secret-key", c.S3SecretKey, "S3 secret key [S3_SECRET_KEY]")
+	fs.BoolVar(&c.S3UseSSL, "s3-ssl", c.S3UseSSL, "use HTTPS for the S3 endpoint [S3_USE_SSL]")
+	fs.StringVar(&c.SFTPAddress, "sftp-host", c.SFTPAddress, "SFTP server host[:port] [SFTP_HOST]")
+	fs.StringVar(&c.SFTPUser, "sftp-user", c.SFTPUser, "SFTP user [SFTP_USER]")
+	fs.StringVar(&c.SFTPKeyFile, "sftp-key", c.SFTPKeyFile, "private key file for SFTP authentication [SFTP_KEY_FILE]")
+	fs.StringVar(&c.SFTPKeyPassphrase, "sftp-key-passphrase", c.SFTPKeyPassphrase, "passphrase of the SFTP private key [SFTP_KEY_PASSPHRASE]")
+	fs.StringVar(&c.SFTPKnownHosts, "sftp-known-hosts", c.SFTPKnownHosts, "known_hosts file used to verify the SFTP server [SFTP_KNOWN_HOSTS]")
+	fs.BoolVar(&c.SFTPInsecureHostKey, "sftp-insecure-host-key", c.SFTPInsecureHostKey, "accept any SFTP server key (testing only) [SFTP_INSECURE_HOST_KEY]")
+	fs.StringVar(&c.SFTPPathTemplate, "sftp-path", c.SFTPPathTemplate, "remote path template using {folder}, {file}, {key}, {date}, {conversationId} (default {folder}/{file}) [SFTP_PATH_TEMPLATE]")
+	fs.IntVar(&c.SFTPRetries, "sftp-retries", c.SFTPRetries, "retries per SFTP operation, reconnecting each time [SFTP_RETRIES]")
+}
+
+// Journal devuelve la ruta del journal, por defecto dentro de DownloadPath.
+func (c *Config) Journal() string {
+	if c.JournalPath != "" {
+		return c.JournalPath
+	}
+	return filepath.Join(c.DownloadPath, "journal.jsonl")
+}
+
+// Summary devuelve la ruta del resumen de la última corrida, junto al journal.
+func (c *Config) Summary() string {
+	return filepath.Join(filepath.Dir(c.Journal()), "summary.json")
+}
+
+// Index devuelve la ruta del índice del archivo, por defecto dentro de DownloadPath.
+func (c *Config) Index() string {
+	if c.IndexPath != "" {
+		return c.IndexPath
+	}
+	return filepath.Join(c.DownloadPath, "index.jsonl")
+}
+
+// Watermark devuelve la ruta del watermark del daemon, por defecto dentro de
+// DownloadPath.
+func (c *Config) Watermark() string {
+	if c.WatermarkPath != "" {
+		return c.WatermarkPath
+	}
+	return filepath.Join(c.DownloadPath, "watermark.json")
+}
+
+// RetentionAudit devuelve la ruta del log de auditoría de purge, por defecto
+// dentro de DownloadPath.
+func (c *Config) RetentionAudit() string {
+	if c.RetentionAuditPath != "" {
+		return c.RetentionAuditPath
+	}
+	return filepath.Join(c.DownloadPath, "retention-audit.jsonl")
+}
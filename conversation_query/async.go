@@ -0,0 +1,239 @@
+package conversation_query
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/goDownloadRecording/logger"
+	sdk "github.com/mypurecloud/platform-client-sdk-go/v157/platformclientv2"
+	"go.uber.org/zap"
+)
+
+// Modos de ejecución de la consulta.
+const (
+	ModeAuto  = "auto"  // sync salvo que el intervalo o los resultados superen sus límites
+	ModeSync  = "sync"  // PostAnalyticsConversationsDetailsQuery paginado
+	ModeAsync = "async" // conversation details jobs
+)
+
+// Límites del endpoint sincrónico: más allá de estos valores Genesys rechaza
+// la consulta o corta los resultados, y hay que usar los jobs asincrónicos.
+const (
+	SyncMaxInterval = 31 * 24 * time.Hour
+	SyncMaxResults  = 100000
+)
+
+// asyncPageSize es el tamaño de página al leer los resultados de un job.
+const asyncPageSize = 1000
+
+// ExecOptions configura cómo se ejecuta la consulta.
+type ExecOptions struct {
+	Mode string
+	// PollInterval es la espera entre consultas del estado de un job.
+	PollInterval time.Duration
+	// Timeout acota cuánto se espera a que un job termine.
+	Timeout time.Duration
+	// Window, si es mayor a cero, divide el intervalo en ventanas de ese
+	// largo que se consultan por separado, hasta Workers a la vez.
+	Window  time.Duration
+	Workers int
+}
+
+// errSyncLimit indica que la consulta supera los límites del endpoint sincrónico.
+var errSyncLimit = errors.New("query exceeds synchronous limits")
+
+// PageFunc recibe cada página de conversaciones a medida que llega. Si
+// devuelve error la consulta se corta con ese error.
+type PageFunc func([]sdk.Analyticsconversationwithoutattributes) error
+
+// GetConversations ejecuta la consulta con el modo elegido en opts, por
+// ventanas si opts.Window está definido. En modo auto se usa el endpoint
+// sincrónico salvo que el intervalo supere SyncMaxInterval o la primera
+// página informe más de SyncMaxResults resultados; en esos casos se usa un
+// job asincrónico. Si ctx se cancela, la consulta se abandona y se devuelve
+// el error del contexto.
+func GetConversations(ctx context.Context, api *sdk.AnalyticsApi, query sdk.Conversationquery, opts ExecOptions) ([]sdk.Analyticsconversationwithoutattributes, error) {
+	var allResults []sdk.Analyticsconversationwithoutattributes
+	if err := StreamConversations(ctx, api, query, opts, collect(&allResults)); err != nil {
+		return nil, err
+	}
+	return allResults, nil
+}
+
+// StreamConversations ejecuta la consulta como GetConversations pero pasa
+// cada página a emit apenas llega, sin esperar al resto. emit se llama desde
+// una sola goroutine a la vez; mientras no vuelve no se piden más páginas.
+func StreamConversations(ctx context.Context, api *sdk.AnalyticsApi, query sdk.Conversationquery, opts ExecOptions, emit PageFunc) error {
+	if opts.Window > 0 && query.Interval != nil {
+		return streamWindowed(ctx, api, query, opts, emit)
+	}
+	return streamSingle(ctx, api, query, opts, emit)
+}
+
+// streamSingle ejecuta la consulta completa con un único endpoint.
+func streamSingle(ctx context.Context, api *sdk.AnalyticsApi, query sdk.Conversationquery, opts ExecOptions, emit PageFunc) error {
+	switch opts.Mode {
+	case ModeSync:
+		return syncPages(ctx, api, query, false, emit)
+	case ModeAsync:
+		return asyncPages(ctx, api, query, opts, emit)
+	case ModeAuto, "":
+	default:
+		return fmt.Errorf("unknown query mode %q (use auto, sync or async)", opts.Mode)
+	}
+
+	if query.Interval != nil {
+		start, end, err := ParseInterval(*query.Interval)
+		if err == nil && end.Sub(start) > SyncMaxInterval {
+			logger.Log.Info("Interval exceeds synchronous query limit, using async job",
+				zap.String("Interval", *query.Interval),
+				zap.Duration("Limit", SyncMaxInterval))
+			return asyncPages(ctx, api, query, opts, emit)
+		}
+	}
+
+	// errSyncLimit llega antes de emitir la primera página
+	err := syncPages(ctx, api, query, true, emit)
+	if errors.Is(err, errSyncLimit) {
+		logger.Log.Info("Query exceeds synchronous result limit, using async job", zap.Int("Limit", SyncMaxResults))
+		return asyncPages(ctx, api, query, opts, emit)
+	}
+	return err
+}
+
+// GetAllConversationsResultsAsync envía la consulta como conversation
+// details job, espera a que termine y lee todos sus resultados con cursor.
+func GetAllConversationsResultsAsync(ctx context.Context, api *sdk.AnalyticsApi, query sdk.Conversationquery, opts ExecOptions) ([]sdk.Analyticsconversationwithoutattributes, error) {
+	var allResults []sdk.Analyticsconversationwithoutattributes
+	if err := asyncPages(ctx, api, query, opts, collect(&allResults)); err != nil {
+		return nil, err
+	}
+	return allResults, nil
+}
+
+// asyncPages ejecuta la consulta como job y pasa cada página de resultados a emit.
+func asyncPages(ctx context.Context, api *sdk.AnalyticsApi, query sdk.Conversationquery, opts ExecOptions, emit PageFunc) error {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 10 * time.Second
+	}
+
+	// Conversationquery y Asyncconversationquery comparten los filtros; el
+	// job no admite paging ni aggregations.
+	var body sdk.Asyncconversationquery
+	if err := convertJSON(query, &body); err != nil {
+		return fmt.Errorf("building async query: %w", err)
+	}
+
+	job, _, err := api.PostAnalyticsConversationsDetailsJobs(body)
+	if err != nil {
+		return fmt.Errorf("submitting conversation details job: %w", err)
+	}
+	if job.JobId == nil {
+		return errors.New("conversation details job returned no job ID")
+	}
+	jobID := *job.JobId
+	logger.Log.Info("Conversation details job submitted", zap.String("JobID", jobID))
+
+	if err := waitForDetailsJob(ctx, api, jobID, opts); err != nil {
+		return err
+	}
+
+	total := 0
+	cursor := ""
+	for page := 1; ; page++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		resp, _, err := api.GetAnalyticsConversationsDetailsJobResults(jobID, cursor, asyncPageSize)
+		if err != nil {
+			return fmt.Errorf("error en página %d del job %s: %w", page, jobID, err)
+		}
+		if resp.Conversations != nil {
+			var conversations []sdk.Analyticsconversationwithoutattributes
+			if err := convertJSON(*resp.Conversations, &conversations); err != nil {
+				return fmt.Errorf("decoding job results: %w", err)
+			}
+			total += len(conversations)
+			if err := emit(conversations); err != nil {
+				return err
+			}
+		}
+		logger.Log.Debug("Conversation details job page", zap.String("JobID", jobID), zap.Int("Page", page), zap.Int("Total", total))
+
+		if resp.Cursor == nil || *resp.Cursor == "" {
+			break
+		}
+		cursor = *resp.Cursor
+	}
+
+	logger.Log.Info("Conversation details job completed", zap.String("JobID", jobID), zap.Int("TotalConversations", total))
+	return nil
+}
+
+// collect devuelve un PageFunc que acumula las páginas en results.
+func collect(results *[]sdk.Analyticsconversationwithoutattributes) PageFunc {
+	return func(page []sdk.Analyticsconversationwithoutattributes) error {
+		*results = append(*results, page...)
+		return nil
+	}
+}
+
+// waitForDetailsJob consulta el estado del job hasta que termine, falle o
+// se agote opts.Timeout o se cancele ctx.
+func waitForDetailsJob(ctx context.Context, api *sdk.AnalyticsApi, jobID string, opts ExecOptions) error {
+	start := time.Now()
+	for {
+		status, _, err := api.GetAnalyticsConversationsDetailsJob(jobID)
+		if err != nil {
+			return fmt.Errorf("polling conversation details job %s: %w", jobID, err)
+		}
+
+		state := ""
+		if status.State != nil {
+			state = *status.State
+		}
+		switch state {
+		case "FULFILLED":
+			return nil
+		case "FAILED", "CANCELLED", "EXPIRED":
+			msg := ""
+			if status.ErrorMessage != nil {
+				msg = *status.ErrorMessage
+			}
+			return fmt.Errorf("conversation details job %s %s: %s", jobID, state, msg)
+		}
+
+		if opts.Timeout > 0 && time.Since(start) > opts.Timeout {
+			return fmt.Errorf("conversation details job %s still %s after %s", jobID, state, opts.Timeout)
+		}
+		logger.Log.Info("Waiting for conversation details job", zap.String("JobID", jobID), zap.String("State", state))
+		if err := sleep(ctx, opts.PollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// sleep espera d o hasta que se cancele ctx.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// convertJSON copia src en dst a través de JSON, para pasar entre tipos del
+// SDK con los mismos campos.
+func convertJSON(src, dst interface{}) error {
+	b, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
@@ -0,0 +1,347 @@
+package conversation_query
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	sdk "github.com/mypurecloud/platform-client-sdk-go/v157/platformclientv2"
+	"gopkg.in/yaml.v3"
+)
+
+// QueryDefinition describe una consulta de conversaciones de forma
+// declarativa, para poder versionarla en un archivo YAML o JSON.
+type QueryDefinition struct {
+	// Interval en formato ISO-8601 (inicio/fin). Alternativamente Start y End.
+	Interval string `json:"interval,omitempty" yaml:"interval,omitempty"`
+	Start    string `json:"start,omitempty" yaml:"start,omitempty"`
+	End      string `json:"end,omitempty" yaml:"end,omitempty"`
+
+	Order   string `json:"order,omitempty" yaml:"order,omitempty"`
+	OrderBy string `json:"orderBy,omitempty" yaml:"orderBy,omitempty"`
+
+	// MediaTypes limita los segmentos a esos tipos de media (default: voice).
+	MediaTypes []string `json:"mediaTypes,omitempty" yaml:"mediaTypes,omitempty"`
+	// RequireRecording agrega el predicado recording=exists (default: true).
+	RequireRecording *bool `json:"requireRecording,omitempty" yaml:"requireRecording,omitempty"`
+
+	SegmentFilters      []FilterDefinition `json:"segmentFilters,omitempty" yaml:"segmentFilters,omitempty"`
+	ConversationFilters []FilterDefinition `json:"conversationFilters,omitempty" yaml:"conversationFilters,omitempty"`
+}
+
+// FilterDefinition es un grupo de predicados combinados con and/or.
+type FilterDefinition struct {
+	Type       string                `json:"type" yaml:"type"`
+	Predicates []PredicateDefinition `json:"predicates" yaml:"predicates"`
+}
+
+// PredicateDefinition es una condición sobre una dimensión de analytics.
+type PredicateDefinition struct {
+	Dimension string `json:"dimension" yaml:"dimension"`
+	Operator  string `json:"operator,omitempty" yaml:"operator,omitempty"`
+	Value     string `json:"value,omitempty" yaml:"value,omitempty"`
+}
+
+var (
+	validOrders     = []string{"asc", "desc"}
+	validOrderBys   = []string{"conversationStart", "conversationEnd", "segmentStart", "segmentEnd"}
+	validFilterType = []string{"and", "or"}
+	validOperators  = []string{"matches", "exists", "notExists"}
+	validMediaTypes = []string{"voice", "chat", "email", "message", "callback", "cobrowse", "video", "screenshare", "unknown"}
+)
+
+// LoadQueryDefinition lee una definición desde un archivo .yaml, .yml o .json.
+// Los campos desconocidos se rechazan para detectar errores de tipeo.
+func LoadQueryDefinition(path string) (*QueryDefinition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	def := &QueryDefinition{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(def)
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(def)
+	default:
+		return nil, fmt.Errorf("unsupported query definition format %q (use .yaml, .yml or .json)", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing query definition %s: %w", path, err)
+	}
+	return def, nil
+}
+
+// LoadRawQuery lee un sdk.Conversationquery en JSON y lo usa tal cual.
+func LoadRawQuery(path string) (sdk.Conversationquery, error) {
+	var query sdk.Conversationquery
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return query, err
+	}
+	if err := json.Unmarshal(data, &query); err != nil {
+		return query, fmt.Errorf("parsing raw query %s: %w", path, err)
+	}
+	if query.Interval == nil || *query.Interval == "" {
+		return query, fmt.Errorf("raw query %s has no interval", path)
+	}
+	return query, nil
+}
+
+// ApplyOptions sobreescribe la definición con los valores no vacíos de opts,
+// de modo que los flags siempre tienen prioridad sobre el archivo. Con sólo
+// uno de -start y -end, el otro extremo sale del intervalo del archivo.
+func (d *QueryDefinition) ApplyOptions(opts QueryOptions) {
+	if opts.StartTime != "" || opts.EndTime != "" {
+		if d.Interval != "" {
+			d.Start, d.End, _ = strings.Cut(d.Interval, "/")
+			d.Interval = ""
+		}
+		if opts.StartTime != "" {
+			d.Start = opts.StartTime
+		}
+		if opts.EndTime != "" {
+			d.End = opts.EndTime
+		}
+	}
+	if opts.Order != "" {
+		d.Order = opts.Order
+	}
+	if opts.OrderBy != "" {
+		d.OrderBy = opts.OrderBy
+	}
+	if opts.MediaTypes != "" {
+		d.MediaTypes = nil
+		for _, mediaType := range strings.Split(opts.MediaTypes, ",") {
+			if mediaType = strings.TrimSpace(mediaType); mediaType != "" {
+				d.MediaTypes = append(d.MediaTypes, mediaType)
+			}
+		}
+	}
+	if opts.DivisionID != "" {
+		d.ConversationFilters = append(d.ConversationFilters, FilterDefinition{
+			Type:       "or",
+			Predicates: []PredicateDefinition{{Dimension: "divisionId", Operator: "matches", Value: opts.DivisionID}},
+		})
+	}
+	if opts.OriginatingDirection != "" {
+		d.ConversationFilters = append(d.ConversationFilters, FilterDefinition{
+			Type:       "or",
+			Predicates: []PredicateDefinition{{Dimension: "originatingDirection", Operator: "matches", Value: opts.OriginatingDirection}},
+		})
+	}
+}
+
+// interval devuelve el intervalo efectivo de la definición.
+func (d *QueryDefinition) interval() string {
+	if d.Interval != "" {
+		return d.Interval
+	}
+	if d.Start == "" && d.End == "" {
+		return ""
+	}
+	return d.Start + "/" + d.End
+}
+
+// Validate revisa que la definición sea compilable antes de enviarla a la API.
+func (d *QueryDefinition) Validate() error {
+	interval := d.interval()
+	if interval == "" {
+		return fmt.Errorf("interval is required (interval, or start and end)")
+	}
+	if d.Interval == "" && (d.Start == "" || d.End == "") {
+		return fmt.Errorf("interval needs both start and end (got %q)", interval)
+	}
+	if _, _, err := ParseInterval(interval); err != nil {
+		return err
+	}
+	if d.Order != "" && !contains(validOrders, d.Order) {
+		return fmt.Errorf("invalid order %q (valid: %s)", d.Order, strings.Join(validOrders, ", "))
+	}
+	if d.OrderBy != "" && !contains(validOrderBys, d.OrderBy) {
+		return fmt.Errorf("invalid orderBy %q (valid: %s)", d.OrderBy, strings.Join(validOrderBys, ", "))
+	}
+	for _, mediaType := range d.MediaTypes {
+		if !contains(validMediaTypes, mediaType) {
+			return fmt.Errorf("invalid media type %q (valid: %s)", mediaType, strings.Join(validMediaTypes, ", "))
+		}
+	}
+	for i, filter := range d.SegmentFilters {
+		if err := filter.validate(); err != nil {
+			return fmt.Errorf("segmentFilters[%d]: %w", i, err)
+		}
+	}
+	for i, filter := range d.ConversationFilters {
+		if err := filter.validate(); err != nil {
+			return fmt.Errorf("conversationFilters[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (f FilterDefinition) validate() error {
+	if !contains(validFilterType, f.Type) {
+		return fmt.Errorf("invalid filter type %q (valid: and, or)", f.Type)
+	}
+	if len(f.Predicates) == 0 {
+		return fmt.Errorf("filter has no predicates")
+	}
+	for i, p := range f.Predicates {
+		if p.Dimension == "" {
+			return fmt.Errorf("predicates[%d]: dimension is required", i)
+		}
+		operator := p.operator()
+		if !contains(validOperators, operator) {
+			return fmt.Errorf("predicates[%d]: invalid operator %q (valid: %s)", i, operator, strings.Join(validOperators, ", "))
+		}
+		if operator == "matches" && p.Value == "" {
+			return fmt.Errorf("predicates[%d]: value is required for operator matches", i)
+		}
+		if operator != "matches" && p.Value != "" {
+			return fmt.Errorf("predicates[%d]: value is not allowed for operator %s", i, operator)
+		}
+	}
+	return nil
+}
+
+// operator devuelve el operador del predicado; matches si no se indicó.
+func (p PredicateDefinition) operator() string {
+	if p.Operator == "" {
+		return "matches"
+	}
+	return p.Operator
+}
+
+// Compile valida la definición y la traduce a un sdk.Conversationquery.
+func (d *QueryDefinition) Compile() (sdk.Conversationquery, error) {
+	if err := d.Validate(); err != nil {
+		return sdk.Conversationquery{}, err
+	}
+
+	interval := d.interval()
+	order := d.Order
+	if order == "" {
+		order = "desc"
+	}
+	orderBy := d.OrderBy
+	if orderBy == "" {
+		orderBy = "conversationStart"
+	}
+
+	// Segment filters obligatorios: tipo de media y grabación existente
+	mediaTypes := d.MediaTypes
+	if len(mediaTypes) == 0 {
+		mediaTypes = []string{"voice"}
+	}
+	var required []sdk.Segmentdetailquerypredicate
+	for _, mediaType := range mediaTypes {
+		required = append(required, sdk.Segmentdetailquerypredicate{
+			Dimension: sdk.String("mediaType"),
+			Operator:  sdk.String("matches"),
+			Value:     sdk.String(mediaType),
+		})
+	}
+	segmentFilters := []sdk.Segmentdetailqueryfilter{}
+	if len(required) == 1 {
+		// Con un solo tipo se mantiene el filtro "and" histórico
+		if d.RequireRecording == nil || *d.RequireRecording {
+			required = append(required, sdk.Segmentdetailquerypredicate{
+				Dimension: sdk.String("recording"),
+				Operator:  sdk.String("exists"),
+			})
+		}
+		segmentFilters = append(segmentFilters, sdk.Segmentdetailqueryfilter{VarType: sdk.String("and"), Predicates: &required})
+	} else {
+		segmentFilters = append(segmentFilters, sdk.Segmentdetailqueryfilter{VarType: sdk.String("or"), Predicates: &required})
+		if d.RequireRecording == nil || *d.RequireRecording {
+			segmentFilters = append(segmentFilters, sdk.Segmentdetailqueryfilter{
+				VarType: sdk.String("and"),
+				Predicates: &[]sdk.Segmentdetailquerypredicate{
+					{Dimension: sdk.String("recording"), Operator: sdk.String("exists")},
+				},
+			})
+		}
+	}
+	for _, filter := range d.SegmentFilters {
+		var predicates []sdk.Segmentdetailquerypredicate
+		for _, p := range filter.Predicates {
+			predicate := sdk.Segmentdetailquerypredicate{
+				Dimension: sdk.String(p.Dimension),
+				Operator:  sdk.String(p.operator()),
+			}
+			if p.Value != "" {
+				predicate.Value = sdk.String(p.Value)
+			}
+			predicates = append(predicates, predicate)
+		}
+		segmentFilters = append(segmentFilters, sdk.Segmentdetailqueryfilter{VarType: sdk.String(filter.Type), Predicates: &predicates})
+	}
+
+	// Arma la estructura base
+	query := sdk.Conversationquery{
+		Order:          &order,
+		OrderBy:        &orderBy,
+		Interval:       &interval,
+		SegmentFilters: &segmentFilters,
+	}
+
+	if len(d.ConversationFilters) > 0 {
+		var conversationFilters []sdk.Conversationdetailqueryfilter
+		for _, filter := range d.ConversationFilters {
+			var predicates []sdk.Conversationdetailquerypredicate
+			for _, p := range filter.Predicates {
+				predicate := sdk.Conversationdetailquerypredicate{
+					Dimension: sdk.String(p.Dimension),
+					Operator:  sdk.String(p.operator()),
+				}
+				if p.Value != "" {
+					predicate.Value = sdk.String(p.Value)
+				}
+				predicates = append(predicates, predicate)
+			}
+			conversationFilters = append(conversationFilters, sdk.Conversationdetailqueryfilter{VarType: sdk.String(filter.Type), Predicates: &predicates})
+		}
+		query.ConversationFilters = &conversationFilters
+	}
+
+	return query, nil
+}
+
+// ParseInterval separa un intervalo ISO-8601 "inicio/fin" y valida que el
+// inicio sea anterior al fin.
+func ParseInterval(interval string) (time.Time, time.Time, error) {
+	parts := strings.Split(interval, "/")
+	if len(parts) != 2 {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid interval %q (expected start/end)", interval)
+	}
+	start, err := time.Parse(time.RFC3339, parts[0])
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid interval start %q: %w", parts[0], err)
+	}
+	end, err := time.Parse(time.RFC3339, parts[1])
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid interval end %q: %w", parts[1], err)
+	}
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid interval %q: start must be before end", interval)
+	}
+	return start, end, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package conversation_query
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyOptionsInterval(t *testing.T) {
+	const (
+		fileStart = "2025-01-01T00:00:00Z"
+		fileEnd   = "2025-01-31T00:00:00Z"
+		flagStart = "2025-01-10T00:00:00Z"
+		flagEnd   = "2025-01-20T00:00:00Z"
+	)
+	tests := []struct {
+		name       string
+		definition QueryDefinition
+		opts       QueryOptions
+		want       string // intervalo resultante, o el error esperado
+		wantErr    bool
+	}{
+		{"sin flags", QueryDefinition{Interval: fileStart + "/" + fileEnd}, QueryOptions{}, fileStart + "/" + fileEnd, false},
+		{"ambos flags", QueryDefinition{Interval: fileStart + "/" + fileEnd}, QueryOptions{StartTime: flagStart, EndTime: flagEnd}, flagStart + "/" + flagEnd, false},
+		{"sólo -start con interval", QueryDefinition{Interval: fileStart + "/" + fileEnd}, QueryOptions{StartTime: flagStart}, flagStart + "/" + fileEnd, false},
+		{"sólo -end con interval", QueryDefinition{Interval: fileStart + "/" + fileEnd}, QueryOptions{EndTime: flagEnd}, fileStart + "/" + flagEnd, false},
+		{"sólo -start con start y end", QueryDefinition{Start: fileStart, End: fileEnd}, QueryOptions{StartTime: flagStart}, flagStart + "/" + fileEnd, false},
+		{"sólo -start sin intervalo", QueryDefinition{}, QueryOptions{StartTime: flagStart}, "needs both start and end", true},
+		{"sólo -end posterior al fin", QueryDefinition{Interval: flagStart + "/" + flagEnd}, QueryOptions{StartTime: fileEnd}, "start must be before end", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			definition := test.definition
+			definition.ApplyOptions(test.opts)
+			err := definition.Validate()
+			if test.wantErr {
+				if err == nil || !strings.Contains(err.Error(), test.want) {
+					t.Errorf("got %v, want an error with %q", err, test.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := definition.interval(); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestApplyOptionsFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "query.yaml")
+	content := "interval: 2025-01-01T00:00:00Z/2025-01-31T00:00:00Z\nmediaTypes: [voice]\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	definition, err := LoadQueryDefinition(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	definition.ApplyOptions(QueryOptions{StartTime: "2025-01-15T00:00:00Z", MediaTypes: "chat, email"})
+	query, err := definition.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *query.Interval != "2025-01-15T00:00:00Z/2025-01-31T00:00:00Z" {
+		t.Errorf("got interval %q", *query.Interval)
+	}
+	if strings.Join(definition.MediaTypes, ",") != "chat,email" {
+		t.Errorf("got media types %v", definition.MediaTypes)
+	}
+}
@@ -2,137 +2,162 @@ package conversation_query
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/goDownloadRecording/logger"
 	sdk "github.com/mypurecloud/platform-client-sdk-go/v157/platformclientv2"
-	//"go.uber.org/zap"
+	"go.uber.org/zap"
 )
 
-func BuildConversationQuery() sdk.Conversationquery {
-	var endTime, startTime, order, orderBy, divisionId, originatingDirection string
+// QueryOptions reúne los parámetros de la consulta de conversaciones. Cada
+// campo tiene un flag equivalente y, si falta, puede pedirse por stdin.
+type QueryOptions struct {
+	StartTime            string
+	EndTime              string
+	Order                string
+	OrderBy              string
+	DivisionID           string
+	OriginatingDirection string
+	MediaTypes           string // separados por coma
+	DefinitionFile       string
+	RawFile              string
+}
 
-	logger.Log.Info("Start function BuildConversationQuery")
-	scanner := bufio.NewScanner(os.Stdin)
+var queryFlagNames = []string{"start", "end", "order", "order-by", "division", "direction", "media", "query-file", "query-raw"}
+
+// RegisterFlags registra los flags de la consulta en fs.
+func (o *QueryOptions) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.StartTime, "start", "", "interval start (yyyy-mm-ddThh:mm:ss-zz:zz)")
+	fs.StringVar(&o.EndTime, "end", "", "interval end (yyyy-mm-ddThh:mm:ss-zz:zz)")
+	fs.StringVar(&o.Order, "order", "", "sort order: desc or asc (default desc)")
+	fs.StringVar(&o.OrderBy, "order-by", "", "sort field: conversationStart, segmentStart or segmentEnd (default conversationStart)")
+	fs.StringVar(&o.DivisionID, "division", "", "division ID filter (empty for all divisions)")
+	fs.StringVar(&o.OriginatingDirection, "direction", "", "originatingDirection filter: inbound or outbound (empty to ignore)")
+	fs.StringVar(&o.MediaTypes, "media", "", "comma-separated media types to export: voice, chat, email, message, ... (default voice)")
+	fs.StringVar(&o.DefinitionFile, "query-file", "", "YAML/JSON query definition file (flags override its values)")
+	fs.StringVar(&o.RawFile, "query-raw", "", "JSON file with a Conversationquery sent verbatim")
+}
 
-	fmt.Println("Ingrese fecha de Inicio (formato: yyyy-mm-ddThh:mm:ss-zz:zz):")
-	if scanner.Scan() {
-		startTime = scanner.Text()
-	}
+// AnyFlagSet indica si se pasó al menos un flag de la consulta en fs.
+func AnyFlagSet(fs *flag.FlagSet) bool {
+	found := false
+	fs.Visit(func(f *flag.Flag) {
+		for _, name := range queryFlagNames {
+			if f.Name == name {
+				found = true
+			}
+		}
+	})
+	return found
+}
 
-	fmt.Println("Ingrese fecha Fin (formato: yyyy-mm-ddThh:mm:ss-zz:zz):")
-	if scanner.Scan() {
-		endTime = scanner.Text()
+// IsInteractive indica si stdin es una terminal, único caso en el que se
+// muestran los prompts.
+func IsInteractive() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
 	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
 
-	interval := startTime + "/" + endTime
-
-	fmt.Println("Ingrese el orden (desc / asc):")
-	if scanner.Scan() {
-		order = scanner.Text()
+// PromptMissing pide por stdin los valores que no llegaron por flags.
+// Las fechas se piden siempre que falten; los campos opcionales sólo cuando
+// promptOptional es true (modo interactivo completo, sin ningún flag).
+func (o *QueryOptions) PromptMissing(promptOptional bool) {
+	// Las definiciones en archivo están pensadas para correr sin preguntas
+	if o.DefinitionFile != "" || o.RawFile != "" {
+		return
 	}
-	if order == "" {
-		order = "desc"
+	scanner := bufio.NewScanner(os.Stdin)
+	ask := func(prompt string, target *string) {
+		fmt.Println(prompt)
+		if scanner.Scan() {
+			*target = strings.TrimSpace(scanner.Text())
+		}
 	}
 
-	fmt.Println("Ingrese el campo para ordenar (conversationStart, segmentStart, segmentEnd):")
-	if scanner.Scan() {
-		orderBy = scanner.Text()
+	if o.StartTime == "" {
+		ask("Ingrese fecha de Inicio (formato: yyyy-mm-ddThh:mm:ss-zz:zz):", &o.StartTime)
 	}
-	if orderBy == "" {
-		orderBy = "conversationStart"
+	if o.EndTime == "" {
+		ask("Ingrese fecha Fin (formato: yyyy-mm-ddThh:mm:ss-zz:zz):", &o.EndTime)
 	}
-
-	fmt.Println("Ingrese el ID de la División (o deje vacío para todas):")
-	if scanner.Scan() {
-		divisionId = scanner.Text()
+	if !promptOptional {
+		return
 	}
-
-	fmt.Println("¿Desea filtrar por dirección (originatingDirection)? (Ej: inbound, outbound, empty para ignorar):")
-	if scanner.Scan() {
-		originatingDirection = scanner.Text()
+	if o.Order == "" {
+		ask("Ingrese el orden (desc / asc):", &o.Order)
 	}
-
-	// Segment filters obligatorios
-	segmentFilters := &[]sdk.Segmentdetailqueryfilter{
-		{
-			VarType: sdk.String("and"),
-			Predicates: &[]sdk.Segmentdetailquerypredicate{
-				{
-					Dimension: sdk.String("mediaType"),
-					Operator:  sdk.String("matches"),
-					Value:     sdk.String("voice"),
-				},
-				{
-					Dimension: sdk.String("recording"),
-					Operator:  sdk.String("exists"),
-				},
-			},
-		},
+	if o.OrderBy == "" {
+		ask("Ingrese el campo para ordenar (conversationStart, segmentStart, segmentEnd):", &o.OrderBy)
 	}
-
-	// Arma la estructura base
-	query := sdk.Conversationquery{
-		Order:          &order,
-		OrderBy:        &orderBy,
-		Interval:       &interval,
-		SegmentFilters: segmentFilters,
+	if o.DivisionID == "" {
+		ask("Ingrese el ID de la División (o deje vacío para todas):", &o.DivisionID)
 	}
+	if o.OriginatingDirection == "" {
+		ask("¿Desea filtrar por dirección (originatingDirection)? (Ej: inbound, outbound, empty para ignorar):", &o.OriginatingDirection)
+	}
+}
 
-	// Filtro por división
-	if divisionId != "" {
-		query.ConversationFilters = &[]sdk.Conversationdetailqueryfilter{
-			{
-				VarType: sdk.String("or"),
-				Predicates: &[]sdk.Conversationdetailquerypredicate{
-					{
-						Dimension: sdk.String("divisionId"),
-						Operator:  sdk.String("matches"),
-						Value:     &divisionId,
-					},
-				},
-			},
-		}
+// BuildConversationQuery arma la consulta de analytics a partir de opts.
+// Con RawFile se usa el Conversationquery del archivo tal cual; con
+// DefinitionFile se parte de la definición y los flags la sobreescriben.
+func BuildConversationQuery(opts QueryOptions) (sdk.Conversationquery, error) {
+	logger.Log.Info("Start function BuildConversationQuery")
+
+	if opts.RawFile != "" {
+		return LoadRawQuery(opts.RawFile)
 	}
 
-	// Filtro por dirección de origen (optional)
-	if originatingDirection != "" {
-		filter := sdk.Conversationdetailqueryfilter{
-			VarType: sdk.String("or"),
-			Predicates: &[]sdk.Conversationdetailquerypredicate{
-				{
-					Dimension: sdk.String("originatingDirection"),
-					Operator:  sdk.String("matches"),
-					Value:     &originatingDirection,
-				},
-			},
+	def := &QueryDefinition{}
+	if opts.DefinitionFile != "" {
+		var err error
+		def, err = LoadQueryDefinition(opts.DefinitionFile)
+		if err != nil {
+			return sdk.Conversationquery{}, err
 		}
+	}
+	def.ApplyOptions(opts)
 
-		// Si ya hay filtros, los agregamos
-		if query.ConversationFilters != nil {
-			*query.ConversationFilters = append(*query.ConversationFilters, filter)
-		} else {
-			query.ConversationFilters = &[]sdk.Conversationdetailqueryfilter{filter}
-		}
+	query, err := def.Compile()
+	if err != nil {
+		return sdk.Conversationquery{}, err
 	}
 
 	// Mostrar JSON resultante (útil para debug)
 	b, _ := json.MarshalIndent(query, "", "  ")
-	fmt.Println("Consulta construida:")
-	fmt.Println(string(b))
+	logger.Log.Debug("Consulta construida", zap.ByteString("Query", b))
 
 	logger.Log.Info("End function BuildConversationQuery")
-	return query
+	return query, nil
 }
 
-func GetAllConversationsResults(api *sdk.AnalyticsApi, baseQuery sdk.Conversationquery) ([]sdk.Analyticsconversationwithoutattributes, error) {
+// GetAllConversationsResults pagina la consulta con el endpoint sincrónico.
+// Si Genesys informa más resultados de los que ese endpoint puede devolver
+// se avisa en el log: conviene usar GetConversations en modo auto o async.
+func GetAllConversationsResults(ctx context.Context, api *sdk.AnalyticsApi, baseQuery sdk.Conversationquery) ([]sdk.Analyticsconversationwithoutattributes, error) {
 	var allResults []sdk.Analyticsconversationwithoutattributes
+	err := syncPages(ctx, api, baseQuery, false, collect(&allResults))
+	return allResults, err
+}
+
+// syncPages pagina la consulta sincrónica y pasa cada página a emit. Con
+// stopAtLimit, si la primera página informa más de SyncMaxResults
+// resultados devuelve errSyncLimit sin haber emitido nada.
+func syncPages(ctx context.Context, api *sdk.AnalyticsApi, baseQuery sdk.Conversationquery, stopAtLimit bool, emit PageFunc) error {
 	pageSize := 100
 	pageNumber := 1
 
 	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		baseQuery.Paging = &sdk.Pagingspec{
 			PageSize:   &pageSize,
 			PageNumber: &pageNumber,
@@ -140,11 +165,23 @@ func GetAllConversationsResults(api *sdk.AnalyticsApi, baseQuery sdk.Conversatio
 
 		resp, _, err := api.PostAnalyticsConversationsDetailsQuery(baseQuery)
 		if err != nil {
-			return nil, fmt.Errorf("error en página %d: %w", pageNumber, err)
+			return fmt.Errorf("error en página %d: %w", pageNumber, err)
 		}
 
-		if resp.Conversations != nil {
-			allResults = append(allResults, *resp.Conversations...)
+		if pageNumber == 1 && resp.TotalHits != nil && *resp.TotalHits > SyncMaxResults {
+			if stopAtLimit {
+				return errSyncLimit
+			}
+			logger.Log.Warn("Query matches more conversations than the synchronous endpoint returns, results will be truncated",
+				zap.Int("TotalHits", *resp.TotalHits),
+				zap.Int("Limit", SyncMaxResults))
+		}
+
+		if resp.Conversations == nil {
+			break
+		}
+		if err := emit(*resp.Conversations); err != nil {
+			return err
 		}
 
 		if len(*resp.Conversations) < pageSize {
@@ -154,5 +191,5 @@ func GetAllConversationsResults(api *sdk.AnalyticsApi, baseQuery sdk.Conversatio
 		pageNumber++
 	}
 
-	return allResults, nil
+	return nil
 }
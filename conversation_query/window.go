@@ -0,0 +1,187 @@
+package conversation_query
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/goDownloadRecording/logger"
+	sdk "github.com/mypurecloud/platform-client-sdk-go/v157/platformclientv2"
+	"go.uber.org/zap"
+)
+
+// WindowResult es el resultado de la consulta en una ventana del intervalo.
+type WindowResult struct {
+	Interval      string
+	Conversations []sdk.Analyticsconversationwithoutattributes
+	Err           error
+}
+
+// SplitInterval divide start/end en ventanas consecutivas de largo window
+// (la última puede ser más corta). Las fechas conservan el huso horario de
+// start; si window es un múltiplo de 24h se avanza por días de calendario, de
+// modo que cada ventana corresponde a días locales enteros aunque haya un
+// cambio de horario en el medio. Con window <= 0 devuelve el intervalo
+// entero.
+func SplitInterval(start, end time.Time, window time.Duration) []string {
+	format := func(from, to time.Time) string {
+		return from.Format(time.RFC3339) + "/" + to.In(start.Location()).Format(time.RFC3339)
+	}
+	if window <= 0 {
+		if !start.Before(end) {
+			return nil
+		}
+		return []string{format(start, end)}
+	}
+	next := func(from time.Time) time.Time { return from.Add(window) }
+	if window%(24*time.Hour) == 0 {
+		days := int(window / (24 * time.Hour))
+		next = func(from time.Time) time.Time { return from.AddDate(0, 0, days) }
+	}
+
+	var intervals []string
+	for from := start; from.Before(end); from = next(from) {
+		to := next(from)
+		if to.After(end) {
+			to = end
+		}
+		intervals = append(intervals, format(from, to))
+	}
+	return intervals
+}
+
+// streamWindowed ejecuta la consulta por ventanas de opts.Window con hasta
+// opts.Workers ventanas en paralelo. Cada ventana se pasa a emit cuando
+// terminaron ella y todas las anteriores, de modo que se respeta el orden de
+// las ventanas (de la más nueva a la más vieja con orden descendente) sin
+// esperar a que termine todo el intervalo; para acotar la memoria no se
+// adelantan más de opts.Workers ventanas sin emitir. Las conversaciones que
+// cruzan el borde de una ventana y aparecen en las dos se emiten una sola
+// vez. Se informa en el log cuántas conversaciones trajo cada ventana; si
+// alguna falla se siguen emitiendo las demás y al final se devuelve error.
+func streamWindowed(ctx context.Context, api *sdk.AnalyticsApi, query sdk.Conversationquery, opts ExecOptions, emit PageFunc) error {
+	start, end, err := ParseInterval(*query.Interval)
+	if err != nil {
+		return err
+	}
+	intervals := SplitInterval(start, end, opts.Window)
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	logger.Log.Info("Splitting query interval",
+		zap.String("Interval", *query.Interval),
+		zap.Duration("Window", opts.Window),
+		zap.Int("Windows", len(intervals)),
+		zap.Int("Workers", workers))
+
+	// Con orden descendente se recorren las ventanas de la más nueva a la
+	// más vieja para respetar el orden pedido.
+	order := make([]int, len(intervals))
+	for i := range order {
+		order[i] = i
+		if query.Order != nil && *query.Order == "desc" {
+			order[i] = len(intervals) - 1 - i
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	results := make([]WindowResult, len(intervals))
+	done := make([]chan struct{}, len(intervals))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	ahead := make(chan struct{}, workers)
+	windowCh := make(chan int)
+
+	go func() {
+		defer close(windowCh)
+		for _, i := range order {
+			select {
+			case ahead <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case windowCh <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range windowCh {
+				windowQuery := query
+				interval := intervals[i]
+				windowQuery.Interval = &interval
+				var conversations []sdk.Analyticsconversationwithoutattributes
+				err := streamSingle(ctx, api, windowQuery, opts, collect(&conversations))
+				results[i] = WindowResult{Interval: interval, Conversations: conversations, Err: err}
+				close(done[i])
+			}
+		}()
+	}
+	// Al salir (también por error o cancelación) se frena al resto
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	var (
+		seen   = make(map[string]bool)
+		failed []string
+		total  int
+	)
+	for _, i := range order {
+		select {
+		case <-done[i]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		window := results[i]
+		results[i] = WindowResult{}
+		<-ahead
+
+		if window.Err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logger.Log.Error("Query window failed", zap.String("Interval", window.Interval), zap.Error(window.Err))
+			failed = append(failed, window.Interval)
+			continue
+		}
+		var page []sdk.Analyticsconversationwithoutattributes
+		for _, conv := range window.Conversations {
+			if conv.ConversationId != nil {
+				if seen[*conv.ConversationId] {
+					continue
+				}
+				seen[*conv.ConversationId] = true
+			}
+			page = append(page, conv)
+		}
+		logger.Log.Info("Query window completed",
+			zap.String("Interval", window.Interval),
+			zap.Int("Conversations", len(window.Conversations)),
+			zap.Int("New", len(page)))
+		total += len(page)
+		if len(page) > 0 {
+			if err := emit(page); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d query windows failed: %v", len(failed), len(intervals), failed)
+	}
+	logger.Log.Info("All query windows completed", zap.Int("Windows", len(intervals)), zap.Int("TotalConversations", total))
+	return nil
+}
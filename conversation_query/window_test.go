@@ -0,0 +1,63 @@
+package conversation_query
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSplitInterval(t *testing.T) {
+	utc := func(day, hour int) time.Time { return time.Date(2025, 1, day, hour, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name       string
+		start, end time.Time
+		window     time.Duration
+		want       []string
+	}{
+		{"ventanas exactas", utc(1, 0), utc(3, 0), 24 * time.Hour, []string{
+			"2025-01-01T00:00:00Z/2025-01-02T00:00:00Z",
+			"2025-01-02T00:00:00Z/2025-01-03T00:00:00Z",
+		}},
+		{"con resto", utc(1, 0), utc(1, 5), 2 * time.Hour, []string{
+			"2025-01-01T00:00:00Z/2025-01-01T02:00:00Z",
+			"2025-01-01T02:00:00Z/2025-01-01T04:00:00Z",
+			"2025-01-01T04:00:00Z/2025-01-01T05:00:00Z",
+		}},
+		{"ventana mayor al intervalo", utc(1, 0), utc(1, 6), 48 * time.Hour, []string{
+			"2025-01-01T00:00:00Z/2025-01-01T06:00:00Z",
+		}},
+		{"ventana cero", utc(1, 0), utc(2, 0), 0, []string{
+			"2025-01-01T00:00:00Z/2025-01-02T00:00:00Z",
+		}},
+		{"ventana negativa", utc(1, 0), utc(2, 0), -time.Hour, []string{
+			"2025-01-01T00:00:00Z/2025-01-02T00:00:00Z",
+		}},
+		{"intervalo vacío", utc(1, 0), utc(1, 0), time.Hour, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := SplitInterval(test.start, test.end, test.window); !slices.Equal(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSplitIntervalDST(t *testing.T) {
+	// En Nueva York el 9 de marzo de 2025 dura 23 horas: las ventanas de 24h
+	// siguen empezando a medianoche local
+	location, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	start := time.Date(2025, 3, 8, 0, 0, 0, 0, location)
+	end := time.Date(2025, 3, 11, 0, 0, 0, 0, location)
+	want := []string{
+		"2025-03-08T00:00:00-05:00/2025-03-09T00:00:00-05:00",
+		"2025-03-09T00:00:00-05:00/2025-03-10T00:00:00-04:00",
+		"2025-03-10T00:00:00-04:00/2025-03-11T00:00:00-04:00",
+	}
+	if got := SplitInterval(start, end, 24*time.Hour); !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,384 @@
+// Package crypt cifra las grabaciones en reposo con envelope encryption:
+// cada archivo se cifra con AES-256-GCM y una clave de datos propia,
+// aleatoria, que se guarda en el encabezado del archivo envuelta con la
+// clave de la organización (un archivo de clave simétrica o la clave pública
+// RSA de un destinatario). El contenido se cifra en bloques, de modo que se
+// puede escribir y leer en streaming sin cargar el archivo en memoria.
+//
+// Formato de un archivo cifrado:
+//
+//	"GDRENC01" | largo del encabezado (uint16 big endian) | encabezado JSON | bloques
+//
+// Cada bloque es el cifrado AES-GCM de hasta ChunkSize bytes con nonce
+// prefijo(8) || contador(4) y, como datos asociados, el encabezado y un
+// byte que marca el último bloque: no se pueden reordenar, quitar ni
+// agregar bloques sin que falle el descifrado.
+package crypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Extension se agrega al nombre de cada archivo cifrado.
+const Extension = ".enc"
+
+// ChunkSize es el tamaño de cada bloque de texto plano.
+const ChunkSize = 64 << 10
+
+// Algorithm es el cifrado del contenido.
+const Algorithm = "AES-256-GCM"
+
+// Formas de envolver la clave de datos.
+const (
+	WrapKeyFile = "AES-256-GCM"     // archivo de clave simétrica
+	WrapRSA     = "RSA-OAEP-SHA256" // clave pública de un destinatario
+)
+
+var magic = []byte("GDRENC01")
+
+const (
+	headerVersion = 1
+	prefixSize    = 8
+	dataKeySize   = 32
+)
+
+// Info describe cómo se cifró un archivo. Se registra en los sidecars para
+// saber qué clave hace falta para descifrarlo.
+type Info struct {
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"keyId"`
+	Wrap      string `json:"wrap"`
+}
+
+// header es el encabezado de un archivo cifrado.
+type header struct {
+	Version    int    `json:"v"`
+	Algorithm  string `json:"alg"`
+	ChunkSize  int    `json:"chunk"`
+	KeyID      string `json:"keyId"`
+	Wrap       string `json:"wrap"`
+	WrappedKey []byte `json:"wrappedKey"`
+	Prefix     []byte `json:"prefix"`
+}
+
+// Encrypter cifra archivos envolviendo cada clave de datos con la clave
+// configurada. Es seguro para uso concurrente.
+type Encrypter struct {
+	info Info
+	wrap func(dataKey []byte) ([]byte, error)
+}
+
+// NewEncrypter crea un Encrypter con un archivo de clave simétrica
+// (keyFile) o con la clave pública PEM de un destinatario (recipient). Con
+// los dos vacíos devuelve nil: sin cifrado.
+func NewEncrypter(keyFile, recipient string) (*Encrypter, error) {
+	switch {
+	case keyFile != "" && recipient != "":
+		return nil, errors.New("use either an encryption key file or a recipient public key, not both")
+	case keyFile != "":
+		key, err := LoadKeyFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &Encrypter{info: Info{Algorithm: Algorithm, KeyID: key.ID, Wrap: WrapKeyFile}, wrap: key.wrap}, nil
+	case recipient != "":
+		public, err := LoadRecipient(recipient)
+		if err != nil {
+			return nil, err
+		}
+		return &Encrypter{info: Info{Algorithm: Algorithm, KeyID: public.ID, Wrap: WrapRSA}, wrap: public.wrap}, nil
+	}
+	return nil, nil
+}
+
+// Info devuelve cómo cifra e (nil si e es nil).
+func (e *Encrypter) Info() *Info {
+	if e == nil {
+		return nil
+	}
+	info := e.info
+	return &info
+}
+
+// NewWriter escribe en w el encabezado de un archivo nuevo, con su propia
+// clave de datos, y devuelve el writer que cifra el contenido. Close escribe
+// el último bloque (sin cerrar w); sin Close el archivo queda incompleto y
+// no se puede descifrar.
+func (e *Encrypter) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	wrapped, err := e.wrap(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("wrapping data key: %w", err)
+	}
+	h := header{
+		Version:    headerVersion,
+		Algorithm:  Algorithm,
+		ChunkSize:  ChunkSize,
+		KeyID:      e.info.KeyID,
+		Wrap:       e.info.Wrap,
+		WrappedKey: wrapped,
+		Prefix:     make([]byte, prefixSize),
+	}
+	if _, err := rand.Read(h.Prefix); err != nil {
+		return nil, err
+	}
+	encoded, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var start bytes.Buffer
+	start.Write(magic)
+	binary.Write(&start, binary.BigEndian, uint16(len(encoded)))
+	start.Write(encoded)
+	if _, err := w.Write(start.Bytes()); err != nil {
+		return nil, err
+	}
+	return &writer{w: w, aead: aead, header: encoded, prefix: h.Prefix, buf: make([]byte, 0, ChunkSize)}, nil
+}
+
+// Seal cifra data de una sola vez, para archivos chicos.
+func (e *Encrypter) Seal(data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	w, err := e.NewWriter(&out)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+type writer struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	counter uint32
+	buf     []byte
+	closed  bool
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed encrypted writer")
+	}
+	written := 0
+	for len(p) > 0 {
+		// El bloque se cifra recién cuando llegan más datos: hasta el Close
+		// no se sabe cuál es el último
+		if len(w.buf) == ChunkSize {
+			if err := w.flush(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(w.buf[len(w.buf):ChunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.flush(true)
+}
+
+func (w *writer) flush(last bool) error {
+	sealed := w.aead.Seal(nil, nonce(w.prefix, w.counter), w.buf, additionalData(w.header, last))
+	w.counter++
+	w.buf = w.buf[:0]
+	_, err := w.w.Write(sealed)
+	return err
+}
+
+// Keyring reúne las claves con las que se puede descifrar, por KeyID. Sirve
+// para leer archivos cifrados con claves anteriores a una rotación.
+type Keyring struct {
+	unwrap map[string]func(wrapped []byte) ([]byte, error)
+}
+
+// LoadKeyring carga claves de descifrado: archivos de clave simétrica o
+// claves privadas RSA en PEM.
+func LoadKeyring(paths []string) (*Keyring, error) {
+	k := &Keyring{unwrap: make(map[string]func([]byte) ([]byte, error))}
+	for _, path := range paths {
+		if private, err := LoadPrivateKey(path); err == nil {
+			k.unwrap[private.ID] = private.unwrap
+			continue
+		} else if !errors.Is(err, errNotPEM) {
+			return nil, err
+		}
+		key, err := LoadKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		k.unwrap[key.ID] = key.unwrap
+	}
+	return k, nil
+}
+
+// KeyIDs devuelve los KeyID disponibles.
+func (k *Keyring) KeyIDs() []string {
+	ids := make([]string, 0, len(k.unwrap))
+	for id := range k.unwrap {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// ErrUnknownKey indica que no se tiene la clave con la que se cifró el archivo.
+var ErrUnknownKey = errors.New("no key for this file")
+
+// NewReader lee el encabezado de r y devuelve un reader con el contenido
+// descifrado. Un archivo alterado o truncado produce un error al leer.
+func (k *Keyring) NewReader(r io.Reader) (io.Reader, Info, error) {
+	h, encoded, err := readHeader(r)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	info := Info{Algorithm: h.Algorithm, KeyID: h.KeyID, Wrap: h.Wrap}
+	unwrap, ok := k.unwrap[h.KeyID]
+	if !ok {
+		return nil, info, fmt.Errorf("%w (key ID %s)", ErrUnknownKey, h.KeyID)
+	}
+	dataKey, err := unwrap(h.WrappedKey)
+	if err != nil {
+		return nil, info, fmt.Errorf("unwrapping data key: %w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, info, err
+	}
+	return &reader{
+		r:      bufio.NewReaderSize(r, h.ChunkSize+aead.Overhead()+1),
+		aead:   aead,
+		header: encoded,
+		prefix: h.Prefix,
+		chunk:  make([]byte, h.ChunkSize+aead.Overhead()),
+	}, info, nil
+}
+
+// ReadInfo lee sólo el encabezado de un archivo cifrado.
+func ReadInfo(r io.Reader) (Info, error) {
+	h, _, err := readHeader(r)
+	if err != nil {
+		return Info{}, err
+	}
+	return Info{Algorithm: h.Algorithm, KeyID: h.KeyID, Wrap: h.Wrap}, nil
+}
+
+func readHeader(r io.Reader) (header, []byte, error) {
+	var h header
+	start := make([]byte, len(magic)+2)
+	if _, err := io.ReadFull(r, start); err != nil || !bytes.Equal(start[:len(magic)], magic) {
+		return h, nil, errors.New("not an encrypted recording")
+	}
+	encoded := make([]byte, binary.BigEndian.Uint16(start[len(magic):]))
+	if _, err := io.ReadFull(r, encoded); err != nil {
+		return h, nil, fmt.Errorf("reading encryption header: %w", err)
+	}
+	if err := json.Unmarshal(encoded, &h); err != nil {
+		return h, nil, fmt.Errorf("invalid encryption header: %w", err)
+	}
+	if h.Version != headerVersion || h.Algorithm != Algorithm || h.ChunkSize <= 0 || h.ChunkSize > 16<<20 || len(h.Prefix) != prefixSize {
+		return h, nil, fmt.Errorf("unsupported encryption header (version %d, %s)", h.Version, h.Algorithm)
+	}
+	return h, encoded, nil
+}
+
+type reader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	counter uint32
+	chunk   []byte
+	plain   []byte
+	done    bool
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// next descifra el bloque siguiente. Es el último si no le sigue nada.
+func (r *reader) next() error {
+	n, err := io.ReadFull(r.r, r.chunk)
+	switch {
+	case err == io.EOF:
+		return errors.New("encrypted recording is truncated")
+	case err == io.ErrUnexpectedEOF:
+		r.done = true
+	case err != nil:
+		return err
+	default:
+		if _, err := r.r.Peek(1); err == io.EOF {
+			r.done = true
+		}
+	}
+	plain, err := r.aead.Open(r.chunk[:0], nonce(r.prefix, r.counter), r.chunk[:n], additionalData(r.header, r.done))
+	if err != nil {
+		return errors.New("encrypted recording is damaged or truncated")
+	}
+	r.counter++
+	r.plain = plain
+	return nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func nonce(prefix []byte, counter uint32) []byte {
+	n := make([]byte, prefixSize+4)
+	copy(n, prefix)
+	binary.BigEndian.PutUint32(n[prefixSize:], counter)
+	return n
+}
+
+func additionalData(header []byte, last bool) []byte {
+	flag := byte(0)
+	if last {
+		flag = 1
+	}
+	return append(append([]byte{}, header...), flag)
+}
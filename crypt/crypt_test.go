@@ -0,0 +1,211 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testKeys escribe una clave simétrica y un par RSA en dir y devuelve, para
+// cada uno, el Encrypter y el Keyring que lo descifra.
+func testKeys(t *testing.T, dir string) map[string]struct {
+	enc     *Encrypter
+	keyring *Keyring
+} {
+	t.Helper()
+	secret := make([]byte, dataKeySize)
+	if _, err := rand.Read(secret); err != nil {
+		t.Fatal(err)
+	}
+	keyFile := writeFile(t, dir, "recordings.key", []byte(hex.EncodeToString(secret)+"\n"))
+
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicFile := writeFile(t, dir, "public.pem", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}))
+	privateFile := writeFile(t, dir, "private.pem", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(private)}))
+
+	keys := make(map[string]struct {
+		enc     *Encrypter
+		keyring *Keyring
+	})
+	for name, files := range map[string][2]string{"key-file": {keyFile, ""}, "rsa": {"", publicFile}} {
+		enc, err := NewEncrypter(files[0], files[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		decryptKey := files[0]
+		if decryptKey == "" {
+			decryptKey = privateFile
+		}
+		keyring, err := LoadKeyring([]string{decryptKey})
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[name] = struct {
+			enc     *Encrypter
+			keyring *Keyring
+		}{enc, keyring}
+	}
+	return keys
+}
+
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func open(keyring *Keyring, sealed []byte) ([]byte, Info, error) {
+	r, info, err := keyring.NewReader(bytes.NewReader(sealed))
+	if err != nil {
+		return nil, info, err
+	}
+	plain, err := io.ReadAll(r)
+	return plain, info, err
+}
+
+func TestRoundTrip(t *testing.T) {
+	sizes := []int{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 3*ChunkSize + 17}
+	for name, key := range testKeys(t, t.TempDir()) {
+		for _, size := range sizes {
+			data := randomBytes(t, size)
+
+			// Seal y NewWriter con escrituras de a poco producen el mismo formato
+			sealed, err := key.enc.Seal(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var streamed bytes.Buffer
+			w, err := key.enc.NewWriter(&streamed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for rest := data; len(rest) > 0; {
+				n := min(len(rest), 1000)
+				if _, err := w.Write(rest[:n]); err != nil {
+					t.Fatal(err)
+				}
+				rest = rest[n:]
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			for kind, encrypted := range map[string][]byte{"seal": sealed, "stream": streamed.Bytes()} {
+				plain, info, err := open(key.keyring, encrypted)
+				if err != nil {
+					t.Fatalf("%s/%s/%d: %v", name, kind, size, err)
+				}
+				if !bytes.Equal(plain, data) {
+					t.Fatalf("%s/%s/%d: decrypted content differs", name, kind, size)
+				}
+				if info != *key.enc.Info() {
+					t.Errorf("%s/%s/%d: got info %+v, want %+v", name, kind, size, info, *key.enc.Info())
+				}
+			}
+		}
+	}
+}
+
+func TestTamper(t *testing.T) {
+	keys := testKeys(t, t.TempDir())
+	key := keys["key-file"]
+	data := randomBytes(t, 2*ChunkSize+100)
+	sealed, err := key.enc.Seal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headerLen := len(magic) + 2 + int(binary.BigEndian.Uint16(sealed[len(magic):]))
+	chunk := ChunkSize + 16 // bloque cifrado con su tag de GCM
+
+	tests := []struct {
+		name   string
+		modify func([]byte) []byte
+	}{
+		{"byte del primer bloque", func(b []byte) []byte { b[headerLen+10] ^= 1; return b }},
+		{"byte del último bloque", func(b []byte) []byte { b[len(b)-1] ^= 1; return b }},
+		{"byte del encabezado", func(b []byte) []byte { b[headerLen-3] ^= 1; return b }},
+		{"truncado a mitad de bloque", func(b []byte) []byte { return b[:len(b)-50] }},
+		{"sin el último bloque", func(b []byte) []byte { return b[:headerLen+2*chunk] }},
+		{"sin bloques", func(b []byte) []byte { return b[:headerLen] }},
+		{"sin un bloque del medio", func(b []byte) []byte {
+			return append(append([]byte{}, b[:headerLen+chunk]...), b[headerLen+2*chunk:]...)
+		}},
+		{"bloques invertidos", func(b []byte) []byte {
+			out := append([]byte{}, b[:headerLen]...)
+			out = append(out, b[headerLen+chunk:headerLen+2*chunk]...)
+			out = append(out, b[headerLen:headerLen+chunk]...)
+			return append(out, b[headerLen+2*chunk:]...)
+		}},
+		{"bloque agregado", func(b []byte) []byte { return append(b, b[headerLen:headerLen+chunk]...) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tampered := test.modify(append([]byte{}, sealed...))
+			if plain, _, err := open(key.keyring, tampered); err == nil {
+				t.Fatalf("tampered file decrypted (%d bytes)", len(plain))
+			}
+		})
+	}
+}
+
+func TestWrongKey(t *testing.T) {
+	keys := testKeys(t, t.TempDir())
+	other := testKeys(t, t.TempDir())
+	for name, key := range keys {
+		sealed, err := key.enc.Seal([]byte("contenido"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := open(other[name].keyring, sealed); !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("%s: got %v, want ErrUnknownKey", name, err)
+		}
+		info, err := ReadInfo(bytes.NewReader(sealed))
+		if err != nil || info.KeyID != key.enc.Info().KeyID {
+			t.Errorf("%s: ReadInfo got %+v, %v", name, info, err)
+		}
+	}
+}
+
+func TestNotEncrypted(t *testing.T) {
+	keys := testKeys(t, t.TempDir())
+	if _, _, err := open(keys["key-file"].keyring, []byte("RIFF....WAVEfmt ")); err == nil {
+		t.Error("plain file accepted as encrypted")
+	}
+}
+
+func TestNewEncrypterBothKeys(t *testing.T) {
+	if _, err := NewEncrypter("a.key", "b.pem"); err == nil {
+		t.Error("expected an error with a key file and a recipient")
+	}
+	if enc, err := NewEncrypter("", ""); enc != nil || err != nil {
+		t.Errorf("got %v, %v without keys", enc, err)
+	}
+}
@@ -0,0 +1,177 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Key es una clave simétrica de 32 bytes que envuelve las claves de datos.
+type Key struct {
+	// ID identifica la clave sin revelarla: los primeros bytes de su SHA-256.
+	ID     string
+	secret []byte
+}
+
+// LoadKeyFile lee un archivo de clave simétrica: 32 bytes en hexadecimal
+// (p.ej. generado con "openssl rand -hex 32"), en base64 o crudos.
+func LoadKeyFile(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading encryption key: %w", err)
+	}
+	text := bytes.TrimSpace(data)
+	var secret []byte
+	if decoded, err := hex.DecodeString(string(text)); err == nil && len(decoded) == dataKeySize {
+		secret = decoded
+	} else if decoded, err := base64.StdEncoding.DecodeString(string(text)); err == nil && len(decoded) == dataKeySize {
+		secret = decoded
+	} else if len(data) == dataKeySize {
+		secret = data
+	} else {
+		return nil, fmt.Errorf("encryption key %s must hold 32 bytes (hex, base64 or raw)", path)
+	}
+	return &Key{ID: keyID("k", secret), secret: secret}, nil
+}
+
+func (k *Key) wrap(dataKey []byte) ([]byte, error) {
+	aead, err := newAEAD(k.secret)
+	if err != nil {
+		return nil, err
+	}
+	n := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(n); err != nil {
+		return nil, err
+	}
+	return aead.Seal(n, n, dataKey, []byte(k.ID)), nil
+}
+
+func (k *Key) unwrap(wrapped []byte) ([]byte, error) {
+	aead, err := newAEAD(k.secret)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped key too short")
+	}
+	return aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(k.ID))
+}
+
+// PublicKey es la clave pública RSA de un destinatario: quien tenga la
+// privada puede descifrar, pero este programa no.
+type PublicKey struct {
+	ID  string
+	key *rsa.PublicKey
+}
+
+// LoadRecipient lee una clave pública RSA en PEM (PUBLIC KEY, RSA PUBLIC
+// KEY o un certificado).
+func LoadRecipient(path string) (*PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	var key interface{}
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q for a recipient", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	public, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: recipient key must be RSA", path)
+	}
+	return newPublicKey(public)
+}
+
+func newPublicKey(public *rsa.PublicKey) (*PublicKey, error) {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+	return &PublicKey{ID: keyID("rsa", der), key: public}, nil
+}
+
+func (p *PublicKey) wrap(dataKey []byte) ([]byte, error) {
+	return rsa.EncryptOAEP(sha256.New(), rand.Reader, p.key, dataKey, []byte(p.ID))
+}
+
+// PrivateKey es la clave privada RSA que corresponde a un destinatario.
+type PrivateKey struct {
+	ID  string
+	key *rsa.PrivateKey
+}
+
+// errNotPEM indica que el archivo no es PEM (y puede ser una clave simétrica).
+var errNotPEM = errors.New("not a PEM file")
+
+// LoadPrivateKey lee una clave privada RSA en PEM (PKCS#1 o PKCS#8, sin
+// contraseña).
+func LoadPrivateKey(path string) (*PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q for a decryption key", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	private, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: decryption key must be RSA", path)
+	}
+	public, err := newPublicKey(&private.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &PrivateKey{ID: public.ID, key: private}, nil
+}
+
+func (p *PrivateKey) unwrap(wrapped []byte) ([]byte, error) {
+	return rsa.DecryptOAEP(sha256.New(), nil, p.key, wrapped, []byte(p.ID))
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: %w", path, errNotPEM)
+	}
+	return block, nil
+}
+
+// keyID deriva un identificador estable de la clave: kind-<16 hex>.
+func keyID(kind string, material []byte) string {
+	sum := sha256.Sum256(material)
+	return kind + "-" + hex.EncodeToString(sum[:8])
+}
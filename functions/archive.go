@@ -0,0 +1,151 @@
+package functions
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/goDownloadRecording/archive"
+	"github.com/goDownloadRecording/journal"
+	"github.com/goDownloadRecording/logger"
+	"github.com/goDownloadRecording/storage"
+	sdk "github.com/mypurecloud/platform-client-sdk-go/v157/platformclientv2"
+	"go.uber.org/zap"
+)
+
+// FilterArchived quita del batch las grabaciones que ya están descargadas y
+// verificadas en el archivo, para no pedírselas de nuevo a Genesys. Una
+// grabación cuenta como archivada si está en el índice y su objeto sigue en
+// el backend con el tamaño registrado. Las omitidas quedan como verificadas
+// en el journal.
+func FilterArchived(requests []sdk.Batchdownloadrequest, backend storage.Backend, index *archive.Index, j *journal.Journal) []sdk.Batchdownloadrequest {
+	if index == nil {
+		return requests
+	}
+
+	var pending []sdk.Batchdownloadrequest
+	skipped := 0
+	for _, request := range requests {
+		if IsArchived(request, backend, index, j) {
+			skipped++
+			continue
+		}
+		pending = append(pending, request)
+	}
+
+	if skipped > 0 {
+		logger.Log.Info("Skipping recordings already in the archive",
+			zap.Int("Skipped", skipped),
+			zap.Int("Pending", len(pending)))
+	}
+	return pending
+}
+
+// IsArchived indica si la grabación de request ya está en el archivo (ver
+// FilterArchived) y, en ese caso, la registra como verificada en el journal.
+func IsArchived(request sdk.Batchdownloadrequest, backend storage.Backend, index *archive.Index, j *journal.Journal) bool {
+	if index == nil {
+		return false
+	}
+	recordingID := getString(request.RecordingId)
+	entry, ok := index.Lookup(recordingID)
+	if !ok || !isArchived(backend, entry) {
+		return false
+	}
+	recordJournal(j, journal.Entry{
+		Stage:          journal.StageVerified,
+		ConversationID: getString(request.ConversationId),
+		RecordingID:    recordingID,
+		Path:           entry.Path,
+		SHA256:         entry.SHA256,
+	})
+	return true
+}
+
+// isArchived indica si el objeto de una entrada sigue en el backend con el
+// tamaño registrado o, si ya se empaquetó, si su paquete existe. Una
+// grabación borrada por retención cuenta como archivada: no se vuelve a
+// descargar.
+func isArchived(backend storage.Backend, entry archive.Entry) bool {
+	if entry.PurgedAt != nil {
+		return true
+	}
+	if entry.Bundle != "" {
+		_, err := os.Stat(entry.Bundle)
+		return err == nil
+	}
+	size, exists, err := backend.Stat(entry.Path)
+	if err != nil {
+		logger.Log.Warn("Failed to check archived recording", zap.String("Key", entry.Path), zap.Error(err))
+		return false
+	}
+	return exists && size == entry.Size
+}
+
+// ReindexDownloads agrega al índice las grabaciones de outputDir que no
+// estén indexadas (por ejemplo, descargadas antes de que existiera el
+// índice). Recorre todas las subcarpetas; los ids salen del sidecar JSON de
+// cada grabación y, si no lo tiene, de la estructura por defecto (carpeta
+// yymmdd-conversationId y archivo recordingId). Sólo se indexan archivos no
+// vacíos. Sólo aplica al storage local. Devuelve cuántas entradas se
+// agregaron.
+func ReindexDownloads(outputDir string, index *archive.Index) (int, error) {
+	added := 0
+	err := filepath.WalkDir(outputDir, func(filePath string, file fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if file.IsDir() || !isRecordingFile(file.Name()) {
+			return nil
+		}
+		recordingID, conversationID := recordingIDs(filePath)
+		if recordingID == "" {
+			return nil
+		}
+		if _, ok := index.Lookup(recordingID); ok {
+			return nil
+		}
+		info, err := file.Info()
+		if err != nil || info.Size() == 0 {
+			return nil
+		}
+		sha, err := FileSHA256(filePath)
+		if err != nil {
+			return err
+		}
+		key, err := filepath.Rel(outputDir, filePath)
+		if err != nil {
+			return err
+		}
+		if err := index.Add(archive.Entry{
+			RecordingID:    recordingID,
+			ConversationID: conversationID,
+			Path:           filepath.ToSlash(key),
+			Size:           info.Size(),
+			SHA256:         sha,
+			DownloadedAt:   info.ModTime().UTC(),
+		}); err != nil {
+			return err
+		}
+		added++
+		return nil
+	})
+	return added, err
+}
+
+// recordingIDs devuelve el recordingId y el conversationId de una grabación
+// descargada, leyendo su sidecar o, si no lo tiene, deduciéndolos de la
+// estructura por defecto.
+func recordingIDs(filePath string) (recordingID, conversationID string) {
+	var sidecar RecordingSidecar
+	if data, err := os.ReadFile(sidecarKey(filePath)); err == nil && json.Unmarshal(data, &sidecar) == nil && sidecar.Recording.RecordingID != "" {
+		return sidecar.Recording.RecordingID, sidecar.Recording.ConversationID
+	}
+	_, conversationID, ok := strings.Cut(filepath.Base(filepath.Dir(filePath)), "-")
+	if !ok {
+		return "", ""
+	}
+	return recordingBase(filepath.Base(filePath)), conversationID
+}
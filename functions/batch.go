@@ -1,28 +1,28 @@
 package functions
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
+	"github.com/goDownloadRecording/journal"
 	"github.com/goDownloadRecording/logger"
 	sdk "github.com/mypurecloud/platform-client-sdk-go/v157/platformclientv2"
 	"go.uber.org/zap"
 )
 
-const (
-	maxRetries   = 3
-	retryDelay   = 2 * time.Second
-	maxBatchSize = 100
-)
+const maxBatchSize = 100
 
 // AddConversationRecordingsToBatch consulta metadata de grabaciones en paralelo y construye el batch.
-func AddConversationRecordingsToBatch(conversationIDs []string, recordingApi *sdk.RecordingApi, workers int) ([]sdk.Batchdownloadrequest, error) {
+// Si ctx se cancela, los workers no toman conversaciones nuevas y se devuelve
+// lo obtenido hasta ese momento junto con el error del contexto.
+func AddConversationRecordingsToBatch(ctx context.Context, conversationIDs []string, recordingApi *sdk.RecordingApi, workers int, j *journal.Journal, details *Details, exclude map[string]bool) ([]sdk.Batchdownloadrequest, error) {
 	var (
 		batchRequests  []sdk.Batchdownloadrequest
-		mu             sync.Mutex
-		wg             sync.WaitGroup
 		conversationCh = make(chan string, len(conversationIDs))
+		requestCh      = make(chan sdk.Batchdownloadrequest, maxBatchSize)
 	)
 
 	// Cargar todas las conversationIDs al canal
@@ -31,44 +31,76 @@ func AddConversationRecordingsToBatch(conversationIDs []string, recordingApi *sd
 	}
 	close(conversationCh)
 
+	go func() {
+		StreamRecordingMetadata(ctx, conversationCh, recordingApi, workers, j, details, exclude, requestCh)
+		close(requestCh)
+	}()
+	for request := range requestCh {
+		batchRequests = append(batchRequests, request)
+	}
+
+	if err := ctx.Err(); err != nil {
+		logger.Log.Warn("Metadata fetch interrupted", zap.Int("Recordings", len(batchRequests)))
+		return batchRequests, err
+	}
+	if len(batchRequests) == 0 {
+		logger.Log.Warn("No recordings found to include in the batch.")
+		return nil, nil
+	}
+
+	logger.Log.Info("✅ Batch ready", zap.Int("TotalRecordings", len(batchRequests)))
+	return batchRequests, nil
+}
+
+// StreamRecordingMetadata consulta con un pool de workers la metadata de
+// cada conversación que llega por conversationIDs y envía a out una
+// solicitud por grabación. Vuelve cuando se cierra conversationIDs (o se
+// cancela ctx) y terminaron todos los workers; no cierra out. Si out no se
+// lee, los workers se frenan. La metadata obtenida se guarda en details para
+// los sidecars. Las grabaciones cuyo media está en exclude (p.ej. "screen")
+// no se piden ni se registran en el journal.
+func StreamRecordingMetadata(ctx context.Context, conversationIDs <-chan string, recordingApi *sdk.RecordingApi, workers int, j *journal.Journal, details *Details, exclude map[string]bool, out chan<- sdk.Batchdownloadrequest) {
+	var wg sync.WaitGroup
+
 	// Pool de workers
 	for i := 0; i < workers; i++ {
 		wg.Add(1)
 		go func(workerID int) {
 			defer wg.Done()
-			for conversationID := range conversationCh {
+			for conversationID := range conversationIDs {
+				if ctx.Err() != nil {
+					// Quedan sin metadata en el journal: resume las retoma
+					continue
+				}
 				logger.Log.Debug("🧵 Worker fetching metadata",
 					zap.Int("WorkerID", workerID),
 					zap.String("ConversationID", conversationID))
 
-				var recordingsData []sdk.Recordingmetadata
-				var err error
-
-				for attempt := 1; attempt <= maxRetries; attempt++ {
-					var response []sdk.Recordingmetadata
-					response, _, err = recordingApi.GetConversationRecordingmetadata(conversationID)
-					if err == nil {
-						recordingsData = response
-						break
-					}
-
-					logger.Log.Warn("Retrying metadata fetch...",
-						zap.String("ConversationID", conversationID),
-						zap.Int("Attempt", attempt),
-						zap.Error(err))
-
-					time.Sleep(retryDelay * time.Duration(attempt))
+				// Los reintentos ante 429/5xx los hace el governor del cliente HTTP
+				recordingsData, _, err := recordingApi.GetConversationRecordingmetadata(conversationID)
+				if err != nil && isCanceled(ctx, err) {
+					continue
 				}
-
 				if err != nil {
-					logger.Log.Error("Failed to fetch metadata after retries",
+					logger.Log.Error("Failed to fetch recording metadata",
 						zap.String("ConversationID", conversationID),
 						zap.Error(err))
+					recordJournal(j, journal.Entry{Stage: journal.StageFailed, ConversationID: conversationID, Error: err.Error()})
+					details.Forget(conversationID)
 					continue
 				}
+				details.AddRecordings(conversationID, recordingsData)
 
 				var localBatch []sdk.Batchdownloadrequest
 				for _, recording := range recordingsData {
+					if exclude[getString(recording.Media)] {
+						// Sigue en los sidecars de la conversación, pero no se descarga
+						logger.Log.Debug("Skipping excluded recording",
+							zap.String("RecordingID", getString(recording.Id)),
+							zap.String("Media", getString(recording.Media)))
+						details.Done(conversationID)
+						continue
+					}
 					if recording.Id != nil && recording.ConversationId != nil {
 						localBatch = append(localBatch, sdk.Batchdownloadrequest{
 							ConversationId: recording.ConversationId,
@@ -77,30 +109,33 @@ func AddConversationRecordingsToBatch(conversationIDs []string, recordingApi *sd
 						logger.Log.Debug("Added recording",
 							zap.String("ConversationID", *recording.ConversationId),
 							zap.String("RecordingID", *recording.Id))
+						recordJournal(j, journal.Entry{Stage: journal.StageMetadata, ConversationID: conversationID, RecordingID: *recording.Id, Media: getString(recording.Media)})
+					} else {
+						// Sin ids no se puede descargar: no queda pendiente en la cache
+						details.Done(conversationID)
 					}
 				}
+				if len(localBatch) == 0 {
+					recordJournal(j, journal.Entry{Stage: journal.StageMetadata, ConversationID: conversationID})
+				}
 
-				// Agregar al batch global con mutex
-				mu.Lock()
-				batchRequests = append(batchRequests, localBatch...)
-				mu.Unlock()
+				for _, request := range localBatch {
+					select {
+					case out <- request:
+					case <-ctx.Done():
+					}
+				}
 			}
 		}(i)
 	}
 
 	wg.Wait()
-
-	if len(batchRequests) == 0 {
-		logger.Log.Warn("No recordings found to include in the batch.")
-		return nil, nil
-	}
-
-	logger.Log.Info("✅ Batch ready", zap.Int("TotalRecordings", len(batchRequests)))
-	return batchRequests, nil
 }
 
-// SendBatchRequests divide las solicitudes en lotes y los envía por separado
-func SendBatchRequests(recordApi *sdk.RecordingApi, batchRequests []sdk.Batchdownloadrequest) ([]*sdk.Batchdownloadjobsubmissionresult, error) {
+// SendBatchRequests divide las solicitudes en lotes y los envía por separado.
+// Si ctx se cancela no se envían más lotes y se devuelven los ya enviados
+// junto con el error del contexto.
+func SendBatchRequests(ctx context.Context, recordApi *sdk.RecordingApi, batchRequests []sdk.Batchdownloadrequest, j *journal.Journal) ([]*sdk.Batchdownloadjobsubmissionresult, error) {
 	if len(batchRequests) == 0 {
 		logger.Log.Warn("Empty batch request. No recordings to submit.")
 		return nil, nil
@@ -109,6 +144,10 @@ func SendBatchRequests(recordApi *sdk.RecordingApi, batchRequests []sdk.Batchdow
 	var results []*sdk.Batchdownloadjobsubmissionresult
 
 	for i := 0; i < len(batchRequests); i += maxBatchSize {
+		if err := ctx.Err(); err != nil {
+			logger.Log.Warn("Batch submission interrupted", zap.Int("Sent", i), zap.Int("Total", len(batchRequests)))
+			return results, err
+		}
 		end := i + maxBatchSize
 		if end > len(batchRequests) {
 			end = len(batchRequests)
@@ -117,21 +156,16 @@ func SendBatchRequests(recordApi *sdk.RecordingApi, batchRequests []sdk.Batchdow
 		chunk := batchRequests[i:end]
 		chunkCopy := chunk // evitar referencia al slice original
 
-		batchRequest := sdk.Batchdownloadjobsubmission{
-			BatchDownloadRequestList: &chunkCopy,
+		resp, err := submitBatch(recordApi, chunkCopy, j)
+		if err != nil && isCanceled(ctx, err) {
+			return results, err
 		}
-
-		resp, _, err := recordApi.PostRecordingBatchrequests(batchRequest)
 		if err != nil {
 			logger.Log.Error("❌ Error sending partial batch request",
 				zap.Int("Start", i), zap.Int("End", end), zap.Error(err))
 			continue
 		}
 
-		logger.Log.Info("✅ Partial batch sent",
-			zap.String("BatchID", *resp.Id),
-			zap.Int("Count", len(chunkCopy)))
-
 		results = append(results, resp)
 	}
 
@@ -142,8 +176,110 @@ func SendBatchRequests(recordApi *sdk.RecordingApi, batchRequests []sdk.Batchdow
 	return results, nil
 }
 
-// PollBatchJobUntilReady consulta hasta que el batch esté listo
-func PollBatchJobUntilReady(recordApi *sdk.RecordingApi, jobID string, maxRetries int, delay time.Duration) (*sdk.Batchdownloadjobstatusresult, error) {
+// SubmittedJob es un batch job enviado, con las solicitudes que incluye.
+type SubmittedJob struct {
+	ID       string
+	Requests []sdk.Batchdownloadrequest
+}
+
+// SubmitBatches arma lotes de hasta maxBatchSize con las solicitudes que
+// llegan por requests y envía cada lote apenas se completa; el último se
+// envía con lo que quede al cerrarse requests. Las solicitudes para las que
+// skip devuelve true no se envían. Cada job enviado se pasa a jobs: si nadie
+// lo lee, el envío se frena y deja de leer requests. Las solicitudes de un
+// lote que no se pudo enviar se liberan de details. No cierra jobs.
+func SubmitBatches(ctx context.Context, recordApi *sdk.RecordingApi, requests <-chan sdk.Batchdownloadrequest, skip func(sdk.Batchdownloadrequest) bool, j *journal.Journal, details *Details, jobs chan<- SubmittedJob) error {
+	var (
+		chunk                 []sdk.Batchdownloadrequest
+		sent, failed, skipped int
+	)
+
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		batch := chunk
+		chunk = nil
+		resp, err := submitBatch(recordApi, batch, j)
+		if err != nil && isCanceled(ctx, err) {
+			return err
+		}
+		if err != nil {
+			// Quedan con metadata en el journal: resume las reenvía
+			logger.Log.Error("❌ Error sending partial batch request", zap.Int("Count", len(batch)), zap.Error(err))
+			failed += len(batch)
+			details.DoneRequests(batch)
+			return nil
+		}
+		sent += len(batch)
+		select {
+		case jobs <- SubmittedJob{ID: *resp.Id, Requests: batch}:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	for {
+		select {
+		case request, ok := <-requests:
+			if !ok {
+				if err := flush(); err != nil {
+					return err
+				}
+				logger.Log.Info("Batch submission completed",
+					zap.Int("Sent", sent),
+					zap.Int("Failed", failed),
+					zap.Int("SkippedArchived", skipped))
+				if sent == 0 && failed > 0 {
+					return fmt.Errorf("no batch requests were successfully sent")
+				}
+				return nil
+			}
+			if skip != nil && skip(request) {
+				skipped++
+				continue
+			}
+			chunk = append(chunk, request)
+			if len(chunk) == maxBatchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// submitBatch envía un lote y registra sus grabaciones en el journal.
+func submitBatch(recordApi *sdk.RecordingApi, chunk []sdk.Batchdownloadrequest, j *journal.Journal) (*sdk.Batchdownloadjobsubmissionresult, error) {
+	batchRequest := sdk.Batchdownloadjobsubmission{
+		BatchDownloadRequestList: &chunk,
+	}
+
+	resp, _, err := recordApi.PostRecordingBatchrequests(batchRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Log.Info("✅ Partial batch sent",
+		zap.String("BatchID", *resp.Id),
+		zap.Int("Count", len(chunk)))
+
+	for _, request := range chunk {
+		recordJournal(j, journal.Entry{
+			Stage:          journal.StageSubmitted,
+			ConversationID: getString(request.ConversationId),
+			RecordingID:    getString(request.RecordingId),
+			JobID:          *resp.Id,
+		})
+	}
+	return resp, nil
+}
+
+// PollBatchJobUntilReady consulta hasta que el batch esté listo o se cancele ctx.
+func PollBatchJobUntilReady(ctx context.Context, recordApi *sdk.RecordingApi, jobID string, maxRetries int, delay time.Duration, j *journal.Journal) (*sdk.Batchdownloadjobstatusresult, error) {
 	var (
 		result       *sdk.Batchdownloadjobstatusresult
 		err          error
@@ -154,6 +290,9 @@ func PollBatchJobUntilReady(recordApi *sdk.RecordingApi, jobID string, maxRetrie
 
 	for i := 0; i < maxRetries; i++ {
 		result, _, err = recordApi.GetRecordingBatchrequest(jobID)
+		if err != nil && isCanceled(ctx, err) {
+			return nil, err
+		}
 		if err != nil {
 			logger.Log.Error("Error polling batch request status", zap.String("BatchID", jobID), zap.Error(err))
 			return nil, err
@@ -168,9 +307,24 @@ func PollBatchJobUntilReady(recordApi *sdk.RecordingApi, jobID string, maxRetrie
 			if result.Results != nil {
 				for _, item := range *result.Results {
 					if item.ResultUrl != nil {
-						fmt.Println("🔗 Download URL:", *item.ResultUrl)
+						logger.Log.Debug("🔗 Download URL", zap.String("RecordingId", getString(item.RecordingId)), zap.String("URL", *item.ResultUrl))
+						recordJournal(j, journal.Entry{
+							Stage:          journal.StageURL,
+							ConversationID: getString(item.ConversationId),
+							RecordingID:    getString(item.RecordingId),
+							JobID:          jobID,
+							URL:            *item.ResultUrl,
+							ContentType:    getString(item.ContentType),
+						})
 					} else if item.ErrorMsg != nil && *item.ErrorMsg != "" {
 						logger.Log.Warn("⚠️ Grabación fallida", zap.String("RecordingId", getString(item.RecordingId)), zap.String("Error", *item.ErrorMsg))
+						recordJournal(j, journal.Entry{
+							Stage:          journal.StageFailed,
+							ConversationID: getString(item.ConversationId),
+							RecordingID:    getString(item.RecordingId),
+							JobID:          jobID,
+							Error:          *item.ErrorMsg,
+						})
 					}
 
 				}
@@ -192,13 +346,15 @@ func PollBatchJobUntilReady(recordApi *sdk.RecordingApi, jobID string, maxRetrie
 		}
 
 		logger.Log.Info("⏳ Waiting for batch job...", zap.String("BatchID", jobID), zap.Int("Progress", curr), zap.Int("Expected", expected))
-		time.Sleep(delay)
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
 	}
 
 	return nil, fmt.Errorf("batch job %s did not complete in time", jobID)
 }
 
-func PollAllBatchesInParallel(recordApi *sdk.RecordingApi, results []*sdk.Batchdownloadjobsubmissionresult) {
+func PollAllBatchesInParallel(ctx context.Context, recordApi *sdk.RecordingApi, results []*sdk.Batchdownloadjobsubmissionresult) {
 	var wg sync.WaitGroup
 	for _, res := range results {
 		if res.Id == nil {
@@ -207,7 +363,7 @@ func PollAllBatchesInParallel(recordApi *sdk.RecordingApi, results []*sdk.Batchd
 		wg.Add(1)
 		go func(jobID string) {
 			defer wg.Done()
-			_, err := PollBatchJobUntilReady(recordApi, jobID, 40, 15*time.Second)
+			_, err := PollBatchJobUntilReady(ctx, recordApi, jobID, 40, 15*time.Second, nil)
 			if err != nil {
 				logger.Log.Error("Error polling batch job", zap.String("BatchID", jobID), zap.Error(err))
 			}
@@ -216,6 +372,32 @@ func PollAllBatchesInParallel(recordApi *sdk.RecordingApi, results []*sdk.Batchd
 	wg.Wait()
 }
 
+// recordJournal registra una entrada y sólo loguea si falla la escritura:
+// perder una línea del journal no debe detener las descargas.
+func recordJournal(j *journal.Journal, entry journal.Entry) {
+	if err := j.Record(entry); err != nil {
+		logger.Log.Warn("Failed to write journal entry", zap.String("Stage", string(entry.Stage)), zap.Error(err))
+	}
+}
+
+// isCanceled indica si err se debe a que se canceló ctx (el governor no
+// envía requests con el contexto cancelado).
+func isCanceled(ctx context.Context, err error) bool {
+	return ctx.Err() != nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded))
+}
+
+// sleep espera d o hasta que se cancele ctx.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func getString(ptr *string) string {
 	if ptr != nil {
 		return *ptr
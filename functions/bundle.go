@@ -0,0 +1,350 @@
+package functions
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/goDownloadRecording/archive"
+	"github.com/goDownloadRecording/bundle"
+	"github.com/goDownloadRecording/crypt"
+	"github.com/goDownloadRecording/layout"
+	"github.com/goDownloadRecording/logger"
+	"go.uber.org/zap"
+)
+
+// Criterios para agrupar las grabaciones en paquetes.
+const (
+	GroupByDay   = "day"   // fecha de la conversación
+	GroupByQueue = "queue" // primera cola de la conversación
+	GroupByRun   = "run"   // todo lo pendiente de empaquetar, en un solo grupo
+)
+
+// PackageOptions configura PackageDownloads.
+type PackageOptions struct {
+	// Root es la carpeta de descargas (storage local).
+	Root string
+	// OutDir es la carpeta donde se escriben los paquetes.
+	OutDir  string
+	Format  string // bundle.FormatTarGz o bundle.FormatZip
+	GroupBy string
+	// MaxSize limita el tamaño (sin comprimir) de cada paquete; un grupo más
+	// grande se parte en varios. 0 = sin límite.
+	MaxSize int64
+	// Remove borra los archivos sueltos de cada paquete verificado.
+	Remove bool
+	// Location es el huso horario de la fecha de GroupByDay (nil = local).
+	Location *time.Location
+}
+
+// PackageReport resume lo empaquetado.
+type PackageReport struct {
+	Packages   []string
+	Recordings int
+	Removed    int
+}
+
+// packagedRecording es una grabación del índice con los archivos que la
+// acompañan (hash, sidecar, transcripciones).
+type packagedRecording struct {
+	entry archive.Entry
+	files []bundle.File
+	size  int64
+	// conversationFiles son los sidecars de conversación de su carpeta
+	conversationFiles []bundle.File
+}
+
+// PackageDownloads agrupa las grabaciones indexadas que todavía no están en
+// un paquete (por día, cola o corrida), y escribe cada grupo como tar.gz o
+// zip con su manifest.json. Cada paquete se vuelve a leer y verificar contra
+// su manifiesto antes de registrarlo en el índice (Entry.Bundle) y, con
+// Remove, de borrar los archivos sueltos. Sólo aplica al storage local.
+func PackageDownloads(index *archive.Index, opts PackageOptions) (*PackageReport, error) {
+	ext, err := bundle.Extension(opts.Format)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Location == nil {
+		opts.Location = time.Local
+	}
+	if err := os.MkdirAll(opts.OutDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	outDir, err := filepath.Abs(opts.OutDir)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string][]packagedRecording)
+	runGroup := "run-" + time.Now().In(opts.Location).Format("20060102-150405")
+	for _, entry := range index.Entries() {
+		if entry.Bundle != "" || entry.Path == "" || entry.PurgedAt != nil {
+			continue
+		}
+		recording, ok := collectRecording(opts.Root, entry)
+		if !ok {
+			logger.Log.Warn("Indexed recording not found on disk, skipping", zap.String("Path", entry.Path))
+			continue
+		}
+		group := runGroup
+		switch opts.GroupBy {
+		case GroupByDay, GroupByQueue:
+			group = packageGroup(opts.Root, entry, opts.GroupBy, opts.Location)
+		case GroupByRun:
+		default:
+			return nil, fmt.Errorf("unknown package grouping %q (use day, queue or run)", opts.GroupBy)
+		}
+		groups[group] = append(groups[group], recording)
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	report := &PackageReport{}
+	for _, name := range names {
+		parts := splitPackage(groups[name], opts.MaxSize)
+		for i, recordings := range parts {
+			file := "recordings-" + name
+			if len(parts) > 1 {
+				file += fmt.Sprintf("-%03d", i+1)
+			}
+			target := bundle.UniquePath(filepath.Join(outDir, file+ext), ext)
+			if err := writePackage(index, target, name, i+1, len(parts), recordings, opts); err != nil {
+				return report, fmt.Errorf("packaging %s: %w", filepath.Base(target), err)
+			}
+			report.Packages = append(report.Packages, target)
+			report.Recordings += len(recordings)
+			if opts.Remove {
+				report.Removed += removeLoose(opts.Root, recordings)
+			}
+		}
+	}
+	return report, nil
+}
+
+// writePackage escribe, verifica y registra en el índice un paquete.
+func writePackage(index *archive.Index, target, group string, part, parts int, recordings []packagedRecording, opts PackageOptions) error {
+	manifest := &bundle.Manifest{
+		SchemaVersion: bundle.ManifestSchemaVersion,
+		Kind:          "package",
+		CreatedAt:     time.Now().UTC(),
+		Archive:       filepath.Base(target),
+		Group:         group,
+		Part:          part,
+		Parts:         parts,
+		Recordings:    len(recordings),
+	}
+	added := make(map[string]bool)
+	for _, recording := range recordings {
+		for _, file := range append(recording.files, recording.conversationFiles...) {
+			if !added[file.Path] {
+				added[file.Path] = true
+				manifest.Files = append(manifest.Files, file)
+			}
+		}
+	}
+
+	if err := bundle.Write(target, opts.Format, manifest); err != nil {
+		return err
+	}
+	if _, err := bundle.Verify(target, opts.Format); err != nil {
+		// Un paquete que no se puede verificar no se conserva
+		os.Remove(target)
+		return fmt.Errorf("verifying package: %w", err)
+	}
+	if err := bundle.WriteManifest(target, manifest); err != nil {
+		return err
+	}
+	sum, err := bundle.SHA256(target)
+	if err != nil {
+		return err
+	}
+	logger.Log.Info("Package written",
+		zap.String("Package", target),
+		zap.Int("Recordings", len(recordings)),
+		zap.Int("Files", len(manifest.Files)),
+		zap.String("SHA256", sum))
+
+	// La grabación queda registrada en su paquete: no se vuelve a empaquetar
+	// ni a descargar
+	for _, recording := range recordings {
+		entry := recording.entry
+		entry.Bundle = target
+		if err := index.Add(entry); err != nil {
+			return fmt.Errorf("updating archive index: %w", err)
+		}
+	}
+	return nil
+}
+
+// collectRecording arma la lista de archivos de una grabación indexada. ok
+// es false si el archivo de la grabación no está en disco (la lista tiene
+// igual los demás archivos que encontró).
+func collectRecording(root string, entry archive.Entry) (packagedRecording, bool) {
+	recording := packagedRecording{entry: entry}
+	found := true
+	base := recordingBase(entry.Path)
+	enc := ""
+	if strings.HasSuffix(entry.Path, crypt.Extension) {
+		enc = crypt.Extension
+	}
+	keys := []string{
+		entry.Path,
+		entry.Path + ".sha256",
+		sidecarKey(entry.Path),
+		base + ".txt" + enc,
+		base + ".html" + enc,
+		base + speechExtension + ".json" + enc,
+		base + speechExtension + ".txt" + enc,
+	}
+	if enc != "" {
+		keys = append(keys, sidecarKey(entry.Path)+enc)
+	}
+	for i, key := range keys {
+		info, err := os.Stat(filepath.Join(root, filepath.FromSlash(key)))
+		if err != nil || info.IsDir() {
+			if i == 0 {
+				found = false
+			}
+			continue
+		}
+		recording.files = append(recording.files, bundle.NewFile(filepath.Join(root, filepath.FromSlash(key)), key, entry.RecordingID, entry.ConversationID))
+		recording.size += info.Size()
+	}
+
+	folder := path.Dir(entry.Path)
+	for _, name := range []string{ConversationSidecarName, layout.Sanitize(entry.ConversationID) + "." + ConversationSidecarName} {
+		key := path.Join(folder, name+enc)
+		if info, err := os.Stat(filepath.Join(root, filepath.FromSlash(key))); err == nil && !info.IsDir() {
+			recording.conversationFiles = append(recording.conversationFiles, bundle.NewFile(filepath.Join(root, filepath.FromSlash(key)), key, "", entry.ConversationID))
+			recording.size += info.Size()
+		}
+	}
+	return recording, found
+}
+
+// packageGroup devuelve el grupo de una grabación según su sidecar: la
+// fecha de la conversación o su primera cola.
+func packageGroup(root string, entry archive.Entry, groupBy string, location *time.Location) string {
+	sidecar, err := readSidecar(root, entry.Path)
+	if err != nil {
+		logger.Log.Debug("Recording sidecar not available for grouping", zap.String("Path", entry.Path), zap.Error(err))
+	}
+
+	if groupBy == GroupByQueue {
+		if sidecar.Summary != nil && len(sidecar.Summary.QueueIDs) > 0 {
+			return layout.Sanitize(sidecar.Summary.QueueIDs[0])
+		}
+		return "no-queue"
+	}
+	start := entry.DownloadedAt
+	if sidecar.Summary != nil && sidecar.Summary.Start != nil {
+		start = *sidecar.Summary.Start
+	}
+	return start.In(location).Format("2006-01-02")
+}
+
+// readSidecar lee el sidecar suelto de la grabación key.
+func readSidecar(root, key string) (RecordingSidecar, error) {
+	var sidecar RecordingSidecar
+	data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(sidecarKey(key))))
+	if err == nil {
+		err = json.Unmarshal(data, &sidecar)
+	}
+	return sidecar, err
+}
+
+// splitPackage reparte las grabaciones de un grupo en partes de hasta
+// maxSize bytes. Una grabación nunca se parte: si sola supera el límite, va
+// sola en su parte.
+func splitPackage(recordings []packagedRecording, maxSize int64) [][]packagedRecording {
+	sort.Slice(recordings, func(a, b int) bool { return recordings[a].entry.Path < recordings[b].entry.Path })
+	var (
+		parts   [][]packagedRecording
+		current []packagedRecording
+		size    int64
+	)
+	for _, recording := range recordings {
+		if maxSize > 0 && len(current) > 0 && size+recording.size > maxSize {
+			parts = append(parts, current)
+			current, size = nil, 0
+		}
+		if maxSize > 0 && recording.size > maxSize {
+			logger.Log.Warn("Recording is larger than the package size limit", zap.String("Path", recording.entry.Path), zap.Int64("Size", recording.size))
+		}
+		current = append(current, recording)
+		size += recording.size
+	}
+	if len(current) > 0 {
+		parts = append(parts, current)
+	}
+	return parts
+}
+
+// removeLoose borra los archivos sueltos de grabaciones ya empaquetadas y
+// limpia sus carpetas (ver cleanupFolders). Devuelve cuántos archivos se
+// borraron.
+func removeLoose(root string, recordings []packagedRecording) int {
+	removed := 0
+	folders := make(map[string]bool)
+	for _, recording := range recordings {
+		removed += len(removeFiles(root, recording.files))
+		folders[path.Dir(recording.entry.Path)] = true
+	}
+	return removed + cleanupFolders(root, folders)
+}
+
+// removeFiles borra files y devuelve las rutas de los que se borraron.
+func removeFiles(root string, files []bundle.File) []string {
+	var removed []string
+	for _, file := range files {
+		if err := os.Remove(filepath.Join(root, filepath.FromSlash(file.Path))); err != nil {
+			logger.Log.Warn("Failed to remove file", zap.String("Path", file.Path), zap.Error(err))
+			continue
+		}
+		removed = append(removed, file.Path)
+	}
+	return removed
+}
+
+// cleanupFolders borra los sidecars de conversación de las carpetas en las
+// que no queda ninguna grabación, y las carpetas que quedaron vacías.
+// Devuelve cuántos archivos se borraron.
+func cleanupFolders(root string, folders map[string]bool) int {
+	removed := 0
+	for folder := range folders {
+		dir := filepath.Join(root, filepath.FromSlash(folder))
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		remaining := false
+		for _, file := range files {
+			if file.IsDir() || isRecordingFile(file.Name()) {
+				remaining = true
+				break
+			}
+		}
+		if remaining {
+			continue
+		}
+		for _, file := range files {
+			if isConversationSidecar(file.Name()) && os.Remove(filepath.Join(dir, file.Name())) == nil {
+				removed++
+			}
+		}
+		// Quita las carpetas que quedaron vacías, sin salir de root
+		for dir != filepath.Clean(root) && os.Remove(dir) == nil {
+			dir = filepath.Dir(dir)
+		}
+	}
+	return removed
+}
@@ -0,0 +1,167 @@
+package functions
+
+import (
+	"sync"
+
+	"github.com/goDownloadRecording/logger"
+	sdk "github.com/mypurecloud/platform-client-sdk-go/v157/platformclientv2"
+	"go.uber.org/zap"
+)
+
+// Details guarda lo que ya se obtuvo de cada conversación (el detalle de la
+// query y la metadata de sus grabaciones) para escribir los sidecars al
+// descargar sin volver a pedírselo a Genesys. Lo que falta, por ejemplo en
+// resume o retry, se pide a la API. Cada conversación se olvida cuando se
+// procesaron todas sus grabaciones, de modo que la memoria queda acotada a
+// lo que está en curso. Todos los métodos aceptan un *Details nil.
+type Details struct {
+	analyticsApi *sdk.AnalyticsApi
+	recordingApi *sdk.RecordingApi
+
+	mu            sync.Mutex
+	conversations map[string]*conversationDetails
+}
+
+type conversationDetails struct {
+	conversation *sdk.Analyticsconversationwithoutattributes
+	recordings   []sdk.Recordingmetadata // nil si todavía no se pidió la metadata
+	pending      int                     // grabaciones sin procesar
+}
+
+// NewDetails crea un Details que completa lo que falte con analyticsApi y
+// recordingApi (cualquiera puede ser nil para no consultarla).
+func NewDetails(analyticsApi *sdk.AnalyticsApi, recordingApi *sdk.RecordingApi) *Details {
+	return &Details{
+		analyticsApi:  analyticsApi,
+		recordingApi:  recordingApi,
+		conversations: make(map[string]*conversationDetails),
+	}
+}
+
+// AddConversation guarda el detalle de una conversación devuelta por la query.
+func (d *Details) AddConversation(conversation sdk.Analyticsconversationwithoutattributes) {
+	if d == nil || conversation.ConversationId == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.entry(*conversation.ConversationId).conversation = &conversation
+}
+
+// AddRecordings guarda la metadata de las grabaciones de una conversación.
+// Una conversación sin grabaciones se olvida enseguida.
+func (d *Details) AddRecordings(conversationID string, recordings []sdk.Recordingmetadata) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(recordings) == 0 {
+		delete(d.conversations, conversationID)
+		return
+	}
+	entry := d.entry(conversationID)
+	entry.recordings = recordings
+	entry.pending = len(recordings)
+}
+
+// Forget descarta lo guardado de una conversación (por ejemplo, si falló su metadata).
+func (d *Details) Forget(conversationID string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.conversations, conversationID)
+}
+
+// Done indica que se terminó de procesar una grabación de la conversación,
+// con o sin éxito. Con la última se olvida la conversación.
+func (d *Details) Done(conversationID string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if entry, ok := d.conversations[conversationID]; ok {
+		entry.pending--
+		if entry.pending <= 0 {
+			delete(d.conversations, conversationID)
+		}
+	}
+}
+
+// DoneRequests llama a Done por cada solicitud, p.ej. las de un batch job
+// que no se pudo enviar o consultar.
+func (d *Details) DoneRequests(requests []sdk.Batchdownloadrequest) {
+	for _, request := range requests {
+		if request.ConversationId != nil {
+			d.Done(*request.ConversationId)
+		}
+	}
+}
+
+// Get devuelve el detalle de la conversación y la metadata de sus
+// grabaciones, pidiendo a la API lo que no se tenga. Si un pedido falla se
+// loguea y se devuelve lo que haya: los sidecars se escriben igual.
+func (d *Details) Get(conversationID string) (*sdk.Analyticsconversationwithoutattributes, []sdk.Recordingmetadata) {
+	if d == nil {
+		return nil, nil
+	}
+	d.mu.Lock()
+	var (
+		conversation *sdk.Analyticsconversationwithoutattributes
+		recordings   []sdk.Recordingmetadata
+	)
+	if entry, ok := d.conversations[conversationID]; ok {
+		conversation, recordings = entry.conversation, entry.recordings
+	}
+	d.mu.Unlock()
+
+	if conversation == nil && d.analyticsApi != nil {
+		fetched, _, err := d.analyticsApi.GetAnalyticsConversationDetails(conversationID)
+		if err != nil {
+			logger.Log.Warn("Failed to fetch conversation details", zap.String("ConversationID", conversationID), zap.Error(err))
+		} else {
+			conversation = fetched
+			d.AddConversation(*fetched)
+		}
+	}
+	if recordings == nil && d.recordingApi != nil {
+		fetched, _, err := d.recordingApi.GetConversationRecordingmetadata(conversationID)
+		if err != nil {
+			logger.Log.Warn("Failed to fetch recording metadata", zap.String("ConversationID", conversationID), zap.Error(err))
+		} else {
+			recordings = fetched
+			d.mu.Lock()
+			entry := d.entry(conversationID)
+			entry.recordings = fetched
+			entry.pending = len(fetched)
+			d.mu.Unlock()
+		}
+	}
+	return conversation, recordings
+}
+
+// Media devuelve el tipo de media (audio, chat, email, message, screen) de
+// una grabación, o "" si no se conoce.
+func (d *Details) Media(conversationID, recordingID string) string {
+	_, recordings := d.Get(conversationID)
+	for _, recording := range recordings {
+		if getString(recording.Id) == recordingID {
+			return getString(recording.Media)
+		}
+	}
+	return ""
+}
+
+// entry devuelve la entrada de la conversación, creándola si no existe. Se
+// llama con d.mu tomado.
+func (d *Details) entry(conversationID string) *conversationDetails {
+	entry, ok := d.conversations[conversationID]
+	if !ok {
+		entry = &conversationDetails{}
+		d.conversations[conversationID] = entry
+	}
+	return entry
+}
@@ -0,0 +1,77 @@
+package functions
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	sdk "github.com/mypurecloud/platform-client-sdk-go/v157/platformclientv2"
+)
+
+// detailsWithRecordings arma un Details con una conversación y sus
+// grabaciones pendientes.
+func detailsWithRecordings(conversationID string, recordingIDs ...string) *Details {
+	details := NewDetails(nil, nil)
+	var recordings []sdk.Recordingmetadata
+	for _, id := range recordingIDs {
+		recordings = append(recordings, sdk.Recordingmetadata{Id: &id, ConversationId: &conversationID})
+	}
+	details.AddRecordings(conversationID, recordings)
+	return details
+}
+
+func TestDetailsDoneRequests(t *testing.T) {
+	details := detailsWithRecordings("c1", "r1", "r2")
+	conversationID, recordingID := "c1", "r1"
+	details.DoneRequests([]sdk.Batchdownloadrequest{{ConversationId: &conversationID, RecordingId: &recordingID}})
+	if _, ok := details.conversations["c1"]; !ok {
+		t.Fatal("conversation released with a recording still pending")
+	}
+	details.DoneRequests([]sdk.Batchdownloadrequest{{ConversationId: &conversationID}, {RecordingId: &recordingID}})
+	if len(details.conversations) != 0 {
+		t.Errorf("got %d conversations after every request was done", len(details.conversations))
+	}
+}
+
+func TestDownloadReleasesFailedRecordings(t *testing.T) {
+	// Las grabaciones que el batch no pudo preparar no se descargan, pero
+	// liberan el detalle de su conversación
+	details := detailsWithRecordings("c1", "r1", "r2")
+	conversationID, failed, message := "c1", "r1", "recording not found"
+	result := &sdk.Batchdownloadjobstatusresult{Results: &[]sdk.Batchdownloadjobresult{
+		{ConversationId: &conversationID, RecordingId: &failed, ErrorMsg: &message},
+		{ConversationId: &conversationID},
+	}}
+	if err := DownloadAllReadyRecordings(context.Background(), result, DownloadOptions{Details: details, MaxWorkers: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if len(details.conversations) != 0 {
+		t.Errorf("got %d conversations after the job finished", len(details.conversations))
+	}
+}
+
+func TestSplitTextRecordings(t *testing.T) {
+	// En resume la metadata no está en memoria: manda el media del journal,
+	// y sin él se busca en details
+	details := NewDetails(nil, nil)
+	chat, audio := "chat", "audio"
+	conversationID := "c3"
+	details.AddRecordings(conversationID, []sdk.Recordingmetadata{{Id: sdk.String("r3"), Media: &chat}, {Id: sdk.String("r4"), Media: &audio}})
+	request := func(conversationID, recordingID string) sdk.Batchdownloadrequest {
+		return sdk.Batchdownloadrequest{ConversationId: &conversationID, RecordingId: &recordingID}
+	}
+	requests := []sdk.Batchdownloadrequest{request("c1", "r1"), request("c2", "r2"), request("c3", "r3"), request("c3", "r4"), request("c5", "r5")}
+	media := map[string]string{"r1": "email", "r2": "audio"}
+
+	batch, text := SplitTextRecordings(requests, media, details)
+	var batchIDs, textIDs []string
+	for _, request := range batch {
+		batchIDs = append(batchIDs, *request.RecordingId)
+	}
+	for _, request := range text {
+		textIDs = append(textIDs, *request.RecordingId)
+	}
+	if strings.Join(textIDs, ",") != "r1,r3" || strings.Join(batchIDs, ",") != "r2,r4,r5" {
+		t.Errorf("got text %v and batch %v", textIDs, batchIDs)
+	}
+}
@@ -1,70 +1,242 @@
 package functions
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
-	"path/filepath"
+	"path"
 	"sync"
 	"time"
 
+	"github.com/goDownloadRecording/archive"
+	"github.com/goDownloadRecording/crypt"
+	"github.com/goDownloadRecording/journal"
 	"github.com/goDownloadRecording/logger"
+	"github.com/goDownloadRecording/storage"
 	sdk "github.com/mypurecloud/platform-client-sdk-go/v157/platformclientv2"
 	"go.uber.org/zap"
 )
 
-// safeString evita valores nulos
-func safeString(field *string) string {
-	if field != nil {
-		return *field
-	}
-	return "N/A"
+// writeChecksumFile guarda el hash junto a la grabación en formato sha256sum,
+// de modo que pueda auditarse con "sha256sum -c".
+func writeChecksumFile(backend storage.Backend, fileKey string, download *downloadResult) error {
+	line := fmt.Sprintf("%s  %s\n", download.SHA256, path.Base(fileKey))
+	return backend.WriteFile(fileKey+".sha256", []byte(line))
+}
+
+// downloadResult describe un archivo descargado y verificado.
+type downloadResult struct {
+	Size   int64
+	SHA256 string
+	// ContentType y Format describen el contenido real del archivo.
+	ContentType string
+	Format      string
+	// Encryption, si no es nil, indica que el archivo se guardó cifrado
+	// (Size y SHA256 son los del archivo cifrado); head son sus primeros
+	// bytes en claro.
+	Encryption *crypt.Info
+	head       []byte
 }
 
-// writeMetadataFile guarda los metadatos en metadata.txt
-func writeMetadataFile(folderPath string, item sdk.Batchdownloadjobresult) error {
-	metadataPath := filepath.Join(folderPath, "metadata.txt")
-	file, err := os.Create(metadataPath)
+// errNotRetryable marca errores de descarga que no tiene sentido reintentar
+// (por ejemplo, una URL prefirmada vencida).
+type errNotRetryable struct{ err error }
+
+func (e errNotRetryable) Error() string { return e.err.Error() }
+func (e errNotRetryable) Unwrap() error { return e.err }
+
+// downloadFile descarga un archivo desde una URL al objeto key del backend.
+// El objeto sólo se publica (Commit) cuando la descarga está completa y
+// coincide con el tamaño anunciado, de modo que nunca queda un archivo
+// truncado con el nombre definitivo. Si la transferencia se corta, se
+// reintenta hasta retries veces con backoff exponencial; si el backend
+// conserva lo ya escrito (local), se continúa desde el último byte con un
+// Range request, y si el servidor no respeta el rango se descarga de nuevo
+// completo. Con enc el contenido se guarda cifrado y no se continúa desde
+// la mitad (ver encryptedObject). Si ctx se cancela la transferencia se corta y el objeto se cierra
+// sin publicar, igual que cuando se agotan los reintentos.
+func downloadFile(ctx context.Context, url string, backend storage.Backend, key string, enc *crypt.Encrypter, retries int, backoff time.Duration) (*downloadResult, error) {
+	obj, err := backend.Create(key)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	var encrypted *encryptedObject
+	if enc != nil {
+		if encrypted, err = encryptObject(obj, enc); err != nil {
+			obj.Abort()
+			return nil, err
+		}
+		obj = encrypted
 	}
-	defer file.Close()
+	delay := backoff
 
-	_, err = fmt.Fprintf(file, "Recording ID: %s\nConversation ID: %s\nContent Type: %s\nResult URL: %s\nFecha de descarga: %s\n",
-		safeString(item.RecordingId),
-		safeString(item.ConversationId),
-		safeString(item.ContentType),
-		safeString(item.ResultUrl),
-		time.Now().Format("2006-01-02 15:04:05"),
-	)
-	return err
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			logger.Log.Warn("Retrying download",
+				zap.String("Key", key),
+				zap.Int("Attempt", attempt),
+				zap.Duration("Backoff", delay),
+				zap.Error(err))
+			if err := sleep(ctx, delay); err != nil {
+				obj.Close()
+				return nil, err
+			}
+			delay = min(delay*2, maxDownloadBackoff)
+		}
+
+		err = downloadAttempt(ctx, url, obj)
+		if err == nil {
+			if err = obj.Commit(); err != nil {
+				obj.Abort()
+				return nil, err
+			}
+			result := &downloadResult{Size: obj.Size(), SHA256: obj.SHA256()}
+			if encrypted != nil {
+				result.Encryption, result.head = enc.Info(), encrypted.head
+			}
+			return result, nil
+		}
+		var fatal errNotRetryable
+		if errors.As(err, &fatal) {
+			obj.Abort()
+			return nil, err
+		}
+		if ctx.Err() != nil {
+			obj.Close()
+			return nil, ctx.Err()
+		}
+	}
+	// Lo ya descargado se conserva para continuar en otra corrida
+	obj.Close()
+	return nil, fmt.Errorf("download failed after %d retries: %w", retries, err)
 }
 
-// downloadFile descarga un archivo desde una URL al path local
-func downloadFile(url, filePath string) error {
-	resp, err := http.Get(url)
+// maxDownloadBackoff limita la espera entre reintentos de una descarga.
+const maxDownloadBackoff = time.Minute
+
+// downloadAttempt hace un intento de descarga sobre obj, continuando desde
+// lo que ya tenga escrito si el backend lo permite.
+func downloadAttempt(ctx context.Context, url string, obj storage.Object) error {
+	offset := obj.Offset()
+	if offset == 0 {
+		// Descarta lo que haya quedado de un intento que no se puede continuar
+		if err := obj.Reset(); err != nil {
+			return errNotRetryable{err}
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return errNotRetryable{err}
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	expected := int64(-1)
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		start, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != offset {
+			return restartObject(obj, fmt.Errorf("unexpected Content-Range %q for offset %d", resp.Header.Get("Content-Range"), offset))
+		}
+		expected = total
+		logger.Log.Info("Resuming download", zap.Int64("Offset", offset))
+	case resp.StatusCode == http.StatusOK:
+		// Sin rango (o el servidor lo ignoró): se empieza de cero
+		if offset > 0 {
+			logger.Log.Info("Server ignored Range request, downloading from start")
+			if err := obj.Reset(); err != nil {
+				return errNotRetryable{err}
+			}
+			offset = 0
+		}
+		if resp.ContentLength >= 0 {
+			expected = resp.ContentLength
+		}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		return restartObject(obj, fmt.Errorf("range %d- not satisfiable", offset))
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
 		return fmt.Errorf("failed to download: status code %d", resp.StatusCode)
+	default:
+		return errNotRetryable{fmt.Errorf("failed to download: status code %d", resp.StatusCode)}
 	}
 
-	out, err := os.Create(filePath)
+	// Se cuenta lo recibido y no obj.Size(): si el objeto cifra, lo que
+	// guarda no mide lo mismo que lo descargado
+	copied, err := io.Copy(obj, resp.Body)
 	if err != nil {
 		return err
 	}
-	defer out.Close()
+	if expected >= 0 && offset+copied != expected {
+		return fmt.Errorf("truncated download: got %d of %d bytes", offset+copied, expected)
+	}
+	return nil
+}
+
+// restartObject descarta lo escrito para que el próximo intento empiece de cero.
+func restartObject(obj storage.Object, cause error) error {
+	if err := obj.Reset(); err != nil {
+		return errNotRetryable{err}
+	}
+	return cause
+}
+
+// parseContentRange interpreta "bytes start-end/total".
+func parseContentRange(value string) (start, total int64, ok bool) {
+	var end int64
+	if _, err := fmt.Sscanf(value, "bytes %d-%d/%d", &start, &end, &total); err != nil {
+		return 0, 0, false
+	}
+	return start, total, true
+}
 
-	_, err = io.Copy(out, resp.Body)
-	return err
+// DownloadOptions agrupa la configuración de DownloadAllReadyRecordings.
+type DownloadOptions struct {
+	// Storage es el destino de las grabaciones y su metadata.
+	Storage    storage.Backend
+	MaxWorkers int
+	// Retries y RetryBackoff acotan los reintentos de cada archivo.
+	Retries      int
+	RetryBackoff time.Duration
+	Journal      *journal.Journal
+	// Index, si no es nil, recibe cada grabación descargada y verificada.
+	Index *archive.Index
+	// Details provee el detalle de la conversación y la metadata de las
+	// grabaciones para los sidecars JSON.
+	Details *Details
+	// Paths arma la carpeta y el nombre de cada grabación (nil = estructura
+	// por defecto).
+	Paths *Paths
+	// Format, si no es vacío, es el formato en que se piden las grabaciones
+	// a RecordingApi en lugar del que devuelve el batch (ver ValidateFormat).
+	// FormatRetries acota la espera mientras Genesys las convierte.
+	Format        string
+	FormatRetries int
+	RecordingApi  *sdk.RecordingApi
+	// SpeechApi, si no es nil, se usa para guardar junto a cada grabación de
+	// audio su transcripción de Speech & Text Analytics.
+	SpeechApi *sdk.SpeechTextAnalyticsApi
+	// Encryption, si no es nil, cifra las grabaciones y transcripciones
+	// guardadas (ver crypt).
+	Encryption *crypt.Encrypter
+	// ExcludeMedia son los tipos de grabación (Recordingmetadata.Media, p.ej.
+	// "screen") que no se descargan.
+	ExcludeMedia map[string]bool
 }
 
-// DownloadAllReadyRecordings descarga las grabaciones en paralelo, cada una en su carpeta
-func DownloadAllReadyRecordings(result *sdk.Batchdownloadjobstatusresult, outputDir string, maxWorkers int) error {
+// DownloadAllReadyRecordings descarga las grabaciones en paralelo, cada una en su carpeta.
+// Si ctx se cancela no se empiezan descargas nuevas, las que están en curso
+// se cortan sin publicar el archivo y se devuelve el error del contexto.
+func DownloadAllReadyRecordings(ctx context.Context, result *sdk.Batchdownloadjobstatusresult, opts DownloadOptions) error {
 	if result == nil || result.Results == nil {
 		logger.Log.Warn("No results to download")
 		return nil
@@ -74,40 +246,22 @@ func DownloadAllReadyRecordings(result *sdk.Batchdownloadjobstatusresult, output
 	tasks := make(chan sdk.Batchdownloadjobresult, len(*result.Results))
 	var wg sync.WaitGroup
 
-	for i := 0; i < maxWorkers; i++ {
+	for i := 0; i < opts.MaxWorkers; i++ {
 		wg.Add(1)
 		go func(workerID int) {
 			defer wg.Done()
 			for item := range tasks {
-				if item.ResultUrl == nil || item.RecordingId == nil || item.ConversationId == nil {
-					logger.Log.Warn("Missing ResultUrl, RecordingId or ConversationId, skipping item")
+				if ctx.Err() != nil {
 					continue
 				}
-
-				// Crear carpeta con formato YYMMDD-ConversationId
-				folderName := time.Now().Format("060102") + "-" + safeString(item.ConversationId)
-				folderPath := filepath.Join(outputDir, folderName)
-				os.MkdirAll(folderPath, os.ModePerm)
-
-				// Descargar grabación
-				fileName := safeString(item.RecordingId) + ".mp3"
-				filePath := filepath.Join(folderPath, fileName)
-				err := downloadFile(*item.ResultUrl, filePath)
-				if err != nil {
-					logger.Log.Error("Failed to download recording", zap.String("RecordingID", *item.RecordingId), zap.Error(err))
+				if item.ResultUrl == nil || item.RecordingId == nil || item.ConversationId == nil {
+					logger.Log.Warn("Missing ResultUrl, RecordingId or ConversationId, skipping item")
+					if item.ConversationId != nil {
+						opts.Details.Done(*item.ConversationId)
+					}
 					continue
 				}
-
-				logger.Log.Info("Downloaded recording",
-					zap.String("File", filePath),
-					zap.Int("Worker", workerID),
-				)
-
-				// Guardar metadata.txt
-				err = writeMetadataFile(folderPath, item)
-				if err != nil {
-					logger.Log.Error("Failed to write metadata", zap.String("RecordingID", *item.RecordingId), zap.Error(err))
-				}
+				downloadRecording(ctx, item, opts, workerID)
 			}
 		}(i)
 	}
@@ -119,11 +273,127 @@ func DownloadAllReadyRecordings(result *sdk.Batchdownloadjobstatusresult, output
 	close(tasks)
 	wg.Wait()
 
+	if err := ctx.Err(); err != nil {
+		logger.Log.Warn("Downloads interrupted", zap.Int("TotalFiles", len(*result.Results)))
+		return err
+	}
 	elapsed := time.Since(start)
 	logger.Log.Info("All downloads completed",
 		zap.Int("TotalFiles", len(*result.Results)),
-		zap.Int("Workers", maxWorkers),
+		zap.Int("Workers", opts.MaxWorkers),
 		zap.Duration("Duration", elapsed),
 	)
 	return nil
 }
+
+// scopeStorage devuelve backend con las rutas de los objetos de la
+// conversación (ver storage.Scope). conversation puede ser nil.
+func scopeStorage(backend storage.Backend, conversation *sdk.Analyticsconversationwithoutattributes, conversationID string) storage.Backend {
+	rec := storage.Recording{ConversationID: conversationID}
+	if conversation != nil && conversation.ConversationStart != nil {
+		rec.Start = *conversation.ConversationStart
+	}
+	return storage.Scope(backend, rec)
+}
+
+// downloadRecording descarga, verifica e indexa una grabación del batch.
+func downloadRecording(ctx context.Context, item sdk.Batchdownloadjobresult, opts DownloadOptions, workerID int) {
+	j := opts.Journal
+	defer opts.Details.Done(*item.ConversationId)
+
+	// URL y extensión según el formato pedido o el que devolvió el batch
+	source, err := resolveMedia(ctx, item, opts)
+	if err != nil {
+		if !isCanceled(ctx, err) {
+			logger.Log.Error("Failed to resolve recording media", zap.String("RecordingID", *item.RecordingId), zap.Error(err))
+			recordJournal(j, journal.Entry{Stage: journal.StageFailed, ConversationID: *item.ConversationId, RecordingID: *item.RecordingId, Error: err.Error()})
+		}
+		return
+	}
+
+	// La carpeta y el nombre salen de las plantillas, con los datos de la conversación
+	conversation, recordings := opts.Details.Get(*item.ConversationId)
+	folderKey, fileKey := opts.Paths.Keys(conversation, *item.ConversationId, *item.RecordingId, source.Extension+encryptedExtension(opts))
+	opts.Storage = scopeStorage(opts.Storage, conversation, *item.ConversationId)
+
+	// Descargar grabación
+	download, err := downloadFile(ctx, source.URL, opts.Storage, fileKey, opts.Encryption, opts.Retries, opts.RetryBackoff)
+	if err != nil && isCanceled(ctx, err) {
+		// Queda pendiente en el journal para la próxima corrida
+		logger.Log.Info("Download interrupted", zap.String("RecordingID", *item.RecordingId))
+		return
+	}
+	if err != nil {
+		logger.Log.Error("Failed to download recording", zap.String("RecordingID", *item.RecordingId), zap.Error(err))
+		recordJournal(j, journal.Entry{Stage: journal.StageFailed, ConversationID: *item.ConversationId, RecordingID: *item.RecordingId, Error: err.Error()})
+		return
+	}
+	recordJournal(j, journal.Entry{Stage: journal.StageDownloaded, ConversationID: *item.ConversationId, RecordingID: *item.RecordingId, Path: fileKey, SHA256: download.SHA256})
+
+	download.ContentType, download.Format = source.ContentType, opts.Format
+	if format, ok := sniffDownload(opts.Storage, fileKey, download); ok {
+		if source.Screen {
+			format = screenFormat(format)
+		}
+		download.ContentType, download.Format = format.ContentType, format.Name
+		if format.Extension != source.Extension {
+			logger.Log.Warn("Recording content does not match its extension",
+				zap.String("File", fileKey),
+				zap.String("Format", format.Name))
+		}
+	}
+
+	logger.Log.Info("Downloaded recording",
+		zap.String("Storage", opts.Storage.Name()),
+		zap.String("File", fileKey),
+		zap.String("Format", download.Format),
+		zap.Int("Worker", workerID),
+	)
+	if storeRecording(opts, item, folderKey, fileKey, conversation, recordings, download) && !source.Screen {
+		exportSpeechTranscript(ctx, opts, *item.ConversationId, *item.RecordingId, fileKey, conversation, recordings)
+	}
+}
+
+// storeRecording completa una grabación ya publicada en fileKey: escribe
+// sus sidecars y su hash, la verifica y la agrega al índice. Devuelve false
+// si no se pudo verificar.
+func storeRecording(opts DownloadOptions, item sdk.Batchdownloadjobresult, folderKey, fileKey string, conversation *sdk.Analyticsconversationwithoutattributes, recordings []sdk.Recordingmetadata, download *downloadResult) bool {
+	j := opts.Journal
+
+	// Guardar los sidecars JSON y el hash junto a la grabación
+	conversationKey := opts.Paths.ConversationSidecar(folderKey, *item.ConversationId)
+	err := writeSidecars(opts, conversationKey, fileKey, item, conversation, recordings, download)
+	if err == nil {
+		err = writeChecksumFile(opts.Storage, fileKey, download)
+	}
+	if err != nil {
+		logger.Log.Error("Failed to write metadata", zap.String("RecordingID", *item.RecordingId), zap.Error(err))
+		return false
+	}
+
+	// Se comprueba lo que quedó publicado volviendo a leerlo del backend, de
+	// modo que "verified" significa lo mismo en disco local, S3 o SFTP: el
+	// objeto guardado tiene el hash de lo descargado.
+	if err := VerifyObject(opts.Storage, fileKey, download.Size, download.SHA256); err != nil {
+		logger.Log.Error("Downloaded recording failed verification", zap.String("File", fileKey), zap.Error(err))
+		recordJournal(j, journal.Entry{Stage: journal.StageFailed, ConversationID: *item.ConversationId, RecordingID: *item.RecordingId, Error: err.Error()})
+		return false
+	}
+	recordJournal(j, journal.Entry{Stage: journal.StageVerified, ConversationID: *item.ConversationId, RecordingID: *item.RecordingId, Path: fileKey, SHA256: download.SHA256})
+
+	entry := archive.Entry{
+		RecordingID:    *item.RecordingId,
+		ConversationID: *item.ConversationId,
+		Path:           fileKey,
+		Size:           download.Size,
+		SHA256:         download.SHA256,
+	}
+	if conversation != nil {
+		entry.ConversationStart = conversation.ConversationStart
+	}
+	err = opts.Index.Add(entry)
+	if err != nil {
+		logger.Log.Warn("Failed to add recording to archive index", zap.String("RecordingID", *item.RecordingId), zap.Error(err))
+	}
+	return true
+}
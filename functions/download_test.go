@@ -0,0 +1,109 @@
+package functions
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/goDownloadRecording/archive"
+	"github.com/goDownloadRecording/storage"
+	sdk "github.com/mypurecloud/platform-client-sdk-go/v157/platformclientv2"
+)
+
+// failingCommit es un backend cuyos objetos fallan al publicarse.
+type failingCommit struct {
+	storage.Backend
+	aborted bool
+}
+
+func (b *failingCommit) Create(key string) (storage.Object, error) {
+	obj, err := b.Backend.Create(key)
+	if err != nil {
+		return nil, err
+	}
+	return &failingObject{Object: obj, backend: b}, nil
+}
+
+type failingObject struct {
+	storage.Object
+	backend *failingCommit
+}
+
+func (o *failingObject) Commit() error { return errors.New("commit failed") }
+
+func (o *failingObject) Abort() error {
+	o.backend.aborted = true
+	return o.Object.Abort()
+}
+
+func TestDownloadFileAbortsFailedCommit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("audio"))
+	}))
+	defer server.Close()
+
+	root := t.TempDir()
+	backend := &failingCommit{Backend: storage.NewLocal(root)}
+	if _, err := downloadFile(context.Background(), server.URL, backend, "c1/r1.wav", nil, 0, 0); err == nil {
+		t.Fatal("expected the commit error")
+	}
+	if !backend.aborted {
+		t.Error("object was not aborted after the failed commit")
+	}
+	if _, err := os.Stat(filepath.Join(root, "c1", "r1.wav.part")); !os.IsNotExist(err) {
+		t.Errorf("partial file left behind: %v", err)
+	}
+}
+
+// remoteBackend es un backend que no es *storage.Local, como S3 o SFTP.
+type remoteBackend struct {
+	storage.Backend
+}
+
+func (remoteBackend) Name() string { return "remote" }
+
+func TestStoreRecordingVerifiesRemoteContent(t *testing.T) {
+	// Un objeto remoto del tamaño correcto pero con otro contenido no se da
+	// por verificado ni se indexa
+	root := t.TempDir()
+	index, err := archive.Open(filepath.Join(root, "index.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	backend := remoteBackend{storage.NewLocal(root)}
+	opts := DownloadOptions{Storage: backend, Index: index}
+
+	conversationID := "c1"
+	for _, test := range []struct {
+		recordingID, content string
+		want                 bool
+	}{
+		{"r1", "audio", true},
+		{"r2", "otro!", false},
+	} {
+		fileKey := "c1/" + test.recordingID + ".wav"
+		if err := backend.WriteFile(fileKey, []byte(test.content)); err != nil {
+			t.Fatal(err)
+		}
+		item := sdk.Batchdownloadjobresult{ConversationId: &conversationID, RecordingId: &test.recordingID}
+		download := &downloadResult{Size: 5, SHA256: sha256String("audio")}
+		if got := storeRecording(opts, item, "c1", fileKey, nil, nil, download); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.recordingID, got, test.want)
+		}
+		if _, indexed := index.Lookup(test.recordingID); indexed != test.want {
+			t.Errorf("%s: indexed %v, want %v", test.recordingID, indexed, test.want)
+		}
+	}
+}
+
+func sha256String(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,90 @@
+package functions
+
+import (
+	"io"
+	"strings"
+
+	"github.com/goDownloadRecording/crypt"
+	"github.com/goDownloadRecording/storage"
+)
+
+// encryptedObject cifra en streaming lo que se escribe en un objeto del
+// backend. Size y SHA256 son los del archivo cifrado, que es lo que queda
+// guardado y lo que se verifica. Una descarga cifrada no se puede continuar
+// desde la mitad: Offset siempre es 0 y cada intento empieza de cero con una
+// clave de datos nueva.
+type encryptedObject struct {
+	storage.Object
+	enc *crypt.Encrypter
+	w   io.WriteCloser
+	// head son los primeros bytes en claro, para reconocer el formato sin
+	// tener que descifrar el archivo
+	head []byte
+}
+
+// encryptObject envuelve obj para que su contenido se guarde cifrado con enc.
+func encryptObject(obj storage.Object, enc *crypt.Encrypter) (*encryptedObject, error) {
+	e := &encryptedObject{Object: obj, enc: enc}
+	if err := e.Reset(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *encryptedObject) Write(p []byte) (int, error) {
+	if missing := sniffLen - len(e.head); missing > 0 {
+		e.head = append(e.head, p[:min(missing, len(p))]...)
+	}
+	return e.w.Write(p)
+}
+
+func (e *encryptedObject) Offset() int64 { return 0 }
+
+func (e *encryptedObject) Reset() error {
+	if err := e.Object.Reset(); err != nil {
+		return err
+	}
+	w, err := e.enc.NewWriter(e.Object)
+	if err != nil {
+		return err
+	}
+	e.w, e.head = w, nil
+	return nil
+}
+
+// Commit escribe el último bloque y publica el objeto.
+func (e *encryptedObject) Commit() error {
+	if err := e.w.Close(); err != nil {
+		e.Object.Abort()
+		return err
+	}
+	return e.Object.Commit()
+}
+
+// writeContent guarda data en key, cifrado si opts tiene cifrado (y con
+// crypt.Extension agregada a key). Devuelve la clave y el contenido
+// guardados, para calcular su hash.
+func writeContent(opts DownloadOptions, key string, data []byte) (string, []byte, error) {
+	if opts.Encryption != nil {
+		sealed, err := opts.Encryption.Seal(data)
+		if err != nil {
+			return key, nil, err
+		}
+		key, data = key+crypt.Extension, sealed
+	}
+	return key, data, opts.Storage.WriteFile(key, data)
+}
+
+// encryptedExtension devuelve lo que se agrega al nombre de los archivos
+// guardados con opts: crypt.Extension si se cifran, "" si no.
+func encryptedExtension(opts DownloadOptions) string {
+	if opts.Encryption != nil {
+		return crypt.Extension
+	}
+	return ""
+}
+
+// plainName quita crypt.Extension del nombre de un archivo cifrado.
+func plainName(name string) string {
+	return strings.TrimSuffix(name, crypt.Extension)
+}
@@ -0,0 +1,320 @@
+package functions
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/goDownloadRecording/logger"
+	"github.com/goDownloadRecording/storage"
+	sdk "github.com/mypurecloud/platform-client-sdk-go/v157/platformclientv2"
+	"go.uber.org/zap"
+)
+
+// mediaFormat describe un formato de grabación de Genesys.
+type mediaFormat struct {
+	Name        string // formatId de Genesys
+	ContentType string
+	Extension   string
+}
+
+// mediaFormats son los formatos de audio que se pueden pedir con -format.
+// La disponibilidad de MP3 depende de la organización.
+var mediaFormats = []mediaFormat{
+	{Name: "WAV", ContentType: "audio/wav", Extension: ".wav"},
+	{Name: "WAV_ULAW", ContentType: "audio/wav", Extension: ".wav"},
+	{Name: "WEBM", ContentType: "audio/webm", Extension: ".webm"},
+	{Name: "OGG_OPUS", ContentType: "audio/ogg", Extension: ".ogg"},
+	{Name: "OGG_VORBIS", ContentType: "audio/ogg", Extension: ".ogg"},
+	{Name: "MP3", ContentType: "audio/mpeg", Extension: ".mp3"},
+}
+
+// unknownExtension se usa cuando no se pudo reconocer el formato.
+const unknownExtension = ".bin"
+
+// contentTypeExtensions asocia los Content-Type (sin parámetros) que puede
+// devolver Genesys con la extensión del contenedor.
+var contentTypeExtensions = map[string]string{
+	"audio/wav":    ".wav",
+	"audio/wave":   ".wav",
+	"audio/x-wav":  ".wav",
+	"audio/webm":   ".webm",
+	"video/webm":   ".webm",
+	"audio/ogg":    ".ogg",
+	"audio/opus":   ".ogg",
+	"audio/mpeg":   ".mp3",
+	"audio/mp3":    ".mp3",
+	"audio/mp4":    ".m4a",
+	"video/mp4":    ".mp4",
+	"audio/x-m4a":  ".m4a",
+	"audio/x-mpeg": ".mp3",
+}
+
+// ValidateFormat comprueba que format sea un formato que se puede pedir
+// ("" = el que devuelva el batch) y lo devuelve normalizado.
+func ValidateFormat(format string) (string, error) {
+	if format == "" {
+		return "", nil
+	}
+	format = strings.ToUpper(format)
+	if _, ok := lookupFormat(format); !ok {
+		names := make([]string, len(mediaFormats))
+		for i, f := range mediaFormats {
+			names[i] = f.Name
+		}
+		return "", fmt.Errorf("unsupported recording format %q (use one of %s)", format, strings.Join(names, ", "))
+	}
+	return format, nil
+}
+
+func lookupFormat(name string) (mediaFormat, bool) {
+	for _, f := range mediaFormats {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return mediaFormat{}, false
+}
+
+// extensionFor devuelve la extensión que corresponde a contentType, o "" si
+// no se reconoce.
+func extensionFor(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return contentTypeExtensions[mediaType]
+}
+
+// isRecordingFile indica si name es una grabación descargada (o la
+// transcripción de una interacción de texto).
+func isRecordingFile(name string) bool {
+	name = plainName(name)
+	ext := path.Ext(name)
+	if ext == unknownExtension || strings.HasSuffix(name, transcriptExtension) {
+		return true
+	}
+	for _, known := range contentTypeExtensions {
+		if known == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// sniffLen es la cantidad de bytes que alcanza para reconocer el formato.
+const sniffLen = 64
+
+// sniffFormat reconoce el formato a partir de los primeros bytes del
+// archivo; ok es false si no lo reconoce.
+func sniffFormat(head []byte) (format mediaFormat, ok bool) {
+	switch {
+	case len(head) >= 12 && bytes.Equal(head[:4], []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WAVE")):
+		name := "WAV"
+		// Código de formato del chunk fmt: 7 = µ-law
+		if len(head) >= 22 && bytes.Equal(head[12:16], []byte("fmt ")) && binary.LittleEndian.Uint16(head[20:22]) == 7 {
+			name = "WAV_ULAW"
+		}
+		format, _ = lookupFormat(name)
+		return format, true
+	case len(head) >= 4 && bytes.Equal(head[:4], []byte{0x1a, 0x45, 0xdf, 0xa3}):
+		format, _ = lookupFormat("WEBM")
+		return format, true
+	case len(head) >= 4 && bytes.Equal(head[:4], []byte("OggS")):
+		name := "OGG_VORBIS"
+		if bytes.Contains(head, []byte("OpusHead")) {
+			name = "OGG_OPUS"
+		}
+		format, _ = lookupFormat(name)
+		return format, true
+	case len(head) >= 3 && bytes.Equal(head[:3], []byte("ID3")),
+		len(head) >= 2 && head[0] == 0xff && head[1]&0xe0 == 0xe0:
+		format, _ = lookupFormat("MP3")
+		return format, true
+	case len(head) >= 8 && bytes.Equal(head[4:8], []byte("ftyp")):
+		return mediaFormat{Name: "MP4", ContentType: "video/mp4", Extension: ".mp4"}, true
+	}
+	return mediaFormat{}, false
+}
+
+// sniffURL descarga los primeros bytes de url (con un Range request) para
+// reconocer el formato cuando el Content-Type no alcanza.
+func sniffURL(ctx context.Context, url string) (mediaFormat, bool) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return mediaFormat{}, false
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", sniffLen-1))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return mediaFormat{}, false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return mediaFormat{}, false
+	}
+	head, _ := io.ReadAll(io.LimitReader(resp.Body, sniffLen))
+	if format, ok := sniffFormat(head); ok {
+		return format, true
+	}
+	if ext := extensionFor(resp.Header.Get("Content-Type")); ext != "" {
+		return mediaFormat{ContentType: resp.Header.Get("Content-Type"), Extension: ext}, true
+	}
+	return mediaFormat{}, false
+}
+
+// sniffDownload reconoce el formato real de una grabación ya guardada: por
+// los primeros bytes en claro que se vieron al cifrarla o, si no se cifró,
+// leyéndolos del backend.
+func sniffDownload(backend storage.Backend, key string, download *downloadResult) (mediaFormat, bool) {
+	if download.Encryption != nil {
+		return sniffFormat(download.head)
+	}
+	return sniffObject(backend, key)
+}
+
+// sniffObject lee los primeros bytes de una grabación ya guardada para
+// registrar su formato real.
+func sniffObject(backend storage.Backend, key string) (mediaFormat, bool) {
+	reader, err := backend.Open(key)
+	if err != nil {
+		return mediaFormat{}, false
+	}
+	defer reader.Close()
+	head, _ := io.ReadAll(io.LimitReader(reader, sniffLen))
+	return sniffFormat(head)
+}
+
+// screenFormat corrige el formato reconocido por el contenido de una
+// grabación de pantalla: el contenedor WEBM es el mismo que el de audio,
+// pero lo que guarda es video.
+func screenFormat(format mediaFormat) mediaFormat {
+	if format.Name == "WEBM" {
+		format.ContentType = "video/webm"
+	}
+	return format
+}
+
+// mediaSource es de dónde se descarga una grabación y qué se espera recibir.
+type mediaSource struct {
+	URL         string
+	ContentType string
+	Extension   string
+	// Screen indica si es una grabación de pantalla
+	Screen bool
+}
+
+// resolveMedia decide la URL y la extensión de una grabación del batch. Con
+// un formato pedido (opts.Format) la URL se pide a Genesys en ese formato;
+// si no, se usa la del batch. Las grabaciones de pantalla siempre se
+// descargan del batch, en su formato de video (WEBM o MP4). La extensión
+// sale del Content-Type y, si no se reconoce, de los primeros bytes del
+// archivo.
+func resolveMedia(ctx context.Context, item sdk.Batchdownloadjobresult, opts DownloadOptions) (mediaSource, error) {
+	source := mediaSource{URL: getString(item.ResultUrl), ContentType: getString(item.ContentType)}
+	source.Screen = opts.Details.Media(*item.ConversationId, *item.RecordingId) == "screen"
+	if opts.Format != "" && !source.Screen {
+		url, err := formatURL(ctx, opts.RecordingApi, opts.Format, *item.ConversationId, *item.RecordingId, opts.FormatRetries)
+		if err != nil {
+			return source, err
+		}
+		format, _ := lookupFormat(opts.Format)
+		source.URL, source.ContentType = url, format.ContentType
+	}
+
+	source.Extension = extensionFor(source.ContentType)
+	if source.Extension == "" {
+		if format, ok := sniffURL(ctx, source.URL); ok {
+			if source.Screen {
+				format = screenFormat(format)
+			}
+			source.ContentType, source.Extension = format.ContentType, format.Extension
+		} else {
+			logger.Log.Warn("Unknown recording format",
+				zap.String("RecordingID", *item.RecordingId),
+				zap.String("ContentType", source.ContentType))
+			source.Extension = unknownExtension
+		}
+	}
+	return source, nil
+}
+
+// formatPoll es la espera entre pedidos mientras Genesys prepara una
+// grabación, si no informa una estimación.
+const formatPoll = 5 * time.Second
+
+// formatURL pide la grabación en format y devuelve su URL de descarga.
+func formatURL(ctx context.Context, api *sdk.RecordingApi, format, conversationID, recordingID string, retries int) (string, error) {
+	recording, err := getRecording(ctx, api, conversationID, recordingID, format, true, retries)
+	if err != nil {
+		return "", fmt.Errorf("requesting recording in %s: %w", format, err)
+	}
+	if uri := mediaURI(recording); uri != "" {
+		return uri, nil
+	}
+	return "", fmt.Errorf("recording has no media URI for format %s", format)
+}
+
+// getRecording pide una grabación a Genesys. Mientras la prepara (202:
+// conversión de formato o armado de la transcripción) espera y vuelve a
+// pedirla, hasta retries veces.
+func getRecording(ctx context.Context, api *sdk.RecordingApi, conversationID, recordingID, format string, download bool, retries int) (*sdk.Recording, error) {
+	for attempt := 0; ; attempt++ {
+		recording, response, err := api.GetConversationRecording(conversationID, recordingID, format, "", "", "", download, "", "", nil)
+		if err != nil {
+			if isCanceled(ctx, err) {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+		if response.StatusCode == http.StatusOK {
+			return recording, nil
+		}
+		if attempt >= retries {
+			return nil, fmt.Errorf("recording not ready after %d attempts", attempt+1)
+		}
+
+		wait := formatPoll
+		if recording != nil && recording.EstimatedTranscodeTimeMs != nil && *recording.EstimatedTranscodeTimeMs > 0 {
+			wait = min(max(time.Duration(*recording.EstimatedTranscodeTimeMs)*time.Millisecond, time.Second), time.Minute)
+		}
+		logger.Log.Debug("Waiting for recording",
+			zap.String("RecordingID", recordingID),
+			zap.String("Format", format),
+			zap.Duration("Wait", wait))
+		if err := sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// mediaURI devuelve la URI de la grabación: la de la mezcla ("S") si existe,
+// si no la primera.
+func mediaURI(recording *sdk.Recording) string {
+	if recording == nil || recording.MediaUris == nil {
+		return ""
+	}
+	uris := *recording.MediaUris
+	if uri := getString(uris["S"].MediaUri); uri != "" {
+		return uri
+	}
+	keys := make([]string, 0, len(uris))
+	for key := range uris {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if uri := getString(uris[key].MediaUri); uri != "" {
+			return uri
+		}
+	}
+	return ""
+}
@@ -0,0 +1,270 @@
+package functions
+
+import (
+	"path"
+	"sync"
+
+	"github.com/goDownloadRecording/archive"
+	"github.com/goDownloadRecording/layout"
+	"github.com/goDownloadRecording/logger"
+	"github.com/goDownloadRecording/storage"
+	sdk "github.com/mypurecloud/platform-client-sdk-go/v157/platformclientv2"
+	"go.uber.org/zap"
+)
+
+// defaultLayout es la estructura de carpetas cuando no se configuran
+// plantillas (y la de un *Paths nil).
+var defaultLayout, _ = layout.New("", "", "")
+
+// Paths decide dónde se guarda cada grabación según las plantillas de
+// carpeta y archivo. Dos grabaciones pueden caer en la misma clave (p.ej.
+// si la plantilla de archivo no incluye {recordingId}, o si sus valores
+// quedan iguales al sanearlos); en ese caso a la segunda se le agrega
+// _<recordingId> al nombre. Una clave está ocupada si ya se asignó a otra
+// grabación en esta corrida, si el índice la registra para otra grabación o,
+// cuando la clave no incluye el recordingId, si el objeto existe en el
+// backend sin ser de esta grabación. Es seguro para uso concurrente; todos
+// los métodos aceptan un *Paths nil.
+type Paths struct {
+	layout  *layout.Layout
+	names   *Names
+	backend storage.Backend
+	index   *archive.Index
+
+	mu       sync.Mutex
+	reserved map[string]string // clave -> recordingId
+	sidecars map[string]bool   // sidecars de conversación ya escritos
+}
+
+// NewPaths crea un Paths. names puede ser nil si las plantillas no usan
+// {queue} ni {division}.
+func NewPaths(l *layout.Layout, names *Names, backend storage.Backend, index *archive.Index) *Paths {
+	return &Paths{
+		layout:   l,
+		names:    names,
+		backend:  backend,
+		index:    index,
+		reserved: make(map[string]string),
+		sidecars: make(map[string]bool),
+	}
+}
+
+// Keys devuelve la carpeta y la clave del archivo (con extensión ext) de una
+// grabación. conversation puede ser nil si no se obtuvo su detalle.
+func (p *Paths) Keys(conversation *sdk.Analyticsconversationwithoutattributes, conversationID, recordingID, ext string) (folderKey, fileKey string) {
+	if p == nil {
+		fields := layout.Fields{ConversationID: conversationID, RecordingID: recordingID}
+		folderKey = defaultLayout.Folder(fields)
+		return folderKey, path.Join(folderKey, defaultLayout.File(fields)+ext)
+	}
+
+	fields := p.fields(conversation, conversationID, recordingID)
+	folderKey = p.layout.Folder(fields)
+	fileKey = path.Join(folderKey, p.layout.File(fields)+ext)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.taken(fileKey, fields) {
+		unique := path.Join(folderKey, p.layout.File(fields)+"_"+layout.Sanitize(recordingID)+ext)
+		logger.Log.Warn("Recording path already in use, adding recording ID",
+			zap.String("Path", fileKey),
+			zap.String("NewPath", unique),
+			zap.String("RecordingID", recordingID))
+		fileKey = unique
+	}
+	p.reserved[fileKey] = recordingID
+	return folderKey, fileKey
+}
+
+// ConversationSidecar devuelve la clave del sidecar de la conversación. Si
+// la carpeta no es exclusiva de la conversación (la plantilla no incluye
+// {conversationId}), el nombre lleva el conversationId para no pisar el de
+// otras conversaciones.
+func (p *Paths) ConversationSidecar(folderKey, conversationID string) string {
+	if p == nil || p.layout.FolderUses("conversationId") {
+		return path.Join(folderKey, ConversationSidecarName)
+	}
+	return path.Join(folderKey, layout.Sanitize(conversationID)+"."+ConversationSidecarName)
+}
+
+// claimSidecar indica si le toca a quien llama escribir el sidecar de
+// conversación key: devuelve true sólo la primera vez en la corrida, de modo
+// que no se reescribe por cada grabación ni lo escriben dos workers a la vez.
+// Si la escritura falla hay que liberarlo con releaseSidecar. Con un *Paths
+// nil siempre devuelve true.
+func (p *Paths) claimSidecar(key string) bool {
+	if p == nil {
+		return true
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.sidecars[key] {
+		return false
+	}
+	p.sidecars[key] = true
+	return true
+}
+
+// releaseSidecar permite volver a escribir el sidecar de conversación key.
+func (p *Paths) releaseSidecar(key string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.sidecars, key)
+}
+
+// taken indica si fileKey ya está ocupada por otra grabación que la de
+// fields. Se llama con p.mu tomado.
+func (p *Paths) taken(fileKey string, fields layout.Fields) bool {
+	recordingID := fields.RecordingID
+	if owner, ok := p.reserved[fileKey]; ok && owner != recordingID {
+		return true
+	}
+	owner := p.index.Owner(fileKey)
+	if owner != "" {
+		return owner != recordingID
+	}
+	if p.backend == nil || p.layout.Uses("recordingId") {
+		// Con el recordingId en la clave, un objeto sin dueño en el índice
+		// es de una descarga anterior de la misma grabación
+		return false
+	}
+	backend := storage.Scope(p.backend, storage.Recording{ConversationID: fields.ConversationID, Start: fields.Start})
+	_, exists, err := backend.Stat(fileKey)
+	if err != nil {
+		logger.Log.Warn("Failed to check recording path", zap.String("Key", fileKey), zap.Error(err))
+		return false
+	}
+	return exists
+}
+
+// fields arma los valores de las plantillas a partir del detalle de la
+// conversación.
+func (p *Paths) fields(conversation *sdk.Analyticsconversationwithoutattributes, conversationID, recordingID string) layout.Fields {
+	fields := layout.Fields{ConversationID: conversationID, RecordingID: recordingID}
+	if conversation == nil {
+		return fields
+	}
+	if conversation.ConversationStart != nil {
+		fields.Start = *conversation.ConversationStart
+	}
+	fields.Direction = getString(conversation.OriginatingDirection)
+	if conversation.DivisionIds != nil && len(*conversation.DivisionIds) > 0 {
+		fields.DivisionID = (*conversation.DivisionIds)[0]
+	}
+	if conversation.Participants != nil {
+		for _, participant := range *conversation.Participants {
+			if fields.AgentID == "" && getString(participant.Purpose) == "agent" {
+				fields.AgentID = getString(participant.UserId)
+				fields.Agent = getString(participant.ParticipantName)
+				if fields.Agent == "" {
+					fields.Agent = fields.AgentID
+				}
+			}
+			if fields.QueueID == "" {
+				fields.QueueID = firstQueue(participant)
+			}
+		}
+	}
+	if p.layout.Uses("queue") {
+		fields.Queue = p.names.Queue(fields.QueueID)
+	}
+	if p.layout.Uses("division") {
+		fields.Division = p.names.Division(fields.DivisionID)
+	}
+	return fields
+}
+
+// firstQueue devuelve la primera cola por la que pasó el participante.
+func firstQueue(participant sdk.Analyticsparticipantwithoutattributes) string {
+	if participant.Sessions == nil {
+		return ""
+	}
+	for _, session := range *participant.Sessions {
+		if session.Segments == nil {
+			continue
+		}
+		for _, segment := range *session.Segments {
+			if queueID := getString(segment.QueueId); queueID != "" {
+				return queueID
+			}
+		}
+	}
+	return ""
+}
+
+// Names traduce ids de colas y divisiones a sus nombres, pidiéndolos a la
+// API una sola vez por id. Si un pedido falla se usa el id. Todos los
+// métodos aceptan un *Names nil (devuelven el id).
+type Names struct {
+	routingApi       *sdk.RoutingApi
+	authorizationApi *sdk.AuthorizationApi
+
+	mu        sync.Mutex
+	queues    map[string]string
+	divisions map[string]string
+}
+
+// NewNames crea un Names que consulta routingApi y authorizationApi.
+func NewNames(routingApi *sdk.RoutingApi, authorizationApi *sdk.AuthorizationApi) *Names {
+	return &Names{
+		routingApi:       routingApi,
+		authorizationApi: authorizationApi,
+		queues:           make(map[string]string),
+		divisions:        make(map[string]string),
+	}
+}
+
+// Queue devuelve el nombre de la cola queueID.
+func (n *Names) Queue(queueID string) string {
+	if n == nil {
+		return queueID
+	}
+	return n.lookup(n.queues, queueID, func() (*string, error) {
+		queue, _, err := n.routingApi.GetRoutingQueue(queueID)
+		if err != nil {
+			return nil, err
+		}
+		return queue.Name, nil
+	})
+}
+
+// Division devuelve el nombre de la división divisionID.
+func (n *Names) Division(divisionID string) string {
+	if n == nil {
+		return divisionID
+	}
+	return n.lookup(n.divisions, divisionID, func() (*string, error) {
+		division, _, err := n.authorizationApi.GetAuthorizationDivision(divisionID, false)
+		if err != nil {
+			return nil, err
+		}
+		return division.Name, nil
+	})
+}
+
+func (n *Names) lookup(cache map[string]string, id string, fetch func() (*string, error)) string {
+	if id == "" {
+		return ""
+	}
+	n.mu.Lock()
+	name, ok := cache[id]
+	n.mu.Unlock()
+	if ok {
+		return name
+	}
+
+	name = id
+	fetched, err := fetch()
+	if err != nil {
+		logger.Log.Warn("Failed to resolve name, using ID", zap.String("ID", id), zap.Error(err))
+	} else if getString(fetched) != "" {
+		name = *fetched
+	}
+	n.mu.Lock()
+	cache[id] = name
+	n.mu.Unlock()
+	return name
+}
@@ -0,0 +1,89 @@
+package functions
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/goDownloadRecording/archive"
+	"github.com/goDownloadRecording/layout"
+	"github.com/goDownloadRecording/storage"
+	sdk "github.com/mypurecloud/platform-client-sdk-go/v157/platformclientv2"
+)
+
+func TestPathsCollisions(t *testing.T) {
+	start := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	conversation := &sdk.Analyticsconversationwithoutattributes{ConversationStart: &start}
+	tests := []struct {
+		name, folder, file string
+		recordings         [][2]string // conversationId, recordingId
+		want               []string
+	}{
+		{"sin recordingId", "{date}", "{date:1504}",
+			[][2]string{{"c1", "r1"}, {"c2", "r2"}, {"c1", "r1"}},
+			[]string{"250601/1000.mp3", "250601/1000_r2.mp3", "250601/1000.mp3"}},
+		{"recordingId que se sanea igual", "{date}-{conversationId}", "{recordingId}",
+			[][2]string{{"c1", "r:1"}, {"c1", "r_1"}, {"c1", "r2"}},
+			[]string{"250601-c1/r_1.mp3", "250601-c1/r_1_r_1.mp3", "250601-c1/r2.mp3"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l, err := layout.New(test.folder, test.file, "UTC")
+			if err != nil {
+				t.Fatal(err)
+			}
+			paths := NewPaths(l, nil, nil, nil)
+			for i, rec := range test.recordings {
+				if _, got := paths.Keys(conversation, rec[0], rec[1], ".mp3"); got != test.want[i] {
+					t.Errorf("%s: got %q, want %q", rec[1], got, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPathsTakenByIndexAndStorage(t *testing.T) {
+	root := t.TempDir()
+	index, err := archive.Open(filepath.Join(root, "index.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	if err := index.Add(archive.Entry{RecordingID: "r-old", ConversationID: "c0", Path: "ventas/r1.mp3"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "ventas"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"agente.mp3", "r2.mp3"} {
+		if err := os.WriteFile(filepath.Join(root, "ventas", name), []byte("audio"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backend := storage.NewLocal(root)
+
+	// El índice manda aunque la clave incluya el recordingId
+	byID, err := layout.New("ventas", "{recordingId}", "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := NewPaths(byID, nil, backend, index)
+	if _, got := paths.Keys(nil, "c1", "r1", ".mp3"); got != "ventas/r1_r1.mp3" {
+		t.Errorf("key owned in the index: got %q", got)
+	}
+	// Un archivo sin dueño con el recordingId en el nombre es de la misma grabación
+	if _, got := paths.Keys(nil, "c2", "r2", ".mp3"); got != "ventas/r2.mp3" {
+		t.Errorf("previous download of the same recording: got %q", got)
+	}
+
+	// Sin el recordingId, un archivo existente es de otra grabación
+	byAgent, err := layout.New("ventas", "agente", "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths = NewPaths(byAgent, nil, backend, index)
+	if _, got := paths.Keys(nil, "c3", "r3", ".mp3"); got != "ventas/agente_r3.mp3" {
+		t.Errorf("existing file: got %q", got)
+	}
+}
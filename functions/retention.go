@@ -0,0 +1,369 @@
+package functions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/goDownloadRecording/archive"
+	"github.com/goDownloadRecording/bundle"
+	"github.com/goDownloadRecording/logger"
+	"github.com/goDownloadRecording/retention"
+	"go.uber.org/zap"
+)
+
+// ActionInPackage marca en el reporte una grabación vencida cuyo paquete no
+// se puede borrar todavía: tiene otras grabaciones vigentes o retenidas.
+const ActionInPackage = "in-package"
+
+// PurgeOptions configura PurgeDownloads.
+type PurgeOptions struct {
+	// Root es la carpeta de descargas (storage local).
+	Root   string
+	Policy *retention.Policy
+	// Now es la fecha contra la que se calcula el vencimiento (cero = ahora).
+	Now time.Time
+	// DryRun sólo arma el reporte, sin borrar nada.
+	DryRun bool
+	// Audit registra cada borrado; es obligatorio salvo con DryRun.
+	Audit *retention.Audit
+}
+
+// PurgeItem es una grabación vencida del reporte.
+type PurgeItem struct {
+	Entry    archive.Entry
+	Start    time.Time
+	Decision retention.Decision
+	// Action es la de Decision o ActionInPackage
+	Action string
+	Size   int64
+}
+
+// PurgeReport resume una purga. Con DryRun, Deleted y Bytes son lo que se
+// borraría.
+type PurgeReport struct {
+	// Items son las grabaciones vencidas (borradas, retenidas, sin colas ni
+	// divisiones conocidas o en un paquete que sigue vigente), ordenadas por
+	// ruta.
+	Items     []PurgeItem
+	Kept      int
+	Held      int
+	Unknown   int
+	InPackage int
+	Deleted   int
+	Failed    int
+	Bytes     int64
+}
+
+// PurgeDownloads aplica la política de retención a las grabaciones del
+// índice. Las vencidas que no están bajo legal hold se borran con todos sus
+// archivos (hash, sidecar, transcripciones), cada borrado se registra en
+// opts.Audit y la entrada del índice queda marcada como purgada para no
+// volver a descargarla. Un paquete se borra entero cuando todas sus
+// grabaciones vencieron y ninguna está retenida. La antigüedad se cuenta
+// desde el inicio de la conversación del sidecar (o la fecha de descarga si
+// no lo tiene). Sin sidecar legible no se conocen las colas ni divisiones:
+// si la política depende de ellas la grabación no se borra
+// (retention.ActionUnknown). Sólo aplica al storage local.
+func PurgeDownloads(index *archive.Index, opts PurgeOptions) (*PurgeReport, error) {
+	if opts.Policy == nil {
+		return nil, errors.New("a retention policy is required")
+	}
+	if !opts.DryRun && opts.Audit == nil {
+		return nil, errors.New("an audit log is required to purge")
+	}
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+
+	var loose []archive.Entry
+	bundles := make(map[string][]archive.Entry)
+	for _, entry := range index.Entries() {
+		switch {
+		case entry.PurgedAt != nil:
+		case entry.Bundle != "":
+			bundles[entry.Bundle] = append(bundles[entry.Bundle], entry)
+		case entry.Path != "":
+			loose = append(loose, entry)
+		}
+	}
+
+	p := &purge{index: index, opts: opts, report: &PurgeReport{}, folders: make(map[string]bool)}
+	for _, entry := range loose {
+		var known *RecordingSidecar
+		sidecar, err := readSidecar(opts.Root, entry.Path)
+		if err != nil {
+			logger.Log.Warn("Recording sidecar not available, queues and divisions unknown", zap.String("Path", entry.Path), zap.Error(err))
+		} else {
+			known = &sidecar
+		}
+		item := p.evaluate(entry, known)
+		if item == nil || item.Action != retention.ActionDelete || opts.DryRun {
+			continue
+		}
+		if err := p.removeRecording(item); err != nil {
+			return p.report, err
+		}
+	}
+
+	names := make([]string, 0, len(bundles))
+	for name := range bundles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := p.purgeBundle(name, bundles[name]); err != nil {
+			return p.report, err
+		}
+	}
+
+	cleanupFolders(opts.Root, p.folders)
+
+	sort.Slice(p.report.Items, func(a, b int) bool { return p.report.Items[a].Entry.Path < p.report.Items[b].Entry.Path })
+	return p.report, nil
+}
+
+// purge es el estado de una corrida de PurgeDownloads.
+type purge struct {
+	index   *archive.Index
+	opts    PurgeOptions
+	report  *PurgeReport
+	folders map[string]bool
+}
+
+// evaluate aplica la política a una grabación y la cuenta en el reporte.
+// sidecar es nil si no se pudo leer. Devuelve el ítem agregado al reporte, o
+// nil si la grabación no venció.
+func (p *purge) evaluate(entry archive.Entry, sidecar *RecordingSidecar) *PurgeItem {
+	item := retention.Item{
+		RecordingID:    entry.RecordingID,
+		ConversationID: entry.ConversationID,
+		Start:          entry.DownloadedAt,
+		Unknown:        true,
+	}
+	if sidecar != nil && sidecar.Summary != nil {
+		summary := sidecar.Summary
+		item.QueueIDs, item.DivisionIDs, item.Unknown = summary.QueueIDs, summary.DivisionIDs, false
+		if summary.Start != nil {
+			item.Start = *summary.Start
+		}
+	}
+	decision := p.opts.Policy.Evaluate(item, p.opts.Now)
+	switch decision.Action {
+	case retention.ActionKeep:
+		p.report.Kept++
+		return nil
+	case retention.ActionHold:
+		p.report.Held++
+	case retention.ActionUnknown:
+		p.report.Unknown++
+	case retention.ActionDelete:
+		p.report.Deleted++
+		p.report.Bytes += entry.Size
+	}
+	p.report.Items = append(p.report.Items, PurgeItem{Entry: entry, Start: item.Start, Decision: decision, Action: decision.Action, Size: entry.Size})
+	return &p.report.Items[len(p.report.Items)-1]
+}
+
+// removeRecording borra los archivos sueltos de una grabación vencida, lo
+// registra en el log de auditoría y marca la entrada como purgada. Sólo un
+// error del log de auditoría o del índice corta la purga.
+func (p *purge) removeRecording(item *PurgeItem) error {
+	entry := item.Entry
+	audit := p.auditEntry(item)
+	recording, ok := collectRecording(p.opts.Root, entry)
+	if err := p.recordStarted(audit, fileNames(recording.files)); err != nil {
+		return err
+	}
+	if !ok {
+		// El archivo ya no estaba: se registra igual para cerrar la entrada
+		audit.Error = "recording file was already missing"
+	}
+	audit.Files = removeFiles(p.opts.Root, recording.files)
+	p.folders[path.Dir(entry.Path)] = true
+
+	if len(audit.Files) < len(recording.files) {
+		audit.Action = retention.AuditFailed
+		audit.Error = fmt.Sprintf("%d of %d files could not be removed", len(recording.files)-len(audit.Files), len(recording.files))
+		p.report.Failed++
+		p.report.Deleted--
+		p.report.Bytes -= item.Size
+		return p.record(audit)
+	}
+	if err := p.record(audit); err != nil {
+		return err
+	}
+	return p.markPurged(entry)
+}
+
+// purgeBundle borra un paquete si todas sus grabaciones vencieron y ninguna
+// está retenida ni es desconocida; si no, sus grabaciones vencidas quedan
+// como ActionInPackage.
+func (p *purge) purgeBundle(bundlePath string, entries []archive.Entry) error {
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, sidecarKey(entry.Path))
+	}
+	sidecars, err := readBundleSidecars(bundlePath, names)
+	if err != nil {
+		logger.Log.Warn("Failed to read sidecars from package, queues and divisions unknown", zap.String("Package", bundlePath), zap.Error(err))
+	}
+
+	first := len(p.report.Items)
+	expired := 0
+	for _, entry := range entries {
+		var known *RecordingSidecar
+		if sidecar, ok := sidecars[sidecarKey(entry.Path)]; ok {
+			known = &sidecar
+		}
+		if item := p.evaluate(entry, known); item != nil && item.Action == retention.ActionDelete {
+			expired++
+		}
+	}
+	items := p.report.Items[first:]
+	if expired < len(entries) {
+		for i := range items {
+			if items[i].Action == retention.ActionDelete {
+				items[i].Action = ActionInPackage
+				p.report.Deleted--
+				p.report.Bytes -= items[i].Size
+				p.report.InPackage++
+			}
+		}
+		return nil
+	}
+	if p.opts.DryRun {
+		return nil
+	}
+
+	// Se registra la intención de cada grabación antes de borrar el paquete y
+	// los archivos sueltos que hayan quedado si se empaquetó sin -remove
+	bundleFiles := []string{bundlePath, bundlePath + ".manifest.json", bundlePath + ".sha256"}
+	leftovers := make([][]bundle.File, len(items))
+	for i := range items {
+		recording, _ := collectRecording(p.opts.Root, items[i].Entry)
+		leftovers[i] = recording.files
+		audit := p.auditEntry(&items[i])
+		audit.Bundle = bundlePath
+		if err := p.recordStarted(audit, slices.Concat(bundleFiles, fileNames(leftovers[i]))); err != nil {
+			return err
+		}
+	}
+
+	var removed []string
+	var failed error
+	for _, file := range bundleFiles {
+		err := os.Remove(file)
+		switch {
+		case err == nil:
+			removed = append(removed, file)
+		case !os.IsNotExist(err):
+			failed = errors.Join(failed, err)
+		}
+	}
+	for i := range items {
+		audit := p.auditEntry(&items[i])
+		audit.Bundle = bundlePath
+		audit.Files = removed
+		if failed != nil {
+			audit.Action, audit.Error = retention.AuditFailed, failed.Error()
+			p.report.Failed++
+			p.report.Deleted--
+			p.report.Bytes -= items[i].Size
+			if err := p.record(audit); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(leftovers[i]) > 0 {
+			audit.Files = append(audit.Files, removeFiles(p.opts.Root, leftovers[i])...)
+			p.folders[path.Dir(items[i].Entry.Path)] = true
+		}
+		if err := p.record(audit); err != nil {
+			return err
+		}
+		if err := p.markPurged(items[i].Entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readBundleSidecars lee del paquete los sidecars names.
+func readBundleSidecars(bundlePath string, names []string) (map[string]RecordingSidecar, error) {
+	files, err := bundle.ReadFiles(bundlePath, names)
+	sidecars := make(map[string]RecordingSidecar, len(files))
+	for name, data := range files {
+		var sidecar RecordingSidecar
+		if jsonErr := json.Unmarshal(data, &sidecar); jsonErr != nil {
+			err = errors.Join(err, fmt.Errorf("%s: %w", name, jsonErr))
+			continue
+		}
+		sidecars[name] = sidecar
+	}
+	return sidecars, err
+}
+
+// fileNames devuelve las rutas de files.
+func fileNames(files []bundle.File) []string {
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, file.Path)
+	}
+	return names
+}
+
+func (p *purge) auditEntry(item *PurgeItem) retention.AuditEntry {
+	return retention.AuditEntry{
+		Action:            retention.AuditDeleted,
+		RecordingID:       item.Entry.RecordingID,
+		ConversationID:    item.Entry.ConversationID,
+		Path:              item.Entry.Path,
+		Size:              item.Size,
+		SHA256:            item.Entry.SHA256,
+		ConversationStart: item.Start.UTC(),
+		Rule:              item.Decision.Rule,
+		Days:              item.Decision.Days,
+		ExpiresAt:         item.Decision.ExpiresAt.UTC(),
+	}
+}
+
+// recordStarted registra que se van a borrar files de la grabación de audit.
+func (p *purge) recordStarted(audit retention.AuditEntry, files []string) error {
+	audit.Action, audit.Files = retention.AuditStarted, files
+	return p.record(audit)
+}
+
+// record escribe en el log de auditoría; sin registro no se sigue borrando.
+func (p *purge) record(audit retention.AuditEntry) error {
+	if err := p.opts.Audit.Record(audit); err != nil {
+		return fmt.Errorf("writing retention audit log: %w", err)
+	}
+	switch audit.Action {
+	case retention.AuditStarted:
+		return nil
+	case retention.AuditFailed:
+		logger.Log.Error("Failed to purge recording", zap.String("Path", audit.Path), zap.String("Error", audit.Error))
+		return nil
+	}
+	logger.Log.Info("Purged recording",
+		zap.String("Path", audit.Path),
+		zap.String("Rule", audit.Rule),
+		zap.Time("ExpiresAt", audit.ExpiresAt))
+	return nil
+}
+
+// markPurged deja la entrada en el índice como purgada.
+func (p *purge) markPurged(entry archive.Entry) error {
+	purgedAt := p.opts.Now.UTC()
+	entry.PurgedAt = &purgedAt
+	if err := p.index.Add(entry); err != nil {
+		return fmt.Errorf("updating archive index: %w", err)
+	}
+	return nil
+}
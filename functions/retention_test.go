@@ -0,0 +1,286 @@
+package functions
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/goDownloadRecording/archive"
+	"github.com/goDownloadRecording/bundle"
+	"github.com/goDownloadRecording/logger"
+	"github.com/goDownloadRecording/retention"
+	"go.uber.org/zap"
+)
+
+var purgeNow = time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func init() {
+	if logger.Log == nil {
+		logger.Log = zap.NewNop()
+	}
+}
+
+// purgeFixture es una carpeta de descargas con su índice.
+type purgeFixture struct {
+	t     *testing.T
+	root  string
+	index *archive.Index
+}
+
+func newPurgeFixture(t *testing.T) *purgeFixture {
+	t.Helper()
+	root := t.TempDir()
+	index, err := archive.Open(filepath.Join(root, "index.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { index.Close() })
+	return &purgeFixture{t: t, root: root, index: index}
+}
+
+// addRecording escribe una grabación de hace days días con su hash y, si
+// queues no es nil, su sidecar con esas colas.
+func (f *purgeFixture) addRecording(recordingID, conversationID string, days int, queues []string) archive.Entry {
+	f.t.Helper()
+	start := purgeNow.AddDate(0, 0, -days)
+	key := "250101-" + conversationID + "/" + recordingID + ".mp3"
+	f.write(key, "audio "+recordingID)
+	f.write(key+".sha256", "hash")
+	if queues != nil {
+		data, err := json.Marshal(RecordingSidecar{
+			SchemaVersion: 1,
+			Kind:          "recording",
+			Summary:       &ConversationSummary{ConversationID: conversationID, Start: &start, QueueIDs: queues},
+		})
+		if err != nil {
+			f.t.Fatal(err)
+		}
+		f.write(sidecarKey(key), string(data))
+	}
+	entry := archive.Entry{RecordingID: recordingID, ConversationID: conversationID, Path: key, Size: 10, DownloadedAt: start}
+	if err := f.index.Add(entry); err != nil {
+		f.t.Fatal(err)
+	}
+	return entry
+}
+
+func (f *purgeFixture) write(key, content string) {
+	f.t.Helper()
+	name := filepath.Join(f.root, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+		f.t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func (f *purgeFixture) exists(key string) bool {
+	_, err := os.Stat(filepath.Join(f.root, filepath.FromSlash(key)))
+	return err == nil
+}
+
+// purge corre PurgeDownloads con policy y devuelve el reporte y las líneas
+// del log de auditoría.
+func (f *purgeFixture) purge(policy *retention.Policy, dryRun bool) (*PurgeReport, []retention.AuditEntry) {
+	f.t.Helper()
+	if err := policy.Validate(); err != nil {
+		f.t.Fatal(err)
+	}
+	auditPath := filepath.Join(f.root, "audit.jsonl")
+	opts := PurgeOptions{Root: f.root, Policy: policy, Now: purgeNow, DryRun: dryRun}
+	if !dryRun {
+		audit, err := retention.OpenAudit(auditPath)
+		if err != nil {
+			f.t.Fatal(err)
+		}
+		defer audit.Close()
+		opts.Audit = audit
+	}
+	report, err := PurgeDownloads(f.index, opts)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return report, readAudit(f.t, auditPath)
+}
+
+func readAudit(t *testing.T, path string) []retention.AuditEntry {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []retention.AuditEntry
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for decoder.More() {
+		var entry retention.AuditEntry
+		if err := decoder.Decode(&entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestPurgeMissingSidecarWithQueueHold(t *testing.T) {
+	f := newPurgeFixture(t)
+	entry := f.addRecording("r1", "c1", 100, nil)
+	policy := &retention.Policy{
+		DefaultDays: 30,
+		LegalHolds:  []retention.Hold{{Name: "auditoria", Queues: []string{"q-auditoria"}}},
+	}
+
+	report, _ := f.purge(policy, true)
+	if report.Unknown != 1 || report.Deleted != 0 || len(report.Items) != 1 || report.Items[0].Action != retention.ActionUnknown {
+		t.Fatalf("dry run: got %+v", report)
+	}
+
+	report, audit := f.purge(policy, false)
+	if report.Unknown != 1 || report.Deleted != 0 {
+		t.Fatalf("got %+v", report)
+	}
+	if !f.exists(entry.Path) {
+		t.Error("recording with unknown queues was deleted")
+	}
+	if len(audit) != 0 {
+		t.Errorf("unexpected audit entries: %+v", audit)
+	}
+	if got, _ := f.index.Lookup("r1"); got.PurgedAt != nil {
+		t.Error("recording with unknown queues was marked as purged")
+	}
+}
+
+func TestPurgeMissingSidecarWithoutQueueRules(t *testing.T) {
+	// Sin reglas ni holds por cola, la fecha de descarga alcanza para decidir
+	f := newPurgeFixture(t)
+	entry := f.addRecording("r1", "c1", 100, nil)
+	report, _ := f.purge(&retention.Policy{DefaultDays: 30}, false)
+	if report.Deleted != 1 || f.exists(entry.Path) {
+		t.Fatalf("got %+v", report)
+	}
+}
+
+func TestPurgeExpiredRecording(t *testing.T) {
+	f := newPurgeFixture(t)
+	old := f.addRecording("r-old", "c1", 40, []string{"q1"})
+	recent := f.addRecording("r-recent", "c2", 10, []string{"q1"})
+
+	report, audit := f.purge(&retention.Policy{DefaultDays: 30}, false)
+	if report.Deleted != 1 || report.Kept != 1 || report.Bytes != old.Size {
+		t.Fatalf("got %+v", report)
+	}
+	for _, key := range []string{old.Path, old.Path + ".sha256", sidecarKey(old.Path)} {
+		if f.exists(key) {
+			t.Errorf("%s was not deleted", key)
+		}
+	}
+	if !f.exists(recent.Path) {
+		t.Error("recording not yet expired was deleted")
+	}
+	if got, _ := f.index.Lookup("r-old"); got.PurgedAt == nil {
+		t.Error("deleted recording was not marked as purged")
+	}
+
+	// La intención se registra antes que el resultado, con los mismos archivos
+	if len(audit) != 2 || audit[0].Action != retention.AuditStarted || audit[1].Action != retention.AuditDeleted {
+		t.Fatalf("got audit %+v", audit)
+	}
+	if audit[0].RecordingID != "r-old" || len(audit[0].Files) != 3 || len(audit[1].Files) != 3 || audit[1].Rule != "default" {
+		t.Errorf("got audit %+v", audit)
+	}
+
+	// Una segunda corrida no vuelve a borrar ni a registrar nada
+	report, audit = f.purge(&retention.Policy{DefaultDays: 30}, false)
+	if report.Deleted != 0 || len(audit) != 2 {
+		t.Errorf("second run: got %+v, %d audit entries", report, len(audit))
+	}
+}
+
+func TestPurgeLegalHolds(t *testing.T) {
+	f := newPurgeFixture(t)
+	byConversation := f.addRecording("r1", "c-hold", 40, []string{"q1"})
+	byQueue := f.addRecording("r2", "c2", 40, []string{"q-auditoria"})
+	free := f.addRecording("r3", "c3", 40, []string{"q1"})
+	policy := &retention.Policy{
+		DefaultDays: 30,
+		LegalHolds: []retention.Hold{
+			{Name: "caso", Conversations: []string{"c-hold"}},
+			{Name: "auditoria", Queues: []string{"q-auditoria"}},
+		},
+	}
+
+	report, audit := f.purge(policy, false)
+	if report.Held != 2 || report.Deleted != 1 {
+		t.Fatalf("got %+v", report)
+	}
+	if !f.exists(byConversation.Path) || !f.exists(byQueue.Path) {
+		t.Error("a recording under legal hold was deleted")
+	}
+	if f.exists(free.Path) {
+		t.Error("expired recording without hold was not deleted")
+	}
+	for _, entry := range audit {
+		if entry.RecordingID != "r3" {
+			t.Errorf("unexpected audit entry %+v", entry)
+		}
+	}
+}
+
+func TestPurgeBundleAllOrNothing(t *testing.T) {
+	f := newPurgeFixture(t)
+	f.addRecording("r1", "c1", 40, []string{"q1"})
+	f.addRecording("r2", "c2", 40, []string{"q-auditoria"})
+	packages, err := PackageDownloads(f.index, PackageOptions{Root: f.root, OutDir: filepath.Join(f.root, "packages"), Format: bundle.FormatTarGz, GroupBy: GroupByRun, Remove: true})
+	if err != nil || len(packages.Packages) != 1 {
+		t.Fatalf("packaging: %v, %+v", err, packages)
+	}
+	bundlePath := packages.Packages[0]
+
+	// Con una grabación retenida el paquete queda entero
+	hold := &retention.Policy{DefaultDays: 30, LegalHolds: []retention.Hold{{Name: "auditoria", Queues: []string{"q-auditoria"}}}}
+	report, audit := f.purge(hold, false)
+	if report.Held != 1 || report.InPackage != 1 || report.Deleted != 0 {
+		t.Fatalf("got %+v", report)
+	}
+	if _, err := os.Stat(bundlePath); err != nil {
+		t.Fatalf("package with a held recording was deleted: %v", err)
+	}
+	if len(audit) != 0 {
+		t.Fatalf("unexpected audit entries: %+v", audit)
+	}
+
+	// Sin el hold se borran el paquete y sus archivos, y se registran las dos
+	report, audit = f.purge(&retention.Policy{DefaultDays: 30}, false)
+	if report.Deleted != 2 {
+		t.Fatalf("got %+v", report)
+	}
+	for _, name := range []string{bundlePath, bundlePath + ".manifest.json", bundlePath + ".sha256"} {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("%s was not deleted", name)
+		}
+	}
+	var started, deleted int
+	for i, entry := range audit {
+		if entry.Bundle != bundlePath {
+			t.Errorf("audit entry without the package: %+v", entry)
+		}
+		switch entry.Action {
+		case retention.AuditStarted:
+			started++
+		case retention.AuditDeleted:
+			deleted++
+			if started < 2 {
+				t.Errorf("audit entry %d: outcome recorded before every intent", i)
+			}
+		}
+	}
+	if started != 2 || deleted != 2 {
+		t.Errorf("got %d started and %d deleted audit entries", started, deleted)
+	}
+}
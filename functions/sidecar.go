@@ -0,0 +1,296 @@
+package functions
+
+import (
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/goDownloadRecording/crypt"
+	"github.com/goDownloadRecording/storage"
+	sdk "github.com/mypurecloud/platform-client-sdk-go/v157/platformclientv2"
+)
+
+// SidecarSchemaVersion es la versión del formato de los sidecars JSON. Se
+// incrementa cuando un cambio deja de ser compatible para quien los lee
+// (quitar o renombrar campos); agregar campos no cambia la versión.
+const SidecarSchemaVersion = 1
+
+// ConversationSidecarName es el nombre del sidecar de cada conversación,
+// dentro de su carpeta (ver Paths.ConversationSidecar).
+const ConversationSidecarName = "conversation.json"
+
+// RecordingSidecar es el sidecar de una grabación (el nombre del archivo con
+// extensión .json).
+type RecordingSidecar struct {
+	SchemaVersion int                  `json:"schemaVersion"`
+	Kind          string               `json:"kind"` // "recording"
+	ExportedAt    time.Time            `json:"exportedAt"`
+	Recording     RecordingFile        `json:"recording"`
+	Summary       *ConversationSummary `json:"summary,omitempty"`
+	// Metadata es la Recordingmetadata de Genesys tal cual.
+	Metadata *sdk.Recordingmetadata `json:"metadata,omitempty"`
+	// Conversation es el detalle de analytics de la conversación tal cual.
+	Conversation *sdk.Analyticsconversationwithoutattributes `json:"conversation,omitempty"`
+	// Sealed es, con cifrado en reposo, el nombre del sidecar completo
+	// cifrado; este queda como índice en claro (ver indexSidecar).
+	Sealed string `json:"sealed,omitempty"`
+}
+
+// ConversationSidecar es el sidecar de una conversación (conversation.json).
+type ConversationSidecar struct {
+	SchemaVersion int                                         `json:"schemaVersion"`
+	Kind          string                                      `json:"kind"` // "conversation"
+	ExportedAt    time.Time                                   `json:"exportedAt"`
+	Summary       *ConversationSummary                        `json:"summary,omitempty"`
+	Conversation  *sdk.Analyticsconversationwithoutattributes `json:"conversation,omitempty"`
+	// Recordings es la metadata de todas las grabaciones de la conversación,
+	// descargadas o no.
+	Recordings []sdk.Recordingmetadata `json:"recordings"`
+}
+
+// RecordingFile describe el archivo descargado.
+type RecordingFile struct {
+	RecordingID    string `json:"recordingId"`
+	ConversationID string `json:"conversationId"`
+	ContentType    string `json:"contentType,omitempty"`
+	// Format es el formato real del archivo (WAV, WAV_ULAW, WEBM, OGG_OPUS,
+	// OGG_VORBIS, MP3), reconocido por su contenido.
+	Format string `json:"format,omitempty"`
+	File   string `json:"file"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// Encryption indica con qué clave se cifró el archivo (ver crypt); Size
+	// y SHA256 son los del archivo cifrado.
+	Encryption *crypt.Info `json:"encryption,omitempty"`
+}
+
+// ConversationSummary reúne los datos de la conversación que más se usan,
+// para no tener que recorrer participants, sessions y segments.
+type ConversationSummary struct {
+	ConversationID string               `json:"conversationId"`
+	Start          *time.Time           `json:"start,omitempty"`
+	End            *time.Time           `json:"end,omitempty"`
+	DurationMs     int64                `json:"durationMs,omitempty"`
+	Direction      string               `json:"direction,omitempty"`
+	DivisionIDs    []string             `json:"divisionIds,omitempty"`
+	ANI            []string             `json:"ani,omitempty"`
+	DNIS           []string             `json:"dnis,omitempty"`
+	QueueIDs       []string             `json:"queueIds,omitempty"`
+	AgentIDs       []string             `json:"agentIds,omitempty"`
+	WrapUpCodes    []string             `json:"wrapUpCodes,omitempty"`
+	Participants   []ParticipantSummary `json:"participants,omitempty"`
+}
+
+// ParticipantSummary resume un participante de la conversación.
+type ParticipantSummary struct {
+	ParticipantID string   `json:"participantId,omitempty"`
+	Name          string   `json:"name,omitempty"`
+	Purpose       string   `json:"purpose,omitempty"`
+	UserID        string   `json:"userId,omitempty"`
+	MediaTypes    []string `json:"mediaTypes,omitempty"`
+}
+
+// Summarize arma el resumen de una conversación. Los valores repetidos entre
+// participantes, sesiones y segmentos aparecen una sola vez.
+func Summarize(conversation *sdk.Analyticsconversationwithoutattributes) *ConversationSummary {
+	if conversation == nil {
+		return nil
+	}
+	summary := &ConversationSummary{
+		ConversationID: getString(conversation.ConversationId),
+		Start:          conversation.ConversationStart,
+		End:            conversation.ConversationEnd,
+		Direction:      getString(conversation.OriginatingDirection),
+	}
+	if summary.Start != nil && summary.End != nil {
+		summary.DurationMs = summary.End.Sub(*summary.Start).Milliseconds()
+	}
+	if conversation.DivisionIds != nil {
+		summary.DivisionIDs = *conversation.DivisionIds
+	}
+
+	ani, dnis, queues, agents, wrapUps := newSet(), newSet(), newSet(), newSet(), newSet()
+	if conversation.Participants != nil {
+		for _, participant := range *conversation.Participants {
+			media := newSet()
+			if getString(participant.Purpose) == "agent" {
+				agents.add(getString(participant.UserId))
+			}
+			if participant.Sessions != nil {
+				for _, session := range *participant.Sessions {
+					ani.add(getString(session.Ani))
+					dnis.add(getString(session.Dnis))
+					media.add(getString(session.MediaType))
+					if session.Segments == nil {
+						continue
+					}
+					for _, segment := range *session.Segments {
+						queues.add(getString(segment.QueueId))
+						wrapUps.add(getString(segment.WrapUpCode))
+					}
+				}
+			}
+			summary.Participants = append(summary.Participants, ParticipantSummary{
+				ParticipantID: getString(participant.ParticipantId),
+				Name:          getString(participant.ParticipantName),
+				Purpose:       getString(participant.Purpose),
+				UserID:        getString(participant.UserId),
+				MediaTypes:    media.values(),
+			})
+		}
+	}
+	summary.ANI = ani.values()
+	summary.DNIS = dnis.values()
+	summary.QueueIDs = queues.values()
+	summary.AgentIDs = agents.values()
+	summary.WrapUpCodes = wrapUps.values()
+	return summary
+}
+
+// writeSidecars escribe el sidecar de la grabación junto al archivo y, con
+// la primera grabación de la conversación en la corrida, el de la
+// conversación en conversationKey (ver Paths.claimSidecar). Con cifrado ambos
+// se guardan cifrados y junto a la grabación queda en claro sólo su índice.
+func writeSidecars(opts DownloadOptions, conversationKey, fileKey string, item sdk.Batchdownloadjobresult, conversation *sdk.Analyticsconversationwithoutattributes, recordings []sdk.Recordingmetadata, download *downloadResult) error {
+	conversationID := getString(item.ConversationId)
+	recordingID := getString(item.RecordingId)
+	summary := Summarize(conversation)
+	now := time.Now().UTC()
+
+	recording := RecordingSidecar{
+		SchemaVersion: SidecarSchemaVersion,
+		Kind:          "recording",
+		ExportedAt:    now,
+		Recording: RecordingFile{
+			RecordingID:    recordingID,
+			ConversationID: conversationID,
+			ContentType:    download.ContentType,
+			Format:         download.Format,
+			File:           path.Base(fileKey),
+			Size:           download.Size,
+			SHA256:         download.SHA256,
+			Encryption:     download.Encryption,
+		},
+		Summary:      summary,
+		Conversation: conversation,
+	}
+	for i := range recordings {
+		if getString(recordings[i].Id) == recordingID {
+			recording.Metadata = &recordings[i]
+			break
+		}
+	}
+	sealedKey, err := writeSidecar(opts, sidecarKey(fileKey), recording)
+	if err != nil {
+		return err
+	}
+	if opts.Encryption != nil {
+		if err := writeJSON(opts.Storage, sidecarKey(fileKey), indexSidecar(recording, path.Base(sealedKey))); err != nil {
+			return err
+		}
+	}
+
+	if !opts.Paths.claimSidecar(conversationKey) {
+		return nil
+	}
+	if recordings == nil {
+		recordings = []sdk.Recordingmetadata{}
+	}
+	_, err = writeSidecar(opts, conversationKey, ConversationSidecar{
+		SchemaVersion: SidecarSchemaVersion,
+		Kind:          "conversation",
+		ExportedAt:    now,
+		Summary:       summary,
+		Conversation:  conversation,
+		Recordings:    recordings,
+	})
+	if err != nil {
+		opts.Paths.releaseSidecar(conversationKey)
+	}
+	return err
+}
+
+// indexSidecar devuelve el índice en claro de un sidecar cifrado: los ids,
+// el archivo con su hash y su keyId, y de la conversación sólo lo que usan
+// purge y package (inicio, colas y divisiones). No lleva ANI, DNIS,
+// participantes ni el detalle de Genesys.
+func indexSidecar(recording RecordingSidecar, sealed string) RecordingSidecar {
+	index := RecordingSidecar{
+		SchemaVersion: recording.SchemaVersion,
+		Kind:          recording.Kind,
+		ExportedAt:    recording.ExportedAt,
+		Recording:     recording.Recording,
+		Sealed:        sealed,
+	}
+	if summary := recording.Summary; summary != nil {
+		index.Summary = &ConversationSummary{
+			ConversationID: summary.ConversationID,
+			Start:          summary.Start,
+			End:            summary.End,
+			DurationMs:     summary.DurationMs,
+			DivisionIDs:    summary.DivisionIDs,
+			QueueIDs:       summary.QueueIDs,
+		}
+	}
+	return index
+}
+
+// sidecarKey devuelve la clave del sidecar de una grabación: la misma que el
+// archivo con extensión .json.
+func sidecarKey(fileKey string) string {
+	return recordingBase(fileKey) + ".json"
+}
+
+// recordingBase quita la extensión del archivo de una grabación (y la de
+// cifrado, si la tiene).
+func recordingBase(fileKey string) string {
+	fileKey = plainName(fileKey)
+	if strings.HasSuffix(fileKey, transcriptExtension) {
+		return strings.TrimSuffix(fileKey, transcriptExtension)
+	}
+	return fileKey[:len(fileKey)-len(path.Ext(fileKey))]
+}
+
+// writeSidecar guarda value como JSON en key, cifrado si opts tiene cifrado.
+// Devuelve la clave guardada.
+func writeSidecar(opts DownloadOptions, key string, value interface{}) (string, error) {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return key, err
+	}
+	key, _, err = writeContent(opts, key, append(data, '\n'))
+	return key, err
+}
+
+func writeJSON(backend storage.Backend, key string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	return backend.WriteFile(key, append(data, '\n'))
+}
+
+// set acumula valores no vacíos sin repetir.
+type set map[string]bool
+
+func newSet() set { return set{} }
+
+func (s set) add(value string) {
+	if value != "" {
+		s[value] = true
+	}
+}
+
+func (s set) values() []string {
+	if len(s) == 0 {
+		return nil
+	}
+	values := make([]string, 0, len(s))
+	for value := range s {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return values
+}
@@ -0,0 +1,156 @@
+package functions
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/goDownloadRecording/crypt"
+	"github.com/goDownloadRecording/layout"
+	"github.com/goDownloadRecording/storage"
+	sdk "github.com/mypurecloud/platform-client-sdk-go/v157/platformclientv2"
+)
+
+func TestWriteSidecarsEncrypted(t *testing.T) {
+	root := t.TempDir()
+	keyFile := filepath.Join(root, "recordings.key")
+	if err := os.WriteFile(keyFile, []byte(hex.EncodeToString(bytes.Repeat([]byte{7}, 32))), 0600); err != nil {
+		t.Fatal(err)
+	}
+	enc, err := crypt.NewEncrypter(keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := crypt.LoadKeyring([]string{keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	ani, queue, name := "tel:+5491100000000", "q1", "Cliente"
+	conversationID, recordingID, purpose := "c1", "r1", "customer"
+	conversation := &sdk.Analyticsconversationwithoutattributes{
+		ConversationId:    &conversationID,
+		ConversationStart: &start,
+		Participants: &[]sdk.Analyticsparticipantwithoutattributes{{
+			ParticipantName: &name,
+			Purpose:         &purpose,
+			Sessions: &[]sdk.Analyticssession{{
+				Ani:      &ani,
+				Segments: &[]sdk.Analyticsconversationsegment{{QueueId: &queue}},
+			}},
+		}},
+	}
+	opts := DownloadOptions{Storage: storage.NewLocal(root), Encryption: enc}
+	item := sdk.Batchdownloadjobresult{ConversationId: &conversationID, RecordingId: &recordingID}
+	download := &downloadResult{Size: 10, SHA256: "abc", Encryption: enc.Info()}
+	fileKey := "250101-c1/r1.wav" + crypt.Extension
+	if err := writeSidecars(opts, "250101-c1/"+ConversationSidecarName, fileKey, item, conversation, nil, download); err != nil {
+		t.Fatal(err)
+	}
+
+	// El índice en claro no tiene datos personales, pero sí lo que usan
+	// purge y package
+	plain, err := os.ReadFile(filepath.Join(root, "250101-c1", "r1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{ani, name, "participants"} {
+		if bytes.Contains(plain, []byte(secret)) {
+			t.Errorf("plaintext sidecar contains %q", secret)
+		}
+	}
+	index, err := readSidecar(root, fileKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index.Recording.Encryption == nil || index.Recording.Encryption.KeyID != enc.Info().KeyID || index.Recording.SHA256 != "abc" {
+		t.Errorf("got recording %+v", index.Recording)
+	}
+	if index.Summary == nil || len(index.Summary.QueueIDs) != 1 || !index.Summary.Start.Equal(start) {
+		t.Errorf("got summary %+v", index.Summary)
+	}
+	if index.Sealed != "r1.json"+crypt.Extension {
+		t.Errorf("got sealed %q", index.Sealed)
+	}
+
+	// El sidecar completo y el de la conversación sólo están cifrados
+	if _, err := os.Stat(filepath.Join(root, "250101-c1", ConversationSidecarName)); !os.IsNotExist(err) {
+		t.Error("conversation sidecar written in plaintext")
+	}
+	for _, name := range []string{"r1.json", ConversationSidecarName} {
+		file, err := os.Open(filepath.Join(root, "250101-c1", name+crypt.Extension))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, _, err := keyring.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var full RecordingSidecar
+		if err := json.Unmarshal(data, &full); err != nil {
+			t.Fatal(err)
+		}
+		if full.Summary == nil || len(full.Summary.ANI) != 1 || full.Summary.ANI[0] != ani {
+			t.Errorf("%s: got summary %+v", name, full.Summary)
+		}
+	}
+}
+
+// countingWrites cuenta las escrituras de cada clave y hace fallar las
+// primeras fail.
+type countingWrites struct {
+	storage.Backend
+	writes map[string]int
+	fail   int
+}
+
+func (b *countingWrites) WriteFile(key string, data []byte) error {
+	b.writes[key]++
+	if b.fail > 0 && path.Base(key) == ConversationSidecarName {
+		b.fail--
+		return errors.New("write failed")
+	}
+	return b.Backend.WriteFile(key, data)
+}
+
+func TestConversationSidecarWrittenOnce(t *testing.T) {
+	l, err := layout.New("", "", "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &countingWrites{Backend: storage.NewLocal(t.TempDir()), writes: make(map[string]int), fail: 1}
+	opts := DownloadOptions{Storage: backend, Paths: NewPaths(l, nil, nil, nil)}
+	conversationID := "c1"
+	conversationKey := opts.Paths.ConversationSidecar("250101-c1", conversationID)
+
+	// La primera escritura falla y la próxima grabación la reintenta; después
+	// ya no se reescribe
+	for i, recordingID := range []string{"r1", "r2", "r3"} {
+		item := sdk.Batchdownloadjobresult{ConversationId: &conversationID, RecordingId: &recordingID}
+		err := writeSidecars(opts, conversationKey, "250101-c1/"+recordingID+".mp3", item, nil, nil, &downloadResult{Size: 1})
+		if (err != nil) != (i == 0) {
+			t.Errorf("%s: got %v", recordingID, err)
+		}
+	}
+	if backend.writes[conversationKey] != 2 {
+		t.Errorf("conversation sidecar written %d times, want 2", backend.writes[conversationKey])
+	}
+	for _, recordingID := range []string{"r1", "r2", "r3"} {
+		if backend.writes["250101-c1/"+recordingID+".json"] != 1 {
+			t.Errorf("%s: sidecar written %d times", recordingID, backend.writes["250101-c1/"+recordingID+".json"])
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package functions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/goDownloadRecording/logger"
+	sdk "github.com/mypurecloud/platform-client-sdk-go/v157/platformclientv2"
+	"go.uber.org/zap"
+)
+
+// speechExtension es el sufijo de la transcripción de Speech & Text
+// Analytics de una grabación de audio: <archivo>.speech.json con el JSON tal
+// cual lo publica Genesys y <archivo>.speech.txt con la versión legible.
+const speechExtension = ".speech"
+
+// maxSpeechTranscript acota el tamaño de una transcripción descargada.
+const maxSpeechTranscript = 64 << 20
+
+// speechTranscript es la parte de la transcripción de Speech & Text
+// Analytics que se usa para la versión en texto.
+type speechTranscript struct {
+	ConversationID  string `json:"conversationId"`
+	CommunicationID string `json:"communicationId"`
+	StartTime       int64  `json:"startTime"` // ms desde epoch
+	Transcripts     []struct {
+		Language string         `json:"language"`
+		Phrases  []speechPhrase `json:"phrases"`
+	} `json:"transcripts"`
+}
+
+type speechPhrase struct {
+	Text               string `json:"text"`
+	StartTimeMs        int64  `json:"startTimeMs"`
+	ParticipantPurpose string `json:"participantPurpose"`
+	Offset             *struct {
+		Milliseconds int64 `json:"milliseconds"`
+	} `json:"offset"`
+}
+
+// speechSpeakers traduce participantPurpose a la etiqueta del hablante.
+var speechSpeakers = map[string]string{
+	"internal": "Agent",
+	"external": "Customer",
+	"acd":      "ACD",
+	"ivr":      "IVR",
+}
+
+// exportSpeechTranscript guarda, junto a la grabación fileKey, la
+// transcripción de Speech & Text Analytics de su comunicación. Es opcional
+// (opts.SpeechApi nil la desactiva) y un error no afecta a la grabación:
+// sólo se loguea.
+func exportSpeechTranscript(ctx context.Context, opts DownloadOptions, conversationID, recordingID, fileKey string, conversation *sdk.Analyticsconversationwithoutattributes, recordings []sdk.Recordingmetadata) {
+	if opts.SpeechApi == nil {
+		return
+	}
+	communications := speechCommunications(recordingID, conversation, recordings)
+	if len(communications) == 0 {
+		logger.Log.Debug("No communication for speech transcript", zap.String("RecordingID", recordingID))
+		return
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+	for _, communicationID := range communications {
+		data, err = fetchSpeechTranscript(ctx, opts.SpeechApi, conversationID, communicationID)
+		if err != nil || data != nil {
+			break
+		}
+	}
+	if err != nil {
+		if !isCanceled(ctx, err) {
+			logger.Log.Warn("Failed to fetch speech transcript", zap.String("RecordingID", recordingID), zap.Error(err))
+		}
+		return
+	}
+	if data == nil {
+		logger.Log.Debug("No speech transcript available", zap.String("RecordingID", recordingID))
+		return
+	}
+
+	base := recordingBase(fileKey) + speechExtension
+	text, err := renderSpeechTranscript(data)
+	if err != nil {
+		// El JSON se guarda igual: es el original
+		logger.Log.Warn("Failed to render speech transcript", zap.String("RecordingID", recordingID), zap.Error(err))
+	}
+	jsonKey, _, err := writeContent(opts, base+".json", data)
+	if err == nil && text != "" {
+		_, _, err = writeContent(opts, base+".txt", []byte(text))
+	}
+	if err != nil {
+		logger.Log.Warn("Failed to write speech transcript", zap.String("RecordingID", recordingID), zap.Error(err))
+		return
+	}
+	logger.Log.Info("Exported speech transcript", zap.String("File", jsonKey))
+}
+
+// speechCommunications devuelve las comunicaciones de la grabación que
+// pueden tener transcripción: la sesión de su metadata y, si no la tiene,
+// las sesiones de voz de la conversación.
+func speechCommunications(recordingID string, conversation *sdk.Analyticsconversationwithoutattributes, recordings []sdk.Recordingmetadata) []string {
+	for _, recording := range recordings {
+		if getString(recording.Id) == recordingID && getString(recording.SessionId) != "" {
+			return []string{*recording.SessionId}
+		}
+	}
+	if conversation == nil || conversation.Participants == nil {
+		return nil
+	}
+	var communications []string
+	seen := newSet()
+	for _, participant := range *conversation.Participants {
+		if participant.Sessions == nil {
+			continue
+		}
+		for _, session := range *participant.Sessions {
+			id := getString(session.SessionId)
+			if getString(session.MediaType) != "voice" || id == "" || seen[id] {
+				continue
+			}
+			seen.add(id)
+			communications = append(communications, id)
+		}
+	}
+	return communications
+}
+
+// fetchSpeechTranscript pide la URL de la transcripción de una comunicación
+// y la descarga. Devuelve nil, nil si la comunicación no tiene transcripción.
+func fetchSpeechTranscript(ctx context.Context, api *sdk.SpeechTextAnalyticsApi, conversationID, communicationID string) ([]byte, error) {
+	transcript, response, err := api.GetSpeechandtextanalyticsConversationCommunicationTranscripturl(conversationID, communicationID)
+	if response != nil && response.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	url := getString(transcript.Url)
+	if url == "" {
+		return nil, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download speech transcript: status code %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxSpeechTranscript))
+}
+
+// renderSpeechTranscript arma la versión en texto de una transcripción: una
+// línea por frase con el momento (desde el inicio de la comunicación) y el
+// hablante.
+func renderSpeechTranscript(data []byte) (string, error) {
+	var transcript speechTranscript
+	if err := json.Unmarshal(data, &transcript); err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	title := fmt.Sprintf("Speech transcript %s", transcript.ConversationID)
+	fmt.Fprintf(&b, "%s\n%s\n", title, strings.Repeat("=", len(title)))
+	if transcript.CommunicationID != "" {
+		fmt.Fprintf(&b, "Communication: %s\n", transcript.CommunicationID)
+	}
+	if transcript.StartTime > 0 {
+		fmt.Fprintf(&b, "Start: %s\n", time.UnixMilli(transcript.StartTime).UTC().Format(time.RFC3339))
+	}
+	for _, t := range transcript.Transcripts {
+		if t.Language != "" {
+			fmt.Fprintf(&b, "Language: %s\n", t.Language)
+		}
+		b.WriteString("\n")
+		for _, phrase := range t.Phrases {
+			fmt.Fprintf(&b, "[%s] %s: %s\n", phraseOffset(phrase, transcript.StartTime), speaker(phrase.ParticipantPurpose), strings.TrimSpace(phrase.Text))
+		}
+	}
+	return b.String(), nil
+}
+
+// phraseOffset devuelve el momento de la frase como hh:mm:ss desde el
+// inicio de la comunicación.
+func phraseOffset(phrase speechPhrase, start int64) string {
+	var ms int64
+	switch {
+	case phrase.Offset != nil:
+		ms = phrase.Offset.Milliseconds
+	case phrase.StartTimeMs > 0 && start > 0:
+		ms = phrase.StartTimeMs - start
+	}
+	d := time.Duration(max(ms, 0)) * time.Millisecond
+	return fmt.Sprintf("%02d:%02d:%02d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60)
+}
+
+func speaker(purpose string) string {
+	if label, ok := speechSpeakers[purpose]; ok {
+		return label
+	}
+	return firstNonEmpty(purpose, "unknown")
+}
@@ -0,0 +1,325 @@
+package functions
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goDownloadRecording/journal"
+	"github.com/goDownloadRecording/logger"
+	sdk "github.com/mypurecloud/platform-client-sdk-go/v157/platformclientv2"
+	"go.uber.org/zap"
+)
+
+// transcriptExtension es la extensión del JSON de una interacción de texto
+// (la grabación tal cual la devuelve Genesys). Junto a él se escriben la
+// transcripción legible en .html y .txt, y el sidecar en .json.
+const transcriptExtension = ".transcript.json"
+
+// textMedia son los valores de Recordingmetadata.Media de las interacciones
+// de texto. Sus grabaciones no se piden en batch: se exportan como
+// transcripción (ver ExportTranscripts).
+var textMedia = map[string]bool{"chat": true, "email": true, "message": true}
+
+// IsTextRecording indica si la grabación de request es de una interacción
+// de texto (chat, email o mensajería), según la metadata guardada en
+// details (si no la tiene, la pide a la API).
+func IsTextRecording(request sdk.Batchdownloadrequest, details *Details) bool {
+	return textMedia[details.Media(getString(request.ConversationId), getString(request.RecordingId))]
+}
+
+// SplitTextRecordings separa las solicitudes de interacciones de texto de
+// las que van en batch. media es el tipo de media por recordingId que ya se
+// conoce (p.ej. el registrado en el journal); el resto se busca en details.
+func SplitTextRecordings(requests []sdk.Batchdownloadrequest, media map[string]string, details *Details) (batch, text []sdk.Batchdownloadrequest) {
+	for _, request := range requests {
+		kind, ok := media[getString(request.RecordingId)]
+		if ok && textMedia[kind] || !ok && IsTextRecording(request, details) {
+			text = append(text, request)
+		} else {
+			batch = append(batch, request)
+		}
+	}
+	return batch, text
+}
+
+// RouteTextRecordings reparte las solicitudes que llegan por requests: las
+// de interacciones de texto a text y el resto a batch. Vuelve cuando se
+// cierra requests o se cancela ctx; no cierra los canales de salida.
+func RouteTextRecordings(ctx context.Context, requests <-chan sdk.Batchdownloadrequest, details *Details, batch, text chan<- sdk.Batchdownloadrequest) {
+	for request := range requests {
+		out := batch
+		if IsTextRecording(request, details) {
+			out = text
+		}
+		select {
+		case out <- request:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// ExportTranscripts exporta con opts.MaxWorkers workers las interacciones de
+// texto que llegan por requests: pide cada grabación a Genesys y guarda el
+// JSON con la transcripción, una versión HTML y una de texto, con los mismos
+// sidecars, hash, journal e índice que una grabación de audio. skip, si no
+// es nil, descarta las que no hace falta exportar. Vuelve cuando se cierra
+// requests (o se cancela ctx) y terminaron los workers.
+func ExportTranscripts(ctx context.Context, requests <-chan sdk.Batchdownloadrequest, skip func(sdk.Batchdownloadrequest) bool, opts DownloadOptions) {
+	var (
+		wg       sync.WaitGroup
+		exported atomic.Int64
+	)
+	for i := 0; i < max(opts.MaxWorkers, 1); i++ {
+		wg.Add(1)
+		go func(workerID int) {
+			defer wg.Done()
+			for request := range requests {
+				if ctx.Err() != nil || request.ConversationId == nil || request.RecordingId == nil {
+					continue
+				}
+				if skip != nil && skip(request) {
+					continue
+				}
+				if exportTranscript(ctx, request, opts, workerID) {
+					exported.Add(1)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if exported.Load() > 0 {
+		logger.Log.Info("Transcripts exported", zap.Int64("Transcripts", exported.Load()))
+	}
+}
+
+// exportTranscript exporta una interacción de texto. Devuelve true si quedó guardada.
+func exportTranscript(ctx context.Context, request sdk.Batchdownloadrequest, opts DownloadOptions, workerID int) bool {
+	j := opts.Journal
+	conversationID, recordingID := *request.ConversationId, *request.RecordingId
+	defer opts.Details.Done(conversationID)
+
+	recording, err := getRecording(ctx, opts.RecordingApi, conversationID, recordingID, "", false, opts.FormatRetries)
+	if err != nil {
+		if !isCanceled(ctx, err) {
+			logger.Log.Error("Failed to fetch transcript", zap.String("RecordingID", recordingID), zap.Error(err))
+			recordJournal(j, journal.Entry{Stage: journal.StageFailed, ConversationID: conversationID, RecordingID: recordingID, Error: err.Error()})
+		}
+		return false
+	}
+	data, err := json.MarshalIndent(recording, "", "  ")
+	if err != nil {
+		logger.Log.Error("Failed to encode transcript", zap.String("RecordingID", recordingID), zap.Error(err))
+		return false
+	}
+	data = append(data, '\n')
+
+	conversation, recordings := opts.Details.Get(conversationID)
+	folderKey, fileKey := opts.Paths.Keys(conversation, conversationID, recordingID, transcriptExtension+encryptedExtension(opts))
+	opts.Storage = scopeStorage(opts.Storage, conversation, conversationID)
+	base := recordingBase(fileKey)
+
+	// Las versiones legibles primero: el JSON, que es lo que se verifica e
+	// indexa, se publica al final. Con cifrado se guardan los tres cifrados.
+	text, page := renderTranscript(recording)
+	_, _, err = writeContent(opts, base+".txt", []byte(text))
+	if err == nil {
+		_, _, err = writeContent(opts, base+".html", []byte(page))
+	}
+	if err == nil {
+		_, data, err = writeContent(opts, plainName(fileKey), data)
+	}
+	if err != nil {
+		logger.Log.Error("Failed to write transcript", zap.String("RecordingID", recordingID), zap.Error(err))
+		recordJournal(j, journal.Entry{Stage: journal.StageFailed, ConversationID: conversationID, RecordingID: recordingID, Error: err.Error()})
+		return false
+	}
+
+	sum := sha256.Sum256(data)
+	download := &downloadResult{
+		Size:        int64(len(data)),
+		SHA256:      hex.EncodeToString(sum[:]),
+		ContentType: "application/json",
+		Format:      "TRANSCRIPT",
+		Encryption:  opts.Encryption.Info(),
+	}
+	recordJournal(j, journal.Entry{Stage: journal.StageDownloaded, ConversationID: conversationID, RecordingID: recordingID, Path: fileKey, SHA256: download.SHA256})
+	logger.Log.Info("Exported transcript",
+		zap.String("Storage", opts.Storage.Name()),
+		zap.String("File", fileKey),
+		zap.String("Media", getString(recording.Media)),
+		zap.Int("Worker", workerID),
+	)
+
+	item := sdk.Batchdownloadjobresult{ConversationId: request.ConversationId, RecordingId: request.RecordingId, ContentType: &download.ContentType}
+	storeRecording(opts, item, folderKey, fileKey, conversation, recordings, download)
+	return true
+}
+
+// transcriptLine es un mensaje de la transcripción, sea de chat, email o
+// mensajería.
+type transcriptLine struct {
+	Time    string
+	From    string
+	Header  []string // datos extra (asunto, destinatarios, adjuntos)
+	Message string
+}
+
+// renderTranscript arma la transcripción legible de una interacción de
+// texto, en texto plano y en HTML.
+func renderTranscript(recording *sdk.Recording) (text, page string) {
+	lines := transcriptLines(recording)
+	title := fmt.Sprintf("%s %s", getString(recording.Media), getString(recording.ConversationId))
+
+	var t strings.Builder
+	fmt.Fprintf(&t, "%s\n%s\n\n", title, strings.Repeat("=", len(title)))
+	for _, line := range lines {
+		fmt.Fprintf(&t, "[%s] %s\n", line.Time, line.From)
+		for _, header := range line.Header {
+			fmt.Fprintf(&t, "  %s\n", header)
+		}
+		fmt.Fprintf(&t, "%s\n\n", line.Message)
+	}
+
+	var h strings.Builder
+	fmt.Fprintf(&h, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n", html.EscapeString(title))
+	h.WriteString("<style>body{font-family:sans-serif;max-width:50em;margin:auto}.msg{margin:1em 0}.meta{color:#666;font-size:.9em}pre{white-space:pre-wrap;font-family:inherit;margin:.3em 0}</style>\n</head>\n<body>\n")
+	fmt.Fprintf(&h, "<h1>%s</h1>\n", html.EscapeString(title))
+	for _, line := range lines {
+		fmt.Fprintf(&h, "<div class=\"msg\">\n<div class=\"meta\">%s &mdash; <b>%s</b></div>\n", html.EscapeString(line.Time), html.EscapeString(line.From))
+		for _, header := range line.Header {
+			fmt.Fprintf(&h, "<div class=\"meta\">%s</div>\n", html.EscapeString(header))
+		}
+		fmt.Fprintf(&h, "<pre>%s</pre>\n</div>\n", html.EscapeString(line.Message))
+	}
+	h.WriteString("</body>\n</html>\n")
+	return t.String(), h.String()
+}
+
+// transcriptLines extrae los mensajes de la grabación, según su tipo.
+func transcriptLines(recording *sdk.Recording) []transcriptLine {
+	var lines []transcriptLine
+	if recording.Transcript != nil {
+		for _, message := range *recording.Transcript {
+			from := getString(message.ParticipantPurpose)
+			if message.User != nil {
+				from = firstNonEmpty(getString(message.User.DisplayName), getString(message.User.Name), from)
+			}
+			lines = append(lines, transcriptLine{
+				Time:    chatTime(getString(message.Utc)),
+				From:    firstNonEmpty(from, getString(message.From), "unknown"),
+				Message: getString(message.Body),
+			})
+		}
+	}
+	if recording.EmailTranscript != nil {
+		for _, email := range *recording.EmailTranscript {
+			line := transcriptLine{From: emailAddress(email.From), Message: getString(email.TextBody)}
+			if email.Time != nil {
+				line.Time = email.Time.UTC().Format(time.RFC3339)
+			}
+			if line.Message == "" {
+				line.Message = stripTags(getString(email.HtmlBody))
+			}
+			line.Header = append(line.Header, "Subject: "+getString(email.Subject))
+			if to := emailAddresses(email.To); to != "" {
+				line.Header = append(line.Header, "To: "+to)
+			}
+			if cc := emailAddresses(email.Cc); cc != "" {
+				line.Header = append(line.Header, "Cc: "+cc)
+			}
+			if email.Attachments != nil {
+				for _, attachment := range *email.Attachments {
+					line.Header = append(line.Header, "Attachment: "+getString(attachment.Name))
+				}
+			}
+			lines = append(lines, line)
+		}
+	}
+	if recording.MessagingTranscript != nil {
+		for _, message := range *recording.MessagingTranscript {
+			from := getString(message.Purpose)
+			if message.FromUser != nil {
+				from = firstNonEmpty(getString(message.FromUser.Name), from)
+			} else if contact := message.FromExternalContact; contact != nil {
+				from = firstNonEmpty(strings.TrimSpace(getString(contact.FirstName)+" "+getString(contact.LastName)), from)
+			}
+			line := transcriptLine{
+				From:    firstNonEmpty(from, getString(message.From), "unknown"),
+				Message: getString(message.MessageText),
+			}
+			if message.Timestamp != nil {
+				line.Time = message.Timestamp.UTC().Format(time.RFC3339)
+			}
+			if message.MessageMediaAttachments != nil {
+				for _, attachment := range *message.MessageMediaAttachments {
+					line.Header = append(line.Header, "Attachment: "+firstNonEmpty(getString(attachment.Name), getString(attachment.MediaType)))
+				}
+			}
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// chatTime pasa a RFC 3339 la hora de un mensaje de chat, que Genesys
+// informa en milisegundos desde epoch.
+func chatTime(utc string) string {
+	ms, err := strconv.ParseInt(utc, 10, 64)
+	if err != nil {
+		return utc
+	}
+	return time.UnixMilli(ms).UTC().Format(time.RFC3339)
+}
+
+func emailAddress(address *sdk.Emailaddress) string {
+	if address == nil {
+		return "unknown"
+	}
+	if name := getString(address.Name); name != "" {
+		return fmt.Sprintf("%s <%s>", name, getString(address.Email))
+	}
+	return firstNonEmpty(getString(address.Email), "unknown")
+}
+
+func emailAddresses(addresses *[]sdk.Emailaddress) string {
+	if addresses == nil {
+		return ""
+	}
+	var list []string
+	for i := range *addresses {
+		list = append(list, emailAddress(&(*addresses)[i]))
+	}
+	return strings.Join(list, ", ")
+}
+
+var (
+	htmlTags   = regexp.MustCompile(`(?s)<(script|style).*?</(script|style)>|<[^>]*>`)
+	blankLines = regexp.MustCompile(`\n{3,}`)
+)
+
+// stripTags deja sólo el texto de un cuerpo HTML, para emails sin versión
+// de texto.
+func stripTags(body string) string {
+	text := html.UnescapeString(htmlTags.ReplaceAllString(body, "\n"))
+	return strings.TrimSpace(blankLines.ReplaceAllString(text, "\n\n"))
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
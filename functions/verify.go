@@ -0,0 +1,177 @@
+package functions
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/goDownloadRecording/archive"
+	"github.com/goDownloadRecording/storage"
+)
+
+// VerifyProblem describe un archivo o carpeta que no pasó la verificación.
+type VerifyProblem struct {
+	Path   string
+	Reason string
+}
+
+// VerifyReport resume el resultado de VerifyDownloads.
+type VerifyReport struct {
+	Folders    int
+	Recordings int
+	Problems   []VerifyProblem
+}
+
+// VerifyDownloads recorre outputDir (con sus subcarpetas, según las
+// plantillas de carpeta) y comprueba que cada carpeta de conversación tenga
+// metadata y grabaciones no vacías. Una carpeta es de conversación si tiene
+// grabaciones o un sidecar de conversación.
+func VerifyDownloads(outputDir string) (*VerifyReport, error) {
+	report := &VerifyReport{}
+	err := filepath.WalkDir(outputDir, func(folderPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		files, err := os.ReadDir(folderPath)
+		if err != nil {
+			return err
+		}
+
+		hasMetadata, recordings := false, 0
+		for _, file := range files {
+			if file.IsDir() {
+				continue
+			}
+			if isConversationSidecar(file.Name()) {
+				hasMetadata = true
+				continue
+			}
+			if !isRecordingFile(file.Name()) {
+				continue
+			}
+			recordings++
+			info, err := file.Info()
+			if err != nil {
+				return err
+			}
+			if info.Size() == 0 {
+				report.Problems = append(report.Problems, VerifyProblem{Path: filepath.Join(folderPath, file.Name()), Reason: "empty recording"})
+			}
+		}
+		if !hasMetadata && recordings == 0 {
+			return nil
+		}
+
+		report.Folders++
+		report.Recordings += recordings
+		if !hasMetadata {
+			report.Problems = append(report.Problems, VerifyProblem{Path: folderPath, Reason: "missing " + ConversationSidecarName})
+		}
+		if recordings == 0 {
+			report.Problems = append(report.Problems, VerifyProblem{Path: folderPath, Reason: "no recordings"})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// isConversationSidecar indica si name es el sidecar de una conversación
+// (conversation.json o <conversationId>.conversation.json, cifrado o no).
+// metadata.txt es el formato anterior a los sidecars JSON.
+func isConversationSidecar(name string) bool {
+	name = plainName(name)
+	return name == ConversationSidecarName || strings.HasSuffix(name, "."+ConversationSidecarName) || name == "metadata.txt"
+}
+
+// VerifyObject comprueba que una grabación exista en el backend, no esté
+// vacía y, si se conocen, coincida con el tamaño y el SHA-256 esperados.
+func VerifyObject(backend storage.Backend, key string, size int64, sha string) error {
+	actualSize, exists, err := backend.Stat(key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("recording %s not found in %s storage", key, backend.Name())
+	}
+	if actualSize == 0 {
+		return fmt.Errorf("empty recording %s", key)
+	}
+	if size > 0 && actualSize != size {
+		return fmt.Errorf("size %d does not match expected %d", actualSize, size)
+	}
+	if sha == "" {
+		return nil
+	}
+	reader, err := backend.Open(key)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	actual, err := readerSHA256(reader)
+	if err != nil {
+		return err
+	}
+	if actual != sha {
+		return fmt.Errorf("SHA-256 %s does not match expected %s", actual, sha)
+	}
+	return nil
+}
+
+// FileSHA256 calcula el SHA-256 (hex) de un archivo.
+func FileSHA256(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return readerSHA256(file)
+}
+
+func readerSHA256(reader io.Reader) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// VerifyIndex comprueba que cada grabación del índice siga en el backend
+// con el tamaño registrado y, si checkHash es true, que su SHA-256 coincida.
+func VerifyIndex(backend storage.Backend, index *archive.Index, checkHash bool) []VerifyProblem {
+	var problems []VerifyProblem
+	for _, entry := range index.Entries() {
+		if entry.PurgedAt != nil {
+			continue
+		}
+		if entry.Bundle != "" {
+			// El contenido del paquete se verificó contra su manifiesto al escribirlo
+			if _, err := os.Stat(entry.Bundle); err != nil {
+				problems = append(problems, VerifyProblem{Path: entry.Bundle, Reason: "missing package"})
+			}
+			continue
+		}
+		sha := entry.SHA256
+		if !checkHash {
+			sha = ""
+		}
+		rec := storage.Recording{ConversationID: entry.ConversationID}
+		if entry.ConversationStart != nil {
+			rec.Start = *entry.ConversationStart
+		}
+		if err := VerifyObject(storage.Scope(backend, rec), entry.Path, entry.Size, sha); err != nil {
+			problems = append(problems, VerifyProblem{Path: entry.Path, Reason: err.Error()})
+		}
+	}
+	return problems
+}
@@ -0,0 +1,318 @@
+package governor
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goDownloadRecording/logger"
+	"github.com/hashicorp/go-retryablehttp"
+	sdk "github.com/mypurecloud/platform-client-sdk-go/v157/platformclientv2"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// Options configura el governor.
+type Options struct {
+	// RequestsPerSecond y Burst definen el token bucket global.
+	RequestsPerSecond float64
+	Burst             int
+	// MaxRetries acota los reintentos de cada request ante 429, 5xx o
+	// errores de red.
+	MaxRetries int
+	// MaxWait limita la espera de un reintento, aunque Retry-After pida más.
+	MaxWait time.Duration
+	// Tokens, si no es nil, provee el token de cada request (ver TokenSource).
+	Tokens TokenSource
+	// Context, si no es nil, corta las esperas y evita enviar requests nuevos
+	// una vez cancelado. Los requests ya enviados terminan normalmente.
+	Context context.Context
+}
+
+// TokenSource entrega el token OAuth vigente. El governor lo pone en cada
+// request en lugar del que copió el SDK, y ante un 401 lo invalida y
+// reintenta una vez con uno nuevo.
+type TokenSource interface {
+	Token() (string, error)
+	Invalidate(token string)
+}
+
+// Stats cuenta lo que hizo el governor desde que se creó.
+type Stats struct {
+	Requests    int64         // requests enviados (incluye reintentos)
+	Throttled   int64         // respuestas 429
+	Unavailable int64         // respuestas 503
+	Retries     int64         // reintentos hechos
+	Waited      time.Duration // tiempo en pausa por throttling, sumado entre llamadores
+}
+
+// baseBackoff es la espera inicial cuando la respuesta no trae Retry-After;
+// se duplica en cada reintento hasta MaxWait.
+const baseBackoff = time.Second
+
+// Governor es el cliente HTTP que usa el SDK para todas las llamadas a
+// Genesys. Aplica un token bucket global y, ante 429/503, respeta
+// Retry-After pausando a todos los llamadores, no sólo al que recibió la
+// respuesta. Implementa sdk.AbstractHttpClient.
+type Governor struct {
+	opts    Options
+	limiter *rate.Limiter
+	client  *http.Client
+
+	mu         sync.Mutex
+	pauseUntil time.Time
+
+	requests, throttled, unavailable, retries, waited atomic.Int64
+}
+
+// Default es el governor instalado con Init.
+var Default *Governor
+
+// New crea un governor con opts.
+func New(opts Options) *Governor {
+	limit := rate.Inf
+	if opts.RequestsPerSecond > 0 {
+		limit = rate.Limit(opts.RequestsPerSecond)
+	}
+	if opts.Burst < 1 {
+		opts.Burst = 1
+	}
+	if opts.MaxWait <= 0 {
+		opts.MaxWait = 2 * time.Minute
+	}
+	if opts.Context == nil {
+		opts.Context = context.Background()
+	}
+	return &Governor{
+		opts:    opts,
+		limiter: rate.NewLimiter(limit, opts.Burst),
+		client:  &http.Client{},
+	}
+}
+
+// Init crea el governor por defecto y lo instala como cliente HTTP de la
+// configuración por defecto del SDK, de modo que todas las APIs creadas con
+// sdk.New*Api() pasen por él.
+func Init(opts Options) error {
+	Default = New(opts)
+	return sdk.GetDefaultConfiguration().APIClient.SetHttpClient(Default)
+}
+
+// Do envía el request esperando su turno en el token bucket y lo reintenta
+// ante 429/503 (según Retry-After), otros 5xx o errores de red. Si se agotan
+// los reintentos devuelve la última respuesta para que el SDK informe el error.
+// Con el contexto cancelado devuelve su error sin enviar el request.
+func (g *Governor) Do(options *sdk.HTTPRequestOptions) (*http.Response, error) {
+	backoff := baseBackoff
+	refreshed := false
+	for attempt := 0; ; attempt++ {
+		if err := g.waitTurn(); err != nil {
+			return nil, err
+		}
+
+		req, err := options.ToRetryableRequest()
+		if err != nil {
+			return nil, err
+		}
+		body, err := req.BodyBytes()
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			req.Request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		token, err := g.setToken(req.Request)
+		if err != nil {
+			return nil, err
+		}
+
+		g.requests.Add(1)
+		resp, err := g.httpClient().Do(req.Request)
+
+		if err == nil && resp.StatusCode == http.StatusUnauthorized && token != "" && !refreshed {
+			// Token vencido o revocado: se pide otro y se repite una vez
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			g.opts.Tokens.Invalidate(token)
+			refreshed = true
+			attempt--
+			continue
+		}
+
+		var wait time.Duration
+		switch {
+		case err != nil:
+			wait = backoff
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable:
+			if resp.StatusCode == http.StatusTooManyRequests {
+				g.throttled.Add(1)
+			} else {
+				g.unavailable.Add(1)
+			}
+			wait = retryAfter(resp.Header, backoff)
+		case resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented:
+			wait = backoff
+		default:
+			return resp, nil
+		}
+
+		if attempt >= g.opts.MaxRetries {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		wait = min(wait, g.opts.MaxWait)
+		backoff = min(backoff*2, g.opts.MaxWait)
+
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		logger.Log.Warn("Genesys API request throttled or failed, retrying",
+			zap.String("URL", req.URL.Path),
+			zap.Int("Status", status),
+			zap.Int("Attempt", attempt+1),
+			zap.Duration("Wait", wait),
+			zap.Error(err))
+
+		g.retries.Add(1)
+		if status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable {
+			// El servidor pidió bajar el ritmo: se pausa a todos
+			g.pause(wait)
+		} else if err := g.sleep(wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// setToken pone el token vigente en req, salvo en el login (Basic auth).
+// Devuelve el token usado, o "" si no se tocó el request.
+func (g *Governor) setToken(req *http.Request) (string, error) {
+	if g.opts.Tokens == nil || !strings.HasPrefix(req.Header.Get("Authorization"), "Bearer ") {
+		return "", nil
+	}
+	token, err := g.opts.Tokens.Token()
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return token, nil
+}
+
+// waitTurn espera a que termine una pausa global y a que haya un token.
+func (g *Governor) waitTurn() error {
+	if err := g.opts.Context.Err(); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	wait := time.Until(g.pauseUntil)
+	g.mu.Unlock()
+	if wait > 0 {
+		g.waited.Add(int64(wait))
+		if err := g.sleep(wait); err != nil {
+			return err
+		}
+	}
+	return g.limiter.Wait(g.opts.Context)
+}
+
+// sleep espera d o hasta que se cancele el contexto.
+func (g *Governor) sleep(d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-g.opts.Context.Done():
+		return g.opts.Context.Err()
+	}
+}
+
+// pause extiende la pausa global por d.
+func (g *Governor) pause(d time.Duration) {
+	g.mu.Lock()
+	if until := time.Now().Add(d); until.After(g.pauseUntil) {
+		g.pauseUntil = until
+	}
+	g.mu.Unlock()
+}
+
+// Stats devuelve los contadores acumulados.
+func (g *Governor) Stats() Stats {
+	if g == nil {
+		return Stats{}
+	}
+	return Stats{
+		Requests:    g.requests.Load(),
+		Throttled:   g.throttled.Load(),
+		Unavailable: g.unavailable.Load(),
+		Retries:     g.retries.Load(),
+		Waited:      time.Duration(g.waited.Load()),
+	}
+}
+
+// LogStats deja en el log cuántas veces se frenaron los requests.
+func (g *Governor) LogStats() {
+	if g == nil {
+		return
+	}
+	stats := g.Stats()
+	logger.Log.Info("Genesys API usage",
+		zap.Int64("Requests", stats.Requests),
+		zap.Int64("Throttled429", stats.Throttled),
+		zap.Int64("Unavailable503", stats.Unavailable),
+		zap.Int64("Retries", stats.Retries),
+		zap.Duration("PausedFor", stats.Waited))
+}
+
+// retryAfter interpreta el header Retry-After (segundos o fecha HTTP). Si
+// falta o no se entiende se usa fallback.
+func retryAfter(header http.Header, fallback time.Duration) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return fallback
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := time.Until(date); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return fallback
+}
+
+// El resto de sdk.AbstractHttpClient: los reintentos del SDK quedan
+// desactivados porque los maneja el governor.
+
+func (g *Governor) SetRetryMax(int)                                                  {}
+func (g *Governor) SetRetryWaitMax(time.Duration)                                    {}
+func (g *Governor) SetRetryWaitMin(time.Duration)                                    {}
+func (g *Governor) SetRequestLogHook(func(retryablehttp.Logger, *http.Request, int)) {}
+func (g *Governor) SetResponseLogHook(func(retryablehttp.Logger, *http.Response))    {}
+func (g *Governor) SetCheckRetry(func(context.Context, *http.Response, error) (bool, error)) {
+}
+func (g *Governor) SetHttpsAgent(*sdk.ProxyAgent) {}
+
+// SetTransport lo usa el SDK cuando hay proxy o mTLS configurado.
+func (g *Governor) SetTransport(transport *http.Transport) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.client = &http.Client{Transport: transport}
+}
+
+func (g *Governor) httpClient() *http.Client {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.client
+}
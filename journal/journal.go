@@ -0,0 +1,318 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Stage es la etapa alcanzada por una conversación o grabación.
+type Stage string
+
+const (
+	StageRun           Stage = "run"            // inicio de corrida, con la query
+	StageQueried       Stage = "queried"        // conversación devuelta por la query
+	StageQueryComplete Stage = "query_complete" // la query terminó de paginar
+	StageMetadata      Stage = "metadata"       // metadata obtenida (una entrada por grabación)
+	StageSubmitted     Stage = "submitted"      // grabación incluida en un batch job
+	StageURL           Stage = "url"            // el batch job devolvió la URL de descarga
+	StageDownloaded    Stage = "downloaded"     // archivo escrito en disco
+	StageVerified      Stage = "verified"       // archivo verificado
+	StageFailed        Stage = "failed"         // error en alguna etapa (ver Error)
+	StageDone          Stage = "done"           // corrida terminada
+	StageInterrupted   Stage = "interrupted"    // corrida cortada por una señal (ver Error)
+)
+
+// stageOrder permite comparar etapas de una grabación.
+var stageOrder = map[Stage]int{
+	StageMetadata:   1,
+	StageSubmitted:  2,
+	StageURL:        3,
+	StageDownloaded: 4,
+	StageVerified:   5,
+}
+
+// Entry es una línea del journal.
+type Entry struct {
+	Time           time.Time `json:"time"`
+	Stage          Stage     `json:"stage"`
+	ConversationID string    `json:"conversationId,omitempty"`
+	RecordingID    string    `json:"recordingId,omitempty"`
+	// ConversationStart es el inicio de la conversación (en queried)
+	ConversationStart *time.Time      `json:"conversationStart,omitempty"`
+	JobID             string          `json:"jobId,omitempty"`
+	URL               string          `json:"url,omitempty"`
+	ContentType       string          `json:"contentType,omitempty"`
+	Media             string          `json:"media,omitempty"` // tipo de media de la grabación (en metadata)
+	Path              string          `json:"path,omitempty"`
+	SHA256            string          `json:"sha256,omitempty"`
+	Error             string          `json:"error,omitempty"`
+	Query             json.RawMessage `json:"query,omitempty"`
+}
+
+// Journal es un registro append-only en disco (una entrada JSON por línea)
+// de lo que hizo cada etapa. Todos los métodos aceptan un *Journal nil, de
+// modo que el resto del código no necesita preguntar si hay journal.
+type Journal struct {
+	mu   sync.Mutex
+	file *os.File
+	path string
+}
+
+// Create abre un journal nuevo en path, descartando el anterior.
+func Create(path string) (*Journal, error) {
+	return open(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY)
+}
+
+// Append abre un journal existente para seguir agregando entradas.
+func Append(path string) (*Journal, error) {
+	return open(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY)
+}
+
+func open(path string, flags int) (*Journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{file: file, path: path}, nil
+}
+
+// Path devuelve la ruta del archivo del journal.
+func (j *Journal) Path() string {
+	if j == nil {
+		return ""
+	}
+	return j.path
+}
+
+// Record agrega una entrada y la sincroniza a disco antes de volver, para
+// que sobreviva a una caída del proceso.
+func (j *Journal) Record(entry Entry) error {
+	if j == nil {
+		return nil
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+// Close cierra el archivo del journal.
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	return j.file.Close()
+}
+
+// RecordingState es el estado reconstruido de una grabación.
+type RecordingState struct {
+	ConversationID string
+	RecordingID    string
+	Stage          Stage
+	JobID          string
+	SubmittedAt    time.Time
+	URL            string
+	ContentType    string
+	Path           string
+	SHA256         string
+	LastError      string
+	// Media es el tipo de media (audio, chat, email, ...), si se registró
+	Media string
+}
+
+// State es el resultado de reproducir un journal.
+type State struct {
+	Query         json.RawMessage
+	QueryComplete bool
+	Done          bool
+	// Interrupted indica que la última corrida se cortó antes de terminar.
+	Interrupted bool
+	// Conversations indica, por conversationId, si ya se obtuvo su metadata.
+	Conversations map[string]bool
+	// ConversationStarts es el inicio de cada conversación consultada, si
+	// el journal lo registró.
+	ConversationStarts map[string]time.Time
+	Recordings         map[string]*RecordingState
+}
+
+// Load reproduce el journal en path y devuelve el estado de cada elemento.
+// Una última línea truncada (caída a mitad de escritura) se ignora.
+func Load(path string) (*State, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	state := &State{
+		Conversations:      make(map[string]bool),
+		ConversationStarts: make(map[string]time.Time),
+		Recordings:         make(map[string]*RecordingState),
+	}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		state.apply(entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading journal %s: %w", path, err)
+	}
+	return state, nil
+}
+
+func (s *State) apply(entry Entry) {
+	switch entry.Stage {
+	case StageRun:
+		s.Query = entry.Query
+		s.Interrupted = false
+	case StageQueried:
+		if _, ok := s.Conversations[entry.ConversationID]; !ok {
+			s.Conversations[entry.ConversationID] = false
+		}
+		if entry.ConversationStart != nil {
+			s.ConversationStarts[entry.ConversationID] = *entry.ConversationStart
+		}
+	case StageQueryComplete:
+		s.QueryComplete = true
+	case StageDone:
+		s.Done = true
+		s.Interrupted = false
+	case StageInterrupted:
+		s.Interrupted = true
+	case StageFailed:
+		if rec, ok := s.Recordings[entry.RecordingID]; ok {
+			rec.LastError = entry.Error
+		}
+	default:
+		if entry.Stage == StageMetadata {
+			s.Conversations[entry.ConversationID] = true
+			if entry.RecordingID == "" {
+				// Conversación sin grabaciones
+				return
+			}
+		}
+		rec, ok := s.Recordings[entry.RecordingID]
+		if !ok {
+			rec = &RecordingState{ConversationID: entry.ConversationID, RecordingID: entry.RecordingID}
+			s.Recordings[entry.RecordingID] = rec
+		}
+		// Un reenvío (submitted tras url) vuelve a la etapa anterior
+		if stageOrder[entry.Stage] >= stageOrder[rec.Stage] || entry.Stage == StageSubmitted {
+			rec.Stage = entry.Stage
+		}
+		if entry.Stage == StageSubmitted {
+			rec.JobID = entry.JobID
+			rec.SubmittedAt = entry.Time
+			rec.URL = ""
+		}
+		if entry.URL != "" {
+			rec.URL = entry.URL
+		}
+		if entry.ContentType != "" {
+			rec.ContentType = entry.ContentType
+		}
+		if entry.Media != "" {
+			rec.Media = entry.Media
+		}
+		if entry.Path != "" {
+			rec.Path = entry.Path
+		}
+		if entry.SHA256 != "" {
+			rec.SHA256 = entry.SHA256
+		}
+	}
+}
+
+// PendingMetadata devuelve las conversaciones consultadas sin metadata.
+func (s *State) PendingMetadata() []string {
+	var ids []string
+	for id, fetched := range s.Conversations {
+		if !fetched {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// HasPending indica si quedó trabajo sin terminar en la corrida.
+func (s *State) HasPending() bool {
+	if !s.QueryComplete || len(s.PendingMetadata()) > 0 {
+		return true
+	}
+	for _, rec := range s.Recordings {
+		if rec.Stage != StageVerified {
+			return true
+		}
+	}
+	return false
+}
+
+// InStage devuelve las grabaciones cuya última etapa es alguna de stages.
+func (s *State) InStage(stages ...Stage) []*RecordingState {
+	var recs []*RecordingState
+	for _, rec := range s.Recordings {
+		for _, stage := range stages {
+			if rec.Stage == stage {
+				recs = append(recs, rec)
+				break
+			}
+		}
+	}
+	return recs
+}
+
+// Summary cuenta cuántos elementos de la corrida llegaron a cada etapa.
+type Summary struct {
+	Conversations   int           `json:"conversations"`
+	PendingMetadata int           `json:"pendingMetadata"`
+	Recordings      int           `json:"recordings"`
+	Stages          map[Stage]int `json:"stages"`
+	Failed          int           `json:"failed"`
+	QueryComplete   bool          `json:"queryComplete"`
+	Done            bool          `json:"done"`
+	Interrupted     bool          `json:"interrupted"`
+}
+
+// Summary resume el estado. Failed cuenta las grabaciones con error que no
+// llegaron a verificarse.
+func (s *State) Summary() Summary {
+	summary := Summary{
+		Conversations:   len(s.Conversations),
+		PendingMetadata: len(s.PendingMetadata()),
+		Recordings:      len(s.Recordings),
+		Stages:          make(map[Stage]int),
+		QueryComplete:   s.QueryComplete,
+		Done:            s.Done,
+		Interrupted:     s.Interrupted,
+	}
+	for _, rec := range s.Recordings {
+		summary.Stages[rec.Stage]++
+		if rec.LastError != "" && rec.Stage != StageVerified {
+			summary.Failed++
+		}
+	}
+	return summary
+}
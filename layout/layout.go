@@ -0,0 +1,222 @@
+package layout
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Plantillas por defecto: la misma estructura que antes de que existieran
+// las plantillas (yymmdd-conversationId/recordingId), pero con la fecha de la
+// conversación en lugar de la de descarga.
+const (
+	DefaultFolderTemplate = "{date}-{conversationId}"
+	DefaultFileTemplate   = "{recordingId}"
+)
+
+// defaultDateLayout es el formato de {date} sin argumento.
+const defaultDateLayout = "060102"
+
+// maxComponent limita el largo (en bytes) de cada valor reemplazado.
+const maxComponent = 100
+
+// Fields son los valores disponibles en las plantillas. Los vacíos se
+// reemplazan por "unknown".
+type Fields struct {
+	ConversationID string
+	RecordingID    string
+	// Start es el inicio de la conversación; si es cero se usa la hora actual.
+	Start      time.Time
+	Queue      string
+	QueueID    string
+	Agent      string
+	AgentID    string
+	Direction  string
+	Division   string
+	DivisionID string
+}
+
+// fieldValues asocia cada variable con su valor en Fields.
+var fieldValues = map[string]func(Fields) string{
+	"conversationId": func(f Fields) string { return f.ConversationID },
+	"recordingId":    func(f Fields) string { return f.RecordingID },
+	"queue":          func(f Fields) string { return f.Queue },
+	"queueId":        func(f Fields) string { return f.QueueID },
+	"agent":          func(f Fields) string { return f.Agent },
+	"agentId":        func(f Fields) string { return f.AgentID },
+	"direction":      func(f Fields) string { return f.Direction },
+	"division":       func(f Fields) string { return f.Division },
+	"divisionId":     func(f Fields) string { return f.DivisionID },
+}
+
+// Layout arma la carpeta y el nombre de archivo de cada grabación a partir
+// de dos plantillas. Una plantilla es texto con variables {nombre}; {date}
+// acepta un formato de Go ({date:2006-01-02}, por defecto 060102) y se
+// calcula en el huso horario del Layout. Los valores, incluida la fecha, se
+// sanean para que sirvan como nombre de archivo en cualquier sistema; sólo
+// las "/" del texto fijo de la plantilla separan carpetas.
+type Layout struct {
+	folder   []part
+	file     []part
+	location *time.Location
+	// variables usadas en cada plantilla
+	folderUses map[string]bool
+	fileUses   map[string]bool
+}
+
+// part es un tramo de plantilla: texto fijo o una variable.
+type part struct {
+	text     string
+	variable string
+	arg      string
+}
+
+// New compila las plantillas. Una plantilla vacía usa la de por defecto;
+// timezone es un nombre IANA (p.ej. America/Argentina/Buenos_Aires) o vacío
+// para el huso horario local.
+func New(folderTemplate, fileTemplate, timezone string) (*Layout, error) {
+	if folderTemplate == "" {
+		folderTemplate = DefaultFolderTemplate
+	}
+	if fileTemplate == "" {
+		fileTemplate = DefaultFileTemplate
+	}
+	location := time.Local
+	if timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(timezone); err != nil {
+			return nil, fmt.Errorf("invalid path timezone %q: %w", timezone, err)
+		}
+	}
+
+	l := &Layout{location: location, folderUses: make(map[string]bool), fileUses: make(map[string]bool)}
+	var err error
+	if l.folder, err = parse(folderTemplate, l.folderUses); err != nil {
+		return nil, fmt.Errorf("folder template: %w", err)
+	}
+	if l.file, err = parse(fileTemplate, l.fileUses); err != nil {
+		return nil, fmt.Errorf("file template: %w", err)
+	}
+	if strings.Contains(fileTemplate, "/") {
+		return nil, fmt.Errorf("file template %q must not contain /", fileTemplate)
+	}
+	return l, nil
+}
+
+// parse separa la plantilla en texto fijo y variables, anota en uses las
+// variables encontradas y rechaza las desconocidas.
+func parse(template string, uses map[string]bool) ([]part, error) {
+	var parts []part
+	for rest := template; rest != ""; {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			parts = append(parts, part{text: rest})
+			break
+		}
+		if open > 0 {
+			parts = append(parts, part{text: rest[:open]})
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed { in %q", template)
+		}
+		name, arg, _ := strings.Cut(rest[open+1:open+end], ":")
+		if _, ok := fieldValues[name]; !ok && name != "date" {
+			return nil, fmt.Errorf("unknown variable {%s} in %q", name, template)
+		}
+		uses[name] = true
+		parts = append(parts, part{variable: name, arg: arg})
+		rest = rest[open+end+1:]
+	}
+	return parts, nil
+}
+
+// Uses indica si alguna plantilla usa la variable name (sin llaves).
+func (l *Layout) Uses(name string) bool {
+	return l.folderUses[name] || l.fileUses[name]
+}
+
+// FolderUses indica si la plantilla de carpeta usa la variable name.
+func (l *Layout) FolderUses(name string) bool {
+	return l.folderUses[name]
+}
+
+// Folder devuelve la carpeta (clave relativa, separada por "/") de la grabación.
+func (l *Layout) Folder(fields Fields) string {
+	return l.expand(l.folder, fields)
+}
+
+// File devuelve el nombre de archivo de la grabación, sin extensión.
+func (l *Layout) File(fields Fields) string {
+	return l.expand(l.file, fields)
+}
+
+func (l *Layout) expand(parts []part, fields Fields) string {
+	var b strings.Builder
+	for _, p := range parts {
+		switch {
+		case p.variable == "":
+			b.WriteString(p.text)
+		case p.variable == "date":
+			start := fields.Start
+			if start.IsZero() {
+				start = time.Now()
+			}
+			dateLayout := p.arg
+			if dateLayout == "" {
+				dateLayout = defaultDateLayout
+			}
+			b.WriteString(Sanitize(start.In(l.location).Format(dateLayout)))
+		default:
+			b.WriteString(Sanitize(fieldValues[p.variable](fields)))
+		}
+	}
+
+	// Cada carpeta del resultado tiene que ser un nombre válido
+	var segments []string
+	for _, segment := range strings.Split(b.String(), "/") {
+		segment = strings.TrimSpace(segment)
+		switch segment {
+		case "":
+			continue
+		case ".", "..":
+			segment = "_"
+		}
+		segments = append(segments, segment)
+	}
+	if len(segments) == 0 {
+		return "unknown"
+	}
+	return strings.Join(segments, "/")
+}
+
+// Sanitize deja value apto como nombre de archivo: reemplaza por "_" los
+// separadores de ruta, los caracteres reservados en Windows y los de
+// control, quita espacios y puntos de los extremos y lo acorta a
+// maxComponent bytes. Un valor vacío queda como "unknown".
+func Sanitize(value string) string {
+	var b strings.Builder
+	for _, r := range value {
+		switch {
+		case r < 0x20 || r == 0x7f || r == utf8.RuneError:
+			b.WriteByte('_')
+		case strings.ContainsRune(`/\:*?"<>|`, r):
+			b.WriteByte('_')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	clean := strings.Trim(strings.TrimSpace(b.String()), ".")
+	if len(clean) > maxComponent {
+		cut := maxComponent
+		for cut > 0 && !utf8.RuneStart(clean[cut]) {
+			cut--
+		}
+		clean = strings.TrimSpace(clean[:cut])
+	}
+	if clean == "" {
+		return "unknown"
+	}
+	return clean
+}
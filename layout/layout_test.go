@@ -0,0 +1,101 @@
+package layout
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSanitize(t *testing.T) {
+	tests := []struct{ value, want string }{
+		{"Ventas", "Ventas"},
+		{"Ventas/Norte", "Ventas_Norte"},
+		{`a\b:c*d?e"f<g>h|i`, "a_b_c_d_e_f_g_h_i"},
+		{"tab\there\x7f", "tab_here_"},
+		{"  .oculto.  ", "oculto"},
+		{"..", "unknown"},
+		{"", "unknown"},
+		{"   ", "unknown"},
+		{"José Pérez", "José Pérez"},
+		{strings.Repeat("a", 150), strings.Repeat("a", maxComponent)},
+		// No se corta una runa a la mitad
+		{strings.Repeat("a", 99) + "ñ", strings.Repeat("a", 99)},
+	}
+	for _, test := range tests {
+		if got := Sanitize(test.value); got != test.want {
+			t.Errorf("Sanitize(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	start := time.Date(2025, 6, 2, 1, 30, 45, 0, time.UTC)
+	fields := Fields{
+		ConversationID: "c1",
+		RecordingID:    "r1",
+		Start:          start,
+		Queue:          "Ventas/Norte",
+		QueueID:        "q1",
+		Agent:          "José: Pérez",
+		Direction:      "inbound",
+	}
+	tests := []struct {
+		name, folder, file, timezone string
+		fields                       Fields
+		wantFolder, wantFile         string
+	}{
+		{"por defecto", "", "", "UTC", fields, "250602-c1", "r1"},
+		{"huso horario", "", "", "America/Argentina/Buenos_Aires", fields, "250601-c1", "r1"},
+		{"subcarpetas", "{date:2006}/{date:01}/{queue}", "{agent}_{recordingId}", "UTC", fields, "2025/06/Ventas_Norte", "José_ Pérez_r1"},
+		{"fecha con : saneada", "{date:2006-01-02}", "{date:15:04:05}", "UTC", fields, "2025-06-02", "01_30_45"},
+		{"fecha con / saneada", "{date:2006/01/02}-{conversationId}", "{recordingId}", "UTC", fields, "2025_06_02-c1", "r1"},
+		{"valores vacíos", "{division}/{queue}", "{agentId}", "UTC", Fields{}, "unknown/unknown", "unknown"},
+		{"segmentos vacíos y relativos", "//{direction}/../x/", "{recordingId}", "UTC", fields, "inbound/_/x", "r1"},
+		{"texto fijo", "grabaciones/{queueId}", "audio-{recordingId}", "UTC", fields, "grabaciones/q1", "audio-r1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l, err := New(test.folder, test.file, test.timezone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := l.Folder(test.fields); got != test.wantFolder {
+				t.Errorf("folder: got %q, want %q", got, test.wantFolder)
+			}
+			if got := l.File(test.fields); got != test.wantFile {
+				t.Errorf("file: got %q, want %q", got, test.wantFile)
+			}
+		})
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	tests := []struct {
+		name, folder, file, timezone string
+	}{
+		{"variable desconocida", "{fecha}", "", ""},
+		{"llave sin cerrar", "{date", "", ""},
+		{"archivo con /", "", "{date}/{recordingId}", ""},
+		{"huso horario inválido", "", "", "Marte/Olympus"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := New(test.folder, test.file, test.timezone); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestUses(t *testing.T) {
+	l, err := New("{date}/{queue}", "{agent}_{recordingId}", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.Uses("recordingId") || !l.Uses("queue") || l.Uses("division") {
+		t.Error("Uses does not match the templates")
+	}
+	if l.FolderUses("conversationId") || !l.FolderUses("date") || l.FolderUses("agent") {
+		t.Error("FolderUses does not match the folder template")
+	}
+}
@@ -22,6 +22,9 @@ func InitLogger() {
 
 	// Abre archivo de log con nombre por fecha
 	today := time.Now().Format("2006-01-02")
+	if err := os.MkdirAll("logs", os.ModePerm); err != nil {
+		panic("No se pudo crear la carpeta de logs: " + err.Error())
+	}
 	logFile, err := os.OpenFile("logs/"+today+".log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		panic("No se pudo crear el archivo de log: " + err.Error())
@@ -29,7 +32,7 @@ func InitLogger() {
 
 	// Crear escritor para archivo
 	fileWriter := zapcore.AddSync(logFile)
-	consoleWriter := zapcore.AddSync(os.Stdout)
+	consoleWriter := zapcore.AddSync(os.Stderr)
 
 	// Combine ambos escritores
 	core := zapcore.NewTee(
@@ -1,128 +1,235 @@
 package main
 
 import (
-	//"fmt"
-	"sync"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/goDownloadRecording/auth"
 	"github.com/goDownloadRecording/config"
 	query "github.com/goDownloadRecording/conversation_query"
-	"github.com/goDownloadRecording/functions"
+	"github.com/goDownloadRecording/governor"
 	"github.com/goDownloadRecording/logger"
+	"github.com/goDownloadRecording/storage"
 	sdk "github.com/mypurecloud/platform-client-sdk-go/v157/platformclientv2"
 	"go.uber.org/zap"
 )
 
+const usageText = `Usage: goDownloadRecording [command] [flags]
+
+Commands:
+  download   query conversations, request batch downloads and save recordings (default)
+  query      run the conversation query only and print the conversation IDs
+  resume     continue the last interrupted run from its journal
+  daemon     keep downloading new recordings incrementally from a watermark
+  schedule   run the named export jobs of a jobs file on their cron schedules
+  retry      poll existing batch job IDs and download their recordings
+  verify     check the recordings directory and the archive index for missing or damaged files
+  decrypt    decrypt recordings saved with encryption at rest
+  package    bundle downloaded recordings into tar.gz or zip packages with a manifest
+  purge      delete recordings past their retention period (see -retention-policy)
+
+Run "goDownloadRecording <command> -h" to see the flags of each command.
+`
+
 func main() {
 	logger.InitLogger()
 	defer logger.Log.Sync()
 
-	logger.Log.Info("Starting Genesys Download Recording")
-
-	cfg, err := config.LoadConfig()
-	if err != nil {
-		logger.Log.Error("Error loading environment variables", zap.Error(err))
-		return
+	// Sin subcomando se mantiene el comportamiento histórico: download.
+	command, args := "download", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
 	}
 
-	// Configuración y autorización del SDK
-	config := sdk.GetDefaultConfiguration()
-	config.BasePath = "https://api." + cfg.GenesysCloudEnvironment
-	downloadPath := "./recordings/"
-	err = config.AuthorizeClientCredentials(cfg.ClientID, cfg.ClientSecret)
-	if err != nil {
-		logger.Log.Error("Error authorizing client credentials", zap.Error(err))
+	ctx := handleSignals()
+
+	var err error
+	switch command {
+	case "download":
+		err = runDownload(ctx, args)
+	case "query":
+		err = runQuery(ctx, args)
+	case "resume":
+		err = runResume(ctx, args)
+	case "daemon":
+		err = runDaemon(ctx, args)
+	case "schedule":
+		err = runSchedule(ctx, args)
+	case "retry":
+		err = runRetry(ctx, args)
+	case "verify":
+		err = runVerify(ctx, args)
+	case "decrypt":
+		err = runDecrypt(args)
+	case "package":
+		err = runPackage(args)
+	case "purge":
+		err = runPurge(args)
+	case "help":
+		fmt.Print(usageText)
 		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usageText)
+		os.Exit(2)
 	}
 
-	// Instanciar APIs
-	analyticsApi := sdk.NewAnalyticsApi()
-	recordApi := sdk.NewRecordingApi()
-
-	// Construir la query
-	logger.Log.Info("Building conversation query")
-	queryConversation := query.BuildConversationQuery()
-
-	start := time.Now() // Marca el inicio justo después de ingresar datos
+	// Governor.Default es nil si el comando no llegó a autorizar
+	governor.Default.LogStats()
 
-	// Obtener todas las conversaciones paginadas
-	logger.Log.Info("Starting paginated query")
-	results, err := query.GetAllConversationsResults(analyticsApi, queryConversation)
-	if err != nil {
-		logger.Log.Error("Error during paginated query", zap.Error(err))
+	if errors.Is(err, flag.ErrHelp) {
 		return
 	}
-	logger.Log.Info("Successfully retrieved conversation data", zap.Int("TotalConversations", len(results)))
-
-	// Extraer IDs de conversación
-	var conversationIDs []string
-	for _, conv := range results {
-		if conv.ConversationId != nil {
-			conversationIDs = append(conversationIDs, *conv.ConversationId)
-		}
+	if ctx.Err() != nil {
+		logger.Log.Warn("Command interrupted", zap.String("Command", command), zap.Error(context.Cause(ctx)))
+		logger.Log.Sync()
+		os.Exit(130)
+	}
+	if err != nil {
+		logger.Log.Error("Command failed", zap.String("Command", command), zap.Error(err))
+		logger.Log.Sync()
+		os.Exit(1)
 	}
+}
 
-	// Construir solicitud batch
-	const batchWorkers = 5 // O el número que quieras probar
+// handleSignals devuelve un contexto que se cancela con SIGINT o SIGTERM.
+// Con la primera señal se deja de tomar trabajo nuevo y se cierra lo que está
+// en curso; con la segunda se sale de inmediato.
+func handleSignals() context.Context {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		logger.Log.Warn("Shutdown requested, finishing in-flight work (signal again to force exit)", zap.String("Signal", sig.String()))
+		cancel(fmt.Errorf("received %s", sig))
+		sig = <-signals
+		logger.Log.Error("Forced exit", zap.String("Signal", sig.String()))
+		logger.Log.Sync()
+		os.Exit(130)
+	}()
+	return ctx
+}
 
-	batchRequestBody, err := functions.AddConversationRecordingsToBatch(conversationIDs, recordApi, batchWorkers)
+// newFlagSet carga la configuración del entorno y registra sus flags.
+func newFlagSet(name string) (*flag.FlagSet, *config.Config, error) {
+	cfg, err := config.LoadConfig()
 	if err != nil {
-		logger.Log.Warn("Continuing despite some metadata fetch errors", zap.Error(err))
-		// No retornamos. Continuamos mientras tengamos algo que procesar.
+		return nil, nil, fmt.Errorf("loading environment variables: %w", err)
 	}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	cfg.RegisterFlags(fs)
+	return fs, cfg, nil
+}
 
-	if batchRequestBody == nil || len(batchRequestBody) == 0 {
-		logger.Log.Warn("No batch requests were created. Exiting.")
-		return
+// authorize configura el SDK y obtiene el token con client credentials. El
+// token lo mantiene vigente auth.Manager (renovación anticipada y ante 401)
+// y el governor lo pone en cada request hasta que se cancele ctx.
+func authorize(ctx context.Context, cfg *config.Config) error {
+	if cfg.GenesysCloudEnvironment == "" || cfg.ClientID == "" || cfg.ClientSecret == "" {
+		return fmt.Errorf("environment, client-id and client-secret are required")
+	}
+	if cfg.TokenCachePath != "" && cfg.TokenCacheKey == "" {
+		return fmt.Errorf("token-cache-key is required to encrypt the token cache")
 	}
 
-	// Enviar batch dividido en partes
-	batchSubmissionResults, err := functions.SendBatchRequests(recordApi, batchRequestBody)
+	tokens := auth.New(auth.Options{
+		Environment:  cfg.GenesysCloudEnvironment,
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		CachePath:    cfg.TokenCachePath,
+		CacheKey:     cfg.TokenCacheKey,
+	})
+	token, err := tokens.Token()
 	if err != nil {
-		logger.Log.Error("Error sending batch requests", zap.Error(err))
-		return
+		return fmt.Errorf("authorizing client credentials: %w", err)
 	}
-	if batchSubmissionResults == nil || len(batchSubmissionResults) == 0 {
-		logger.Log.Warn("Batch submission was empty, skipping download")
-		return
-	}
-
-	// Hacer polling para cada job y descargar grabaciones
-	// Procesar jobs en paralelo
-	var wg sync.WaitGroup
-	maxDownloadWorkers := cfg.MaxDownloadWorkers //10
-	pollRetries := cfg.PollRetries               // 100 intentos para evitar timeout
-	pollInterval := cfg.PollInterval             //25 * time.Second // tiempo entre polls
 
-	for _, batchSubmissionResult := range batchSubmissionResults {
-		jobID := *batchSubmissionResult.Id
-		wg.Add(1)
-
-		go func(jobID string) {
-			defer wg.Done()
-
-			batchStatus, err := functions.PollBatchJobUntilReady(recordApi, jobID, pollRetries, pollInterval)
-			if err != nil {
-				logger.Log.Error("Error polling batch job status", zap.String("BatchID", jobID), zap.Error(err))
-				return
-			}
-			if batchStatus == nil || batchStatus.Results == nil {
-				logger.Log.Warn("No results available in batch job", zap.String("BatchID", jobID))
-				return
-			}
+	// Todas las llamadas al SDK pasan por el governor: token bucket global
+	// y reintentos ante 429/503 respetando Retry-After.
+	err = governor.Init(governor.Options{
+		RequestsPerSecond: cfg.APIRate,
+		Burst:             cfg.APIBurst,
+		MaxRetries:        cfg.APIRetries,
+		Tokens:            tokens,
+		Context:           ctx,
+	})
+	if err != nil {
+		return fmt.Errorf("installing API governor: %w", err)
+	}
 
-			logger.Log.Info("Descargando grabaciones del batch", zap.String("BatchID", jobID))
+	sdkConfig := sdk.GetDefaultConfiguration()
+	sdkConfig.BasePath = "https://api." + cfg.GenesysCloudEnvironment
+	sdkConfig.AccessToken = token
+	return nil
+}
 
-			err = functions.DownloadAllReadyRecordings(batchStatus, downloadPath, maxDownloadWorkers)
-			if err != nil {
-				logger.Log.Error("Error downloading recordings", zap.String("BatchID", jobID), zap.Error(err))
+// openStorage crea el backend de almacenamiento elegido en la configuración.
+// ctx corta los reintentos del backend (SFTP).
+func openStorage(ctx context.Context, cfg *config.Config) (storage.Backend, error) {
+	switch cfg.Storage {
+	case "", "local":
+		return storage.NewLocal(cfg.DownloadPath), nil
+	case "s3":
+		if cfg.S3Endpoint == "" || cfg.S3Bucket == "" {
+			return nil, fmt.Errorf("s3-endpoint and s3-bucket are required for s3 storage")
+		}
+		backend, err := storage.NewS3(storage.S3Options{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			Prefix:    cfg.S3Prefix,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			UseSSL:    cfg.S3UseSSL,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("opening s3 storage: %w", err)
+		}
+		return backend, nil
+	case "sftp":
+		if cfg.SFTPAddress == "" || cfg.SFTPUser == "" || cfg.SFTPKeyFile == "" {
+			return nil, fmt.Errorf("sftp-host, sftp-user and sftp-key are required for sftp storage")
+		}
+		location := time.Local
+		if cfg.PathTimezone != "" {
+			var err error
+			if location, err = time.LoadLocation(cfg.PathTimezone); err != nil {
+				return nil, fmt.Errorf("invalid path timezone %q: %w", cfg.PathTimezone, err)
 			}
-		}(jobID)
+		}
+		backend, err := storage.NewSFTP(storage.SFTPOptions{
+			Address:         cfg.SFTPAddress,
+			User:            cfg.SFTPUser,
+			KeyFile:         cfg.SFTPKeyFile,
+			KeyPassphrase:   cfg.SFTPKeyPassphrase,
+			KnownHostsFile:  cfg.SFTPKnownHosts,
+			InsecureHostKey: cfg.SFTPInsecureHostKey,
+			PathTemplate:    cfg.SFTPPathTemplate,
+			Location:        location,
+			Retries:         cfg.SFTPRetries,
+			Context:         ctx,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("opening sftp storage: %w", err)
+		}
+		return backend, nil
+	default:
+		return nil, fmt.Errorf("unknown storage %q (use local, s3 or sftp)", cfg.Storage)
 	}
+}
 
-	wg.Wait()
-
-	elapsed := time.Since(start)
-	logger.Log.Info("Tiempo total de ejecución", zap.Duration("Duración:", elapsed))
-	logger.Log.Info("Process completed successfully")
+// buildQuery completa las opciones de la consulta (prompts sólo si stdin es
+// una terminal y no se pidió -no-prompt) y construye la query.
+func buildQuery(fs *flag.FlagSet, opts *query.QueryOptions, noPrompt bool) (sdk.Conversationquery, error) {
+	if !noPrompt && query.IsInteractive() {
+		opts.PromptMissing(!query.AnyFlagSet(fs))
+	}
+	return query.BuildConversationQuery(*opts)
 }
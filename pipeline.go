@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goDownloadRecording/config"
+	query "github.com/goDownloadRecording/conversation_query"
+	"github.com/goDownloadRecording/functions"
+	"github.com/goDownloadRecording/journal"
+	"github.com/goDownloadRecording/logger"
+	sdk "github.com/mypurecloud/platform-client-sdk-go/v157/platformclientv2"
+	"go.uber.org/zap"
+)
+
+// pipelineBuffer es la capacidad de los canales entre etapas del pipeline.
+const pipelineBuffer = 100
+
+// runPipeline ejecuta query, metadata, envío de batch y descargas como un
+// pipeline: cada etapa pasa su trabajo a la siguiente apenas lo tiene, de
+// modo que las descargas empiezan con el primer batch job terminado y no al
+// final de la query. Las etapas se comunican por canales acotados y las
+// descargas por un máximo de cfg.MaxActiveJobs jobs a la vez: si una etapa
+// se atrasa, las anteriores se frenan en lugar de acumular trabajo. Si todo
+// termina bien marca la corrida como hecha en el journal.
+func runPipeline(ctx context.Context, analyticsApi *sdk.AnalyticsApi, recordApi *sdk.RecordingApi, cfg *config.Config, queryConversation sdk.Conversationquery, opts functions.DownloadOptions) error {
+	j := opts.Journal
+	conversationCh := make(chan string, pipelineBuffer)
+	requestCh := make(chan sdk.Batchdownloadrequest, pipelineBuffer)
+	batchCh := make(chan sdk.Batchdownloadrequest, pipelineBuffer)
+	textCh := make(chan sdk.Batchdownloadrequest, pipelineBuffer)
+	jobCh := make(chan functions.SubmittedJob)
+
+	var (
+		queryErr, submitErr error
+		conversations, jobs atomic.Int64
+		stages              sync.WaitGroup
+	)
+	start := time.Now()
+	logger.Log.Info("Starting pipeline",
+		zap.String("Mode", cfg.QueryMode),
+		zap.Int("MetadataWorkers", cfg.BatchWorkers),
+		zap.Int("ActiveJobs", cfg.MaxActiveJobs))
+
+	// 1. Query: cada página pasa sus conversaciones a la metadata
+	stages.Add(1)
+	go func() {
+		defer stages.Done()
+		defer close(conversationCh)
+		queryErr = query.StreamConversations(ctx, analyticsApi, queryConversation, queryExecOptions(cfg),
+			func(page []sdk.Analyticsconversationwithoutattributes) error {
+				for _, conv := range page {
+					if conv.ConversationId == nil {
+						continue
+					}
+					opts.Details.AddConversation(conv)
+					if err := j.Record(journal.Entry{Stage: journal.StageQueried, ConversationID: *conv.ConversationId, ConversationStart: conv.ConversationStart}); err != nil {
+						return fmt.Errorf("writing journal: %w", err)
+					}
+					select {
+					case conversationCh <- *conv.ConversationId:
+						conversations.Add(1)
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+				return nil
+			})
+		if queryErr != nil {
+			// Lo ya consultado sigue su curso; resume repite la query
+			logger.Log.Error("Conversation query failed", zap.Error(queryErr))
+			return
+		}
+		if err := j.Record(journal.Entry{Stage: journal.StageQueryComplete}); err != nil {
+			queryErr = fmt.Errorf("writing journal: %w", err)
+			return
+		}
+		logger.Log.Info("Successfully retrieved conversation data", zap.Int64("TotalConversations", conversations.Load()))
+	}()
+
+	// 2. Metadata: una solicitud por grabación
+	stages.Add(1)
+	go func() {
+		defer stages.Done()
+		defer close(requestCh)
+		functions.StreamRecordingMetadata(ctx, conversationCh, recordApi, cfg.BatchWorkers, j, opts.Details, opts.ExcludeMedia, requestCh)
+	}()
+
+	// Las interacciones de texto no van en batch: se exportan como transcripción
+	stages.Add(1)
+	go func() {
+		defer stages.Done()
+		defer close(batchCh)
+		defer close(textCh)
+		functions.RouteTextRecordings(ctx, requestCh, opts.Details, batchCh, textCh)
+	}()
+	skip := func(request sdk.Batchdownloadrequest) bool {
+		if !functions.IsArchived(request, opts.Storage, opts.Index, j) {
+			return false
+		}
+		opts.Details.Done(*request.ConversationId)
+		return true
+	}
+	stages.Add(1)
+	go func() {
+		defer stages.Done()
+		functions.ExportTranscripts(ctx, textCh, skip, opts)
+	}()
+
+	// 3. Envío: un batch job por cada lote completo, salvo lo ya archivado
+	stages.Add(1)
+	go func() {
+		defer stages.Done()
+		defer close(jobCh)
+		submitErr = functions.SubmitBatches(ctx, recordApi, batchCh, skip, j, opts.Details, jobCh)
+	}()
+
+	// 4. Descargas: cada job se espera y descarga apenas se envía, con hasta
+	// MaxActiveJobs a la vez
+	var (
+		downloads  sync.WaitGroup
+		failedJobs atomic.Int64
+	)
+	active := make(chan struct{}, max(cfg.MaxActiveJobs, 1))
+	for job := range jobCh {
+		jobs.Add(1)
+		active <- struct{}{}
+		downloads.Add(1)
+		go func(job functions.SubmittedJob) {
+			defer downloads.Done()
+			defer func() { <-active }()
+			if err := downloadBatchJob(ctx, recordApi, cfg, job.ID, nil, opts); err != nil {
+				// Sus grabaciones quedan enviadas en el journal: resume las
+				// retoma. Su detalle ya no hace falta en esta corrida.
+				failedJobs.Add(1)
+				opts.Details.DoneRequests(job.Requests)
+			}
+		}(job)
+	}
+	downloads.Wait()
+	stages.Wait()
+
+	logger.Log.Info("Pipeline completed",
+		zap.Int64("Conversations", conversations.Load()),
+		zap.Int64("BatchJobs", jobs.Load()),
+		zap.Int64("FailedJobs", failedJobs.Load()),
+		zap.Duration("Duration", time.Since(start)))
+
+	switch {
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case queryErr != nil:
+		return fmt.Errorf("conversation query: %w", queryErr)
+	case submitErr != nil:
+		return fmt.Errorf("sending batch requests: %w", submitErr)
+	}
+	return j.Record(journal.Entry{Stage: journal.StageDone})
+}
@@ -0,0 +1,79 @@
+package retention
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Acciones registradas en el log de auditoría. Cada borrado registra primero
+// AuditStarted, con los archivos que se van a borrar, y después su resultado:
+// un AuditStarted sin resultado es un borrado que se cortó a mitad de camino.
+const (
+	AuditStarted = "delete-started"
+	AuditDeleted = "deleted"
+	AuditFailed  = "delete-failed"
+)
+
+// AuditEntry registra el borrado de una grabación (o su intención).
+type AuditEntry struct {
+	Time           time.Time `json:"time"`
+	Action         string    `json:"action"`
+	RecordingID    string    `json:"recordingId"`
+	ConversationID string    `json:"conversationId,omitempty"`
+	Path           string    `json:"path,omitempty"`
+	// Bundle es el paquete borrado, si la grabación estaba empaquetada
+	Bundle            string    `json:"bundle,omitempty"`
+	Files             []string  `json:"files,omitempty"`
+	Size              int64     `json:"size,omitempty"`
+	SHA256            string    `json:"sha256,omitempty"`
+	ConversationStart time.Time `json:"conversationStart"`
+	Rule              string    `json:"rule"`
+	Days              int       `json:"days"`
+	ExpiresAt         time.Time `json:"expiresAt"`
+	Error             string    `json:"error,omitempty"`
+}
+
+// Audit es el log de auditoría de los borrados: un archivo JSONL al que sólo
+// se agregan líneas.
+type Audit struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenAudit abre (o crea) el log de auditoría en path.
+func OpenAudit(path string) (*Audit, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Audit{file: file}, nil
+}
+
+// Record agrega entry al log y lo sincroniza a disco, de modo que un borrado
+// registrado no se pierde aunque el proceso se corte.
+func (a *Audit) Record(entry AuditEntry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return a.file.Sync()
+}
+
+// Close cierra el log.
+func (a *Audit) Close() error {
+	return a.file.Close()
+}
@@ -0,0 +1,260 @@
+// Package retention define la política de retención del archivo local:
+// cuántos días se conserva cada grabación (con excepciones por cola o
+// división), qué grabaciones están bajo legal hold y el log de auditoría de
+// cada borrado.
+package retention
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Acciones de una decisión de retención.
+const (
+	ActionKeep   = "keep"   // todavía no venció
+	ActionDelete = "delete" // venció y se puede borrar
+	ActionHold   = "hold"   // venció pero está bajo legal hold
+	// ActionUnknown: pudo haber vencido, pero no se conocen sus colas ni
+	// divisiones y la política depende de ellas; nunca se borra
+	ActionUnknown = "unknown"
+)
+
+// Policy es la política de retención, versionable en un archivo YAML o JSON.
+type Policy struct {
+	// DefaultDays es la retención de las grabaciones sin regla (0 = sin
+	// vencimiento).
+	DefaultDays int `json:"defaultDays" yaml:"defaultDays"`
+	// Rules reemplazan DefaultDays para ciertas colas o divisiones. Si
+	// varias aplican a una grabación gana la de más días.
+	Rules []Rule `json:"rules,omitempty" yaml:"rules,omitempty"`
+	// LegalHolds impiden borrar las grabaciones que alcanzan, aunque hayan
+	// vencido.
+	LegalHolds []Hold `json:"legalHolds,omitempty" yaml:"legalHolds,omitempty"`
+}
+
+// Rule fija la retención de las grabaciones de alguna de sus colas o
+// divisiones.
+type Rule struct {
+	Name      string   `json:"name" yaml:"name"`
+	Queues    []string `json:"queues,omitempty" yaml:"queues,omitempty"`
+	Divisions []string `json:"divisions,omitempty" yaml:"divisions,omitempty"`
+	Days      int      `json:"days" yaml:"days"`
+}
+
+// Hold es un legal hold: alcanza a las conversaciones, grabaciones, colas o
+// divisiones listadas hasta Until (vacío = sin fecha de fin).
+type Hold struct {
+	Name          string   `json:"name" yaml:"name"`
+	Reason        string   `json:"reason,omitempty" yaml:"reason,omitempty"`
+	Conversations []string `json:"conversations,omitempty" yaml:"conversations,omitempty"`
+	Recordings    []string `json:"recordings,omitempty" yaml:"recordings,omitempty"`
+	Queues        []string `json:"queues,omitempty" yaml:"queues,omitempty"`
+	Divisions     []string `json:"divisions,omitempty" yaml:"divisions,omitempty"`
+	// Until es una fecha (2006-01-02) o un instante RFC 3339
+	Until string `json:"until,omitempty" yaml:"until,omitempty"`
+
+	until time.Time
+}
+
+// Item es una grabación a evaluar.
+type Item struct {
+	RecordingID    string
+	ConversationID string
+	QueueIDs       []string
+	DivisionIDs    []string
+	// Start es el inicio de la conversación; la antigüedad se cuenta desde ahí
+	Start time.Time
+	// Unknown indica que no se sabe a qué colas y divisiones pertenece (p.ej.
+	// falta el sidecar): QueueIDs y DivisionIDs vacíos no significan "ninguna"
+	Unknown bool
+}
+
+// Decision es el resultado de evaluar una grabación.
+type Decision struct {
+	Action string
+	// Rule es la regla aplicada ("default" si ninguna) y Days su retención
+	Rule string
+	Days int
+	// ExpiresAt es cuándo vence la grabación (cero si no vence)
+	ExpiresAt time.Time
+	// Hold es el legal hold que la retiene, si Action es ActionHold
+	Hold string
+}
+
+// Load lee una política desde un archivo .yaml, .yml o .json y la valida.
+// Los campos desconocidos se rechazan para detectar errores de tipeo.
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &Policy{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(policy)
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(policy)
+	default:
+		return nil, fmt.Errorf("unsupported retention policy format %q (use .yaml, .yml or .json)", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing retention policy %s: %w", path, err)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("retention policy %s: %w", path, err)
+	}
+	return policy, nil
+}
+
+// Validate comprueba la política y prepara las fechas de los legal holds.
+func (p *Policy) Validate() error {
+	if p.DefaultDays < 0 {
+		return fmt.Errorf("defaultDays must be 0 (no expiry) or positive")
+	}
+	for i, rule := range p.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rule %d has no name", i+1)
+		}
+		if len(rule.Queues) == 0 && len(rule.Divisions) == 0 {
+			return fmt.Errorf("rule %q needs queues or divisions", rule.Name)
+		}
+		if rule.Days <= 0 {
+			return fmt.Errorf("rule %q: days must be positive (use a legal hold to keep recordings indefinitely)", rule.Name)
+		}
+	}
+	for i := range p.LegalHolds {
+		hold := &p.LegalHolds[i]
+		if hold.Name == "" {
+			return fmt.Errorf("legal hold %d has no name", i+1)
+		}
+		if len(hold.Conversations)+len(hold.Recordings)+len(hold.Queues)+len(hold.Divisions) == 0 {
+			return fmt.Errorf("legal hold %q needs conversations, recordings, queues or divisions", hold.Name)
+		}
+		if hold.Until == "" {
+			continue
+		}
+		until, err := time.Parse(time.RFC3339, hold.Until)
+		if err != nil {
+			// Una fecha sola vale hasta el final de ese día
+			day, dayErr := time.Parse("2006-01-02", hold.Until)
+			if dayErr != nil {
+				return fmt.Errorf("legal hold %q: invalid until %q (use 2006-01-02 or RFC 3339)", hold.Name, hold.Until)
+			}
+			until = day.AddDate(0, 0, 1)
+		}
+		hold.until = until
+	}
+	return nil
+}
+
+// Evaluate decide qué hacer con item a la fecha now. Si item.Unknown y
+// alguna regla o legal hold depende de colas o divisiones, la grabación no se
+// borra: queda como ActionUnknown desde que vencería con la retención más
+// corta posible.
+func (p *Policy) Evaluate(item Item, now time.Time) Decision {
+	if item.Unknown && p.usesAttributes() {
+		return p.evaluateUnknown(item, now)
+	}
+	decision := Decision{Action: ActionKeep, Rule: "default", Days: p.DefaultDays}
+	matched := false
+	for _, rule := range p.Rules {
+		if !intersects(rule.Queues, item.QueueIDs) && !intersects(rule.Divisions, item.DivisionIDs) {
+			continue
+		}
+		if !matched || rule.Days > decision.Days {
+			decision.Rule, decision.Days = rule.Name, rule.Days
+			matched = true
+		}
+	}
+	if decision.Days == 0 {
+		return decision
+	}
+
+	decision.ExpiresAt = item.Start.AddDate(0, 0, decision.Days)
+	if now.Before(decision.ExpiresAt) {
+		return decision
+	}
+	decision.Action = ActionDelete
+	if hold := p.hold(item, now); hold != nil {
+		decision.Action, decision.Hold = ActionHold, hold.Name
+	}
+	return decision
+}
+
+// evaluateUnknown evalúa item sin sus colas ni divisiones: toma la retención
+// más corta entre la default y las reglas, y si venció la deja retenida (por
+// un legal hold por conversación o grabación) o como ActionUnknown.
+func (p *Policy) evaluateUnknown(item Item, now time.Time) Decision {
+	decision := Decision{Action: ActionKeep, Rule: "default", Days: p.DefaultDays}
+	for _, rule := range p.Rules {
+		if decision.Days == 0 || rule.Days < decision.Days {
+			decision.Rule, decision.Days = rule.Name, rule.Days
+		}
+	}
+	if decision.Days == 0 {
+		return decision
+	}
+
+	decision.ExpiresAt = item.Start.AddDate(0, 0, decision.Days)
+	if now.Before(decision.ExpiresAt) {
+		return decision
+	}
+	decision.Action = ActionUnknown
+	if hold := p.hold(item, now); hold != nil {
+		decision.Action, decision.Hold = ActionHold, hold.Name
+	}
+	return decision
+}
+
+// usesAttributes indica si alguna regla o legal hold depende de colas o
+// divisiones.
+func (p *Policy) usesAttributes() bool {
+	if len(p.Rules) > 0 {
+		return true
+	}
+	for _, hold := range p.LegalHolds {
+		if len(hold.Queues)+len(hold.Divisions) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// hold devuelve el primer legal hold vigente que alcanza a item.
+func (p *Policy) hold(item Item, now time.Time) *Hold {
+	for i := range p.LegalHolds {
+		hold := &p.LegalHolds[i]
+		if !hold.until.IsZero() && !now.Before(hold.until) {
+			continue
+		}
+		if slices.Contains(hold.Conversations, item.ConversationID) ||
+			slices.Contains(hold.Recordings, item.RecordingID) ||
+			intersects(hold.Queues, item.QueueIDs) ||
+			intersects(hold.Divisions, item.DivisionIDs) {
+			return hold
+		}
+	}
+	return nil
+}
+
+func intersects(a, b []string) bool {
+	for _, value := range a {
+		if slices.Contains(b, value) {
+			return true
+		}
+	}
+	return false
+}
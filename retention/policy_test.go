@@ -0,0 +1,90 @@
+package retention
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEvaluate(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) time.Time { return now.AddDate(0, 0, -days) }
+
+	policy := &Policy{
+		DefaultDays: 90,
+		Rules: []Rule{
+			{Name: "ventas", Queues: []string{"q-ventas"}, Days: 30},
+			{Name: "legales", Divisions: []string{"d-legales"}, Days: 365},
+		},
+		LegalHolds: []Hold{
+			{Name: "caso-1", Conversations: []string{"c-hold"}},
+			{Name: "auditoria", Queues: []string{"q-auditoria"}},
+			{Name: "vencido", Recordings: []string{"r-old-hold"}, Until: "2025-01-01"},
+		},
+	}
+	if err := policy.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		item   Item
+		action string
+		rule   string
+		hold   string
+	}{
+		{"default vigente", Item{Start: daysAgo(89)}, ActionKeep, "default", ""},
+		{"default vencido", Item{Start: daysAgo(90)}, ActionDelete, "default", ""},
+		{"regla por cola", Item{QueueIDs: []string{"q-ventas"}, Start: daysAgo(31)}, ActionDelete, "ventas", ""},
+		{"regla por cola vigente", Item{QueueIDs: []string{"q-ventas"}, Start: daysAgo(29)}, ActionKeep, "ventas", ""},
+		{"gana la regla más larga", Item{QueueIDs: []string{"q-ventas"}, DivisionIDs: []string{"d-legales"}, Start: daysAgo(100)}, ActionKeep, "legales", ""},
+		{"hold por conversación", Item{ConversationID: "c-hold", Start: daysAgo(400)}, ActionHold, "default", "caso-1"},
+		{"hold por cola", Item{QueueIDs: []string{"q-auditoria"}, Start: daysAgo(400)}, ActionHold, "default", "auditoria"},
+		{"hold vencido", Item{RecordingID: "r-old-hold", Start: daysAgo(400)}, ActionDelete, "default", ""},
+		{"hold no aplica si no venció", Item{ConversationID: "c-hold", Start: daysAgo(10)}, ActionKeep, "default", ""},
+		{"desconocido vigente", Item{Unknown: true, Start: daysAgo(29)}, ActionKeep, "ventas", ""},
+		{"desconocido vencido", Item{Unknown: true, Start: daysAgo(31)}, ActionUnknown, "ventas", ""},
+		{"desconocido con hold por conversación", Item{Unknown: true, ConversationID: "c-hold", Start: daysAgo(400)}, ActionHold, "ventas", "caso-1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decision := policy.Evaluate(test.item, now)
+			if decision.Action != test.action || decision.Rule != test.rule || decision.Hold != test.hold {
+				t.Errorf("got %s/%s/%s, want %s/%s/%s", decision.Action, decision.Rule, decision.Hold, test.action, test.rule, test.hold)
+			}
+		})
+	}
+}
+
+func TestEvaluateUnknownWithoutAttributes(t *testing.T) {
+	// Sin reglas ni holds por cola o división, no conocerlas no cambia nada
+	policy := &Policy{DefaultDays: 30, LegalHolds: []Hold{{Name: "caso", Conversations: []string{"c1"}}}}
+	if err := policy.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	decision := policy.Evaluate(Item{Unknown: true, Start: now.AddDate(0, 0, -31)}, now)
+	if decision.Action != ActionDelete {
+		t.Errorf("got %s, want %s", decision.Action, ActionDelete)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+	}{
+		{"defaultDays negativo", Policy{DefaultDays: -1}},
+		{"regla sin nombre", Policy{Rules: []Rule{{Queues: []string{"q"}, Days: 1}}}},
+		{"regla sin colas", Policy{Rules: []Rule{{Name: "r", Days: 1}}}},
+		{"regla sin días", Policy{Rules: []Rule{{Name: "r", Queues: []string{"q"}}}}},
+		{"hold vacío", Policy{LegalHolds: []Hold{{Name: "h"}}}},
+		{"hold con until inválido", Policy{LegalHolds: []Hold{{Name: "h", Conversations: []string{"c"}, Until: "mañana"}}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.policy.Validate(); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
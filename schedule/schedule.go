@@ -0,0 +1,274 @@
+// Package schedule define los trabajos de exportación programados: cada uno
+// con su expresión cron, su consulta, su destino y sus límites de
+// concurrencia, versionables en un archivo YAML o JSON.
+package schedule
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/goDownloadRecording/config"
+	query "github.com/goDownloadRecording/conversation_query"
+	"github.com/goDownloadRecording/storage"
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// File es el archivo de trabajos.
+type File struct {
+	Jobs []Job `json:"jobs" yaml:"jobs"`
+}
+
+// Job es un trabajo de exportación. Cada ejecución descarga lo nuevo desde
+// su propio watermark (ver daemon); los campos vacíos toman el valor de la
+// configuración general.
+type Job struct {
+	Name string `json:"name" yaml:"name"`
+	// Schedule es una expresión cron de 5 campos ("0 6 * * 1-5") o un
+	// descriptor (@daily, @weekly, @every 2h).
+	Schedule string `json:"schedule" yaml:"schedule"`
+	// Timezone es el huso horario IANA de Schedule (default el local).
+	Timezone string `json:"timezone,omitempty" yaml:"timezone,omitempty"`
+
+	// Consulta: definición en archivo (relativa al archivo de trabajos) y
+	// filtros que la sobreescriben, como los flags de download.
+	Query     string `json:"query,omitempty" yaml:"query,omitempty"`
+	Media     string `json:"media,omitempty" yaml:"media,omitempty"`
+	Division  string `json:"division,omitempty" yaml:"division,omitempty"`
+	Direction string `json:"direction,omitempty" yaml:"direction,omitempty"`
+
+	// Output es la carpeta del trabajo: grabaciones (storage local),
+	// journal, índice y watermark. Debe ser distinta en cada trabajo. Con
+	// S3 o SFTP, el destino también (ver CheckDestinations): sin S3Prefix ni
+	// SFTPPath es el general dentro de una carpeta con el nombre del trabajo.
+	Output   string `json:"output" yaml:"output"`
+	Storage  string `json:"storage,omitempty" yaml:"storage,omitempty"`
+	S3Prefix string `json:"s3Prefix,omitempty" yaml:"s3Prefix,omitempty"`
+	SFTPPath string `json:"sftpPath,omitempty" yaml:"sftpPath,omitempty"`
+
+	DownloadWorkers int `json:"downloadWorkers,omitempty" yaml:"downloadWorkers,omitempty"`
+	BatchWorkers    int `json:"batchWorkers,omitempty" yaml:"batchWorkers,omitempty"`
+	ActiveJobs      int `json:"activeJobs,omitempty" yaml:"activeJobs,omitempty"`
+	QueryWorkers    int `json:"queryWorkers,omitempty" yaml:"queryWorkers,omitempty"`
+
+	// Since es el inicio de la primera ejecución (sin watermark): instante
+	// RFC 3339 o duración hacia atrás. Default 24h.
+	Since   string `json:"since,omitempty" yaml:"since,omitempty"`
+	Overlap string `json:"overlap,omitempty" yaml:"overlap,omitempty"`
+	Delay   string `json:"delay,omitempty" yaml:"delay,omitempty"`
+
+	overlap, delay *time.Duration
+}
+
+// validName limita los nombres a algo usable en logs y rutas.
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Load lee los trabajos desde un archivo .yaml, .yml o .json y los valida.
+// Los campos desconocidos se rechazan para detectar errores de tipeo.
+func Load(path string) ([]Job, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &File{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(file)
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(file)
+	default:
+		return nil, fmt.Errorf("unsupported jobs file format %q (use .yaml, .yml or .json)", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing jobs file %s: %w", path, err)
+	}
+	if len(file.Jobs) == 0 {
+		return nil, fmt.Errorf("jobs file %s has no jobs", path)
+	}
+
+	names := make(map[string]bool)
+	outputs := make(map[string]string)
+	for i := range file.Jobs {
+		job := &file.Jobs[i]
+		if job.Query != "" && !filepath.IsAbs(job.Query) {
+			job.Query = filepath.Join(filepath.Dir(path), job.Query)
+		}
+		if err := job.validate(); err != nil {
+			return nil, fmt.Errorf("jobs file %s: %w", path, err)
+		}
+		if names[job.Name] {
+			return nil, fmt.Errorf("jobs file %s: duplicate job name %q", path, job.Name)
+		}
+		names[job.Name] = true
+		output := filepath.Clean(job.Output)
+		if other, ok := outputs[output]; ok {
+			return nil, fmt.Errorf("jobs file %s: jobs %q and %q share the output %s", path, other, job.Name, job.Output)
+		}
+		outputs[output] = job.Name
+	}
+	return file.Jobs, nil
+}
+
+// CheckDestinations falla si dos trabajos escriben en el mismo prefijo de S3
+// o la misma plantilla de SFTP. Se comparan los destinos resueltos con base
+// (ver Config), de modo que el default de un trabajo tampoco puede coincidir
+// con el destino explícito de otro.
+func CheckDestinations(jobs []Job, base *config.Config) error {
+	s3Prefixes := make(map[string]string)
+	sftpPaths := make(map[string]string)
+	for i := range jobs {
+		cfg := jobs[i].Config(base)
+		var err error
+		switch cfg.Storage {
+		case "s3":
+			err = unique(s3Prefixes, strings.Trim(cfg.S3Prefix, "/"), jobs[i].Name, "s3Prefix")
+		case "sftp":
+			err = unique(sftpPaths, path.Clean(cfg.SFTPPathTemplate), jobs[i].Name, "sftpPath")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unique registra en seen que el trabajo name usa value y falla si otro
+// trabajo ya lo usaba.
+func unique(seen map[string]string, value, name, field string) error {
+	if other, ok := seen[value]; ok {
+		return fmt.Errorf("jobs %q and %q share the %s %s", other, name, field, value)
+	}
+	seen[value] = name
+	return nil
+}
+
+func (j *Job) validate() error {
+	if !validName.MatchString(j.Name) {
+		return fmt.Errorf("invalid job name %q (letters, digits, '.', '_' and '-')", j.Name)
+	}
+	if j.Timezone != "" {
+		if _, err := time.LoadLocation(j.Timezone); err != nil {
+			return fmt.Errorf("job %q: invalid timezone %q: %w", j.Name, j.Timezone, err)
+		}
+	}
+	if _, err := cron.ParseStandard(j.Spec()); err != nil {
+		return fmt.Errorf("job %q: invalid schedule %q: %w", j.Name, j.Schedule, err)
+	}
+	if j.Output == "" {
+		return fmt.Errorf("job %q: output is required", j.Name)
+	}
+	switch j.Storage {
+	case "", "local", "s3", "sftp":
+	default:
+		return fmt.Errorf("job %q: unknown storage %q (use local, s3 or sftp)", j.Name, j.Storage)
+	}
+	if j.DownloadWorkers < 0 || j.BatchWorkers < 0 || j.ActiveJobs < 0 || j.QueryWorkers < 0 {
+		return fmt.Errorf("job %q: concurrency limits cannot be negative", j.Name)
+	}
+	for _, d := range []struct {
+		name, value string
+		target      **time.Duration
+	}{{"overlap", j.Overlap, &j.overlap}, {"delay", j.Delay, &j.delay}} {
+		if d.value == "" {
+			continue
+		}
+		value, err := time.ParseDuration(d.value)
+		if err != nil || value < 0 {
+			return fmt.Errorf("job %q: invalid %s %q (use a duration like 2h)", j.Name, d.name, d.value)
+		}
+		*d.target = &value
+	}
+	return nil
+}
+
+// Spec devuelve la expresión cron con su huso horario.
+func (j *Job) Spec() string {
+	if j.Timezone != "" {
+		return "CRON_TZ=" + j.Timezone + " " + j.Schedule
+	}
+	return j.Schedule
+}
+
+// QueryOptions devuelve las opciones de la consulta del trabajo, sin
+// intervalo (lo pone el watermark).
+func (j *Job) QueryOptions() query.QueryOptions {
+	return query.QueryOptions{
+		DefinitionFile:       j.Query,
+		MediaTypes:           j.Media,
+		DivisionID:           j.Division,
+		OriginatingDirection: j.Direction,
+	}
+}
+
+// insertDir agrega la carpeta dir a template después de su prefijo fijo (las
+// carpetas iniciales sin variables, incluida la raíz de una ruta absoluta):
+// "/upload/{folder}/{file}" queda "/upload/dir/{folder}/{file}".
+func insertDir(template, dir string) string {
+	parts := strings.Split(template, "/")
+	fixed := 0
+	for fixed < len(parts)-1 && !strings.Contains(parts[fixed], "{") {
+		fixed++
+	}
+	prefix := strings.Join(parts[:fixed], "/")
+	if fixed == 1 && parts[0] == "" {
+		prefix = "/"
+	}
+	return path.Join(prefix, dir, strings.Join(parts[fixed:], "/"))
+}
+
+// Config devuelve una copia de base con los valores del trabajo. Journal,
+// índice y watermark quedan siempre dentro de Output, para que dos trabajos
+// nunca compartan estado. Por lo mismo, sin S3Prefix ni SFTPPath propios el
+// destino remoto es el general dentro de una carpeta con el nombre del
+// trabajo.
+func (j *Job) Config(base *config.Config) *config.Config {
+	cfg := *base
+	cfg.DownloadPath = j.Output
+	cfg.JournalPath, cfg.IndexPath, cfg.WatermarkPath = "", "", ""
+	if j.Storage != "" {
+		cfg.Storage = j.Storage
+	}
+	cfg.S3Prefix = j.S3Prefix
+	if j.S3Prefix == "" {
+		cfg.S3Prefix = path.Join(base.S3Prefix, j.Name)
+	}
+	cfg.SFTPPathTemplate = j.SFTPPath
+	if j.SFTPPath == "" {
+		template := base.SFTPPathTemplate
+		if template == "" {
+			template = storage.DefaultSFTPPathTemplate
+		}
+		cfg.SFTPPathTemplate = insertDir(template, j.Name)
+	}
+	if j.DownloadWorkers > 0 {
+		cfg.MaxDownloadWorkers = j.DownloadWorkers
+	}
+	if j.BatchWorkers > 0 {
+		cfg.BatchWorkers = j.BatchWorkers
+	}
+	if j.ActiveJobs > 0 {
+		cfg.MaxActiveJobs = j.ActiveJobs
+	}
+	if j.QueryWorkers > 0 {
+		cfg.QueryWorkers = j.QueryWorkers
+	}
+	if j.overlap != nil {
+		cfg.WatermarkOverlap = *j.overlap
+	}
+	if j.delay != nil {
+		cfg.WatermarkDelay = *j.delay
+	}
+	return &cfg
+}
@@ -0,0 +1,143 @@
+package schedule
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/goDownloadRecording/config"
+)
+
+func writeJobs(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "jobs.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadRejectsSharedDestinations(t *testing.T) {
+	tests := []struct {
+		name, jobs, want string
+	}{
+		{"output", `
+jobs:
+  - {name: a, schedule: "@daily", output: ./out}
+  - {name: b, schedule: "@daily", output: ./out/}
+`, "share the output"},
+		{"name", `
+jobs:
+  - {name: a, schedule: "@daily", output: ./a}
+  - {name: a, schedule: "@daily", output: ./b}
+`, "duplicate job name"},
+		{"schedule", `
+jobs:
+  - {name: a, schedule: "every day", output: ./a}
+`, "invalid schedule"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Load(writeJobs(t, test.jobs))
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got %v, want an error with %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckDestinations(t *testing.T) {
+	base := &config.Config{S3Prefix: "genesys", SFTPPathTemplate: "/upload/{folder}/{file}"}
+	tests := []struct {
+		name, jobs, want string
+	}{
+		{"s3Prefix", `
+jobs:
+  - {name: a, schedule: "@daily", output: ./a, storage: s3, s3Prefix: exports/qa}
+  - {name: b, schedule: "@daily", output: ./b, storage: s3, s3Prefix: /exports/qa/}
+`, "share the s3Prefix"},
+		{"sftpPath", `
+jobs:
+  - {name: a, schedule: "@daily", output: ./a, storage: sftp, sftpPath: "qa/{folder}/{file}"}
+  - {name: b, schedule: "@daily", output: ./b, storage: sftp, sftpPath: "qa/{folder}/{file}"}
+`, "share the sftpPath"},
+		{"s3Prefix por default", `
+jobs:
+  - {name: a, schedule: "@daily", output: ./a, storage: s3}
+  - {name: b, schedule: "@daily", output: ./b, storage: s3, s3Prefix: genesys/a}
+`, "share the s3Prefix"},
+		{"sftpPath por default", `
+jobs:
+  - {name: a, schedule: "@daily", output: ./a, storage: sftp, sftpPath: "/upload/b/{folder}/{file}"}
+  - {name: b, schedule: "@daily", output: ./b, storage: sftp}
+`, "share the sftpPath"},
+		{"distintos", `
+jobs:
+  - {name: a, schedule: "@daily", output: ./a, storage: s3}
+  - {name: b, schedule: "@daily", output: ./b, storage: s3, s3Prefix: genesys/b/extra}
+  - {name: c, schedule: "@daily", output: ./c, storage: sftp, sftpPath: "/upload/a/{folder}/{file}"}
+  - {name: d, schedule: "@daily", output: ./d, s3Prefix: genesys/a}
+`, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			jobs, err := Load(writeJobs(t, test.jobs))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = CheckDestinations(jobs, base)
+			if test.want == "" && err != nil {
+				t.Errorf("got %v", err)
+			}
+			if test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)) {
+				t.Errorf("got %v, want an error with %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestConfigDestinations(t *testing.T) {
+	jobs, err := Load(writeJobs(t, `
+jobs:
+  - {name: qa, schedule: "@daily", output: ./qa, downloadWorkers: 2}
+  - {name: sales, schedule: "@daily", output: ./sales, s3Prefix: compliance/sales, sftpPath: "sales/{file}"}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := &config.Config{DownloadPath: "./recordings", JournalPath: "journal.jsonl", S3Prefix: "genesys", MaxDownloadWorkers: 8}
+
+	qa := jobs[0].Config(base)
+	if qa.S3Prefix != "genesys/qa" || qa.SFTPPathTemplate != "qa/{folder}/{file}" {
+		t.Errorf("qa: got prefix %q and sftp path %q", qa.S3Prefix, qa.SFTPPathTemplate)
+	}
+	if qa.DownloadPath != "./qa" || qa.JournalPath != "" || qa.MaxDownloadWorkers != 2 {
+		t.Errorf("qa: got %+v", qa)
+	}
+
+	sales := jobs[1].Config(base)
+	if sales.S3Prefix != "compliance/sales" || sales.SFTPPathTemplate != "sales/{file}" || sales.MaxDownloadWorkers != 8 {
+		t.Errorf("sales: got %+v", sales)
+	}
+	if base.S3Prefix != "genesys" || base.DownloadPath != "./recordings" {
+		t.Error("Config modified the base configuration")
+	}
+}
+
+func TestConfigSFTPTemplate(t *testing.T) {
+	job := Job{Name: "qa"}
+	tests := []struct{ base, want string }{
+		{"", "qa/{folder}/{file}"},
+		{"{folder}/{file}", "qa/{folder}/{file}"},
+		{"/{folder}/{file}", "/qa/{folder}/{file}"},
+		{"/upload/{folder}/{file}", "/upload/qa/{folder}/{file}"},
+		{"upload/grabaciones/{date}/{file}", "upload/grabaciones/qa/{date}/{file}"},
+		{"/upload/{file}", "/upload/qa/{file}"},
+	}
+	for _, test := range tests {
+		if got := job.Config(&config.Config{SFTPPathTemplate: test.base}).SFTPPathTemplate; got != test.want {
+			t.Errorf("%q: got %q, want %q", test.base, got, test.want)
+		}
+	}
+}
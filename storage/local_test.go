@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"testing"
+)
+
+func sha(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// read devuelve el contenido de key en backend.
+func read(t *testing.T, backend Backend, key string) string {
+	t.Helper()
+	reader, err := backend.Open(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// assertMissing falla si key existe en backend.
+func assertMissing(t *testing.T, backend Backend, key string) {
+	t.Helper()
+	if _, exists, err := backend.Stat(key); err != nil || exists {
+		t.Fatalf("%s: got exists %v, %v", key, exists, err)
+	}
+}
+
+// testBackend prueba el contrato de Backend que comparten todos los
+// backends. Las claves van bajo la carpeta dir.
+func testBackend(t *testing.T, backend Backend, dir string) {
+	key := dir + "/250601-c1/r1.mp3"
+
+	// El objeto sólo aparece con Commit
+	obj, err := backend.Create(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chunk := range []string{"audio ", "de ", "prueba"} {
+		if _, err := obj.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if obj.Size() != 15 || obj.SHA256() != sha("audio de prueba") {
+		t.Errorf("got size %d and sha %s", obj.Size(), obj.SHA256())
+	}
+	assertMissing(t, backend, key)
+	if err := obj.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if size, exists, err := backend.Stat(key); err != nil || !exists || size != 15 {
+		t.Fatalf("after commit: got %d, %v, %v", size, exists, err)
+	}
+	if got := read(t, backend, key); got != "audio de prueba" {
+		t.Errorf("got content %q", got)
+	}
+
+	// Abort descarta lo escrito y no toca el objeto publicado
+	obj, err = backend.Create(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := obj.Write([]byte("otro contenido")); err != nil {
+		t.Fatal(err)
+	}
+	if err := obj.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(t, backend, key); got != "audio de prueba" {
+		t.Errorf("after abort: got content %q", got)
+	}
+	aborted := dir + "/250601-c1/r2.mp3"
+	obj, err = backend.Create(aborted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := obj.Write([]byte("descartado")); err != nil {
+		t.Fatal(err)
+	}
+	if err := obj.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	assertMissing(t, backend, aborted)
+
+	// Un objeto vacío también se publica
+	empty := dir + "/250601-c1/r3.mp3"
+	obj, err = backend.Create(empty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := obj.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if size, exists, err := backend.Stat(empty); err != nil || !exists || size != 0 {
+		t.Fatalf("empty object: got %d, %v, %v", size, exists, err)
+	}
+
+	sidecar := dir + "/250601-c1/r1.json"
+	if err := backend.WriteFile(sidecar, []byte(`{"kind":"recording"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(t, backend, sidecar); got != `{"kind":"recording"}` {
+		t.Errorf("got sidecar %q", got)
+	}
+	// WriteFile reemplaza el objeto entero y no deja el .part
+	if err := backend.WriteFile(sidecar, []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(t, backend, sidecar); got != `{}` {
+		t.Errorf("got rewritten sidecar %q", got)
+	}
+	assertMissing(t, backend, sidecar+".part")
+
+	for _, name := range []string{key, empty, sidecar} {
+		if err := backend.Remove(name); err != nil {
+			t.Fatal(err)
+		}
+		assertMissing(t, backend, name)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	testBackend(t, NewLocal(t.TempDir()), "local")
+}
+
+func TestLocalResume(t *testing.T) {
+	backend := NewLocal(t.TempDir())
+	key := "250601-c1/r1.mp3"
+
+	// Close conserva el .part y Create continúa desde su final
+	obj, err := backend.Create(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := obj.Write([]byte("primera ")); err != nil {
+		t.Fatal(err)
+	}
+	if err := obj.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(backend.Path(key) + ".part"); err != nil {
+		t.Fatalf("partial download was not kept: %v", err)
+	}
+	assertMissing(t, backend, key)
+
+	obj, err = backend.Create(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.Offset() != 8 {
+		t.Fatalf("got offset %d, want 8", obj.Offset())
+	}
+	if _, err := obj.Write([]byte("parte")); err != nil {
+		t.Fatal(err)
+	}
+	if obj.SHA256() != sha("primera parte") {
+		t.Error("the hash does not cover the resumed content")
+	}
+	if err := obj.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(t, backend, key); got != "primera parte" {
+		t.Errorf("got content %q", got)
+	}
+	if _, err := os.Stat(backend.Path(key) + ".part"); !os.IsNotExist(err) {
+		t.Errorf("the .part file was left behind: %v", err)
+	}
+
+	// Reset vuelve a empezar de cero y Abort borra el .part
+	obj, err = backend.Create("250601-c1/r2.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj.Write([]byte("descartado"))
+	if err := obj.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if obj.Offset() != 0 || obj.SHA256() != sha("") {
+		t.Errorf("after reset: got offset %d and sha %s", obj.Offset(), obj.SHA256())
+	}
+	if err := obj.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(backend.Path("250601-c1/r2.mp3") + ".part"); !os.IsNotExist(err) {
+		t.Errorf("abort left the .part file: %v", err)
+	}
+}
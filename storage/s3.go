@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Options configura un backend S3-compatible (AWS S3, MinIO, etc.).
+type S3Options struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+}
+
+// S3 guarda los objetos en un bucket S3-compatible. Las grabaciones se
+// suben en streaming (multipart) sin pasar por el disco local.
+type S3 struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3 conecta con el endpoint y comprueba que el bucket exista.
+func NewS3(opts S3Options) (*S3, error) {
+	client, err := minio.New(opts.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(opts.AccessKey, opts.SecretKey, ""),
+		Secure: opts.UseSSL,
+		Region: opts.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := client.BucketExists(context.Background(), opts.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.New("bucket " + opts.Bucket + " does not exist")
+	}
+	return &S3{client: client, bucket: opts.Bucket, prefix: opts.Prefix}, nil
+}
+
+func (s *S3) Name() string { return "s3" }
+
+func (s *S3) objectName(key string) string {
+	return path.Join(s.prefix, key)
+}
+
+func (s *S3) Create(key string) (Object, error) {
+	return &s3Object{backend: s, name: s.objectName(key), digest: newDigest()}, nil
+}
+
+func (s *S3) WriteFile(key string, data []byte) error {
+	_, err := s.client.PutObject(context.Background(), s.bucket, s.objectName(key), bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+	return err
+}
+
+func (s *S3) Open(key string) (io.ReadCloser, error) {
+	return s.client.GetObject(context.Background(), s.bucket, s.objectName(key), minio.GetObjectOptions{})
+}
+
+func (s *S3) Stat(key string) (int64, bool, error) {
+	info, err := s.client.StatObject(context.Background(), s.bucket, s.objectName(key), minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	return info.Size, true, nil
+}
+
+func (s *S3) Remove(key string) error {
+	return s.client.RemoveObject(context.Background(), s.bucket, s.objectName(key), minio.RemoveObjectOptions{})
+}
+
+// s3PartSize acota la memoria usada por cada subida en streaming: sin
+// tamaño conocido, minio-go calcula partes pensadas para objetos de 5 TiB.
+const s3PartSize = 16 << 20
+
+// errUploadAborted cancela la subida en curso de un objeto.
+var errUploadAborted = errors.New("upload aborted")
+
+// s3Object sube el contenido a medida que se escribe, a través de un pipe
+// hacia PutObject. La subida arranca con el primer Write y el objeto sólo
+// aparece en el bucket cuando se completa (Commit).
+type s3Object struct {
+	*digest
+	backend *S3
+	name    string
+	pipe    *io.PipeWriter
+	done    chan error
+}
+
+func (o *s3Object) start() {
+	reader, writer := io.Pipe()
+	o.pipe = writer
+	o.done = make(chan error, 1)
+	go func() {
+		_, err := o.backend.client.PutObject(context.Background(), o.backend.bucket, o.name, reader, -1, minio.PutObjectOptions{PartSize: s3PartSize})
+		reader.CloseWithError(err)
+		o.done <- err
+	}()
+}
+
+func (o *s3Object) Write(p []byte) (int, error) {
+	if o.pipe == nil {
+		o.start()
+	}
+	n, err := o.pipe.Write(p)
+	o.digest.Write(p[:n])
+	return n, err
+}
+
+// Offset siempre es 0: una subida cortada no se puede continuar.
+func (o *s3Object) Offset() int64 { return 0 }
+
+func (o *s3Object) Reset() error {
+	o.cancel()
+	o.digest.reset()
+	return nil
+}
+
+func (o *s3Object) cancel() {
+	if o.pipe == nil {
+		return
+	}
+	o.pipe.CloseWithError(errUploadAborted)
+	<-o.done
+	o.pipe = nil
+}
+
+func (o *s3Object) Commit() error {
+	if o.pipe == nil {
+		// Objeto vacío
+		o.start()
+	}
+	o.pipe.Close()
+	err := <-o.done
+	o.pipe = nil
+	return err
+}
+
+func (o *s3Object) Close() error {
+	o.cancel()
+	return nil
+}
+
+func (o *s3Object) Abort() error {
+	o.cancel()
+	return nil
+}
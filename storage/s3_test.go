@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestS3 corre contra un servidor S3-compatible (p.ej. MinIO) sólo si se
+// configura TEST_S3_ENDPOINT, con TEST_S3_BUCKET (ya creado),
+// TEST_S3_ACCESS_KEY, TEST_S3_SECRET_KEY y TEST_S3_USE_SSL opcional:
+//
+//	docker run -p 9000:9000 minio/minio server /data
+//	TEST_S3_ENDPOINT=localhost:9000 TEST_S3_BUCKET=test TEST_S3_ACCESS_KEY=minioadmin TEST_S3_SECRET_KEY=minioadmin go test ./storage/
+func TestS3(t *testing.T) {
+	endpoint := os.Getenv("TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("TEST_S3_ENDPOINT not set")
+	}
+	backend, err := NewS3(S3Options{
+		Endpoint:  endpoint,
+		Region:    os.Getenv("TEST_S3_REGION"),
+		Bucket:    os.Getenv("TEST_S3_BUCKET"),
+		Prefix:    "storage-test",
+		AccessKey: os.Getenv("TEST_S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("TEST_S3_SECRET_KEY"),
+		UseSSL:    os.Getenv("TEST_S3_USE_SSL") == "true",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBackend(t, backend, fmt.Sprintf("run-%d", time.Now().UnixNano()))
+}
@@ -0,0 +1,413 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goDownloadRecording/layout"
+	"github.com/goDownloadRecording/logger"
+	"github.com/pkg/sftp"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTPOptions configura la entrega de grabaciones a un servidor SFTP.
+type SFTPOptions struct {
+	// Address es host[:puerto]; sin puerto se usa el 22.
+	Address string
+	User    string
+	// KeyFile es la clave privada (OpenSSH/PEM) con la que se autentica.
+	KeyFile       string
+	KeyPassphrase string
+	// KnownHostsFile valida la clave del servidor. Sólo si InsecureHostKey
+	// es true se acepta cualquier clave (pruebas).
+	KnownHostsFile  string
+	InsecureHostKey bool
+	// PathTemplate arma la ruta remota de cada objeto (ver RemotePath).
+	PathTemplate string
+	// Location es el huso horario de {date} (default el local).
+	Location *time.Location
+	// Retries acota los reintentos (con reconexión) de cada operación.
+	Retries int
+	// Context, si no es nil, corta la espera entre reintentos una vez
+	// cancelado.
+	Context context.Context
+}
+
+// DefaultSFTPPathTemplate deja la misma estructura que el storage local,
+// relativa al directorio de login.
+const DefaultSFTPPathTemplate = "{folder}/{file}"
+
+// sftpRetryBackoff es la espera inicial entre reintentos; se duplica en cada uno.
+const sftpRetryBackoff = 2 * time.Second
+
+// SFTP entrega los objetos a un servidor remoto por SFTP. Cada operación
+// se reintenta reconectando si la sesión se cae. Los backends de Scope
+// comparten la conexión.
+type SFTP struct {
+	*sftpConn
+	rec Recording
+}
+
+// sftpConn es la conexión con el servidor.
+type sftpConn struct {
+	opts   SFTPOptions
+	config *ssh.ClientConfig
+
+	mu     sync.Mutex
+	conn   *ssh.Client
+	client *sftp.Client
+}
+
+// NewSFTP prepara la autenticación por clave y comprueba que el servidor
+// responda.
+func NewSFTP(opts SFTPOptions) (*SFTP, error) {
+	if opts.PathTemplate == "" {
+		opts.PathTemplate = DefaultSFTPPathTemplate
+	}
+	if !strings.Contains(opts.PathTemplate, "{file}") && !strings.Contains(opts.PathTemplate, "{key}") {
+		return nil, fmt.Errorf("sftp path template %q must contain {file} or {key}", opts.PathTemplate)
+	}
+	if opts.Context == nil {
+		opts.Context = context.Background()
+	}
+	if opts.Location == nil {
+		opts.Location = time.Local
+	}
+	if _, _, err := net.SplitHostPort(opts.Address); err != nil {
+		opts.Address = net.JoinHostPort(opts.Address, "22")
+	}
+
+	key, err := os.ReadFile(opts.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading sftp key: %w", err)
+	}
+	var signer ssh.Signer
+	if opts.KeyPassphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(opts.KeyPassphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing sftp key: %w", err)
+	}
+
+	var hostKey ssh.HostKeyCallback
+	switch {
+	case opts.InsecureHostKey:
+		hostKey = ssh.InsecureIgnoreHostKey()
+	case opts.KnownHostsFile != "":
+		if hostKey, err = knownhosts.New(opts.KnownHostsFile); err != nil {
+			return nil, fmt.Errorf("loading known hosts: %w", err)
+		}
+	default:
+		return nil, errors.New("sftp requires a known hosts file to verify the server key")
+	}
+
+	conn := &sftpConn{
+		opts: opts,
+		config: &ssh.ClientConfig{
+			User:            opts.User,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKey,
+			Timeout:         30 * time.Second,
+		},
+	}
+	if _, err := conn.session(); err != nil {
+		return nil, err
+	}
+	return &SFTP{sftpConn: conn}, nil
+}
+
+func (s *SFTP) Name() string { return "sftp" }
+
+// Scope devuelve un backend sobre la misma conexión que arma las rutas con
+// la fecha y la conversación de rec.
+func (s *SFTP) Scope(rec Recording) Backend {
+	return &SFTP{sftpConn: s.sftpConn, rec: rec}
+}
+
+// session devuelve la sesión SFTP abierta, conectando si hace falta.
+func (s *sftpConn) session() (*sftp.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		return s.client, nil
+	}
+
+	conn, err := ssh.Dial("tcp", s.opts.Address, s.config)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to sftp server %s: %w", s.opts.Address, err)
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("starting sftp session: %w", err)
+	}
+	s.conn, s.client = conn, client
+	return client, nil
+}
+
+// drop descarta la sesión si sigue siendo client, para que la próxima
+// operación reconecte.
+func (s *sftpConn) drop(client *sftp.Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != client || client == nil {
+		return
+	}
+	s.client.Close()
+	s.conn.Close()
+	s.client, s.conn = nil, nil
+}
+
+// Close cierra la conexión con el servidor.
+func (s *sftpConn) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == nil {
+		return nil
+	}
+	s.client.Close()
+	err := s.conn.Close()
+	s.client, s.conn = nil, nil
+	return err
+}
+
+// retry ejecuta op hasta Retries veces más, reconectando entre intentos.
+// Los errores del servidor (permisos, archivo inexistente) no se reintentan,
+// y si se cancela opts.Context se deja de esperar y se devuelve el último error.
+func (s *sftpConn) retry(name string, op func(*sftp.Client) error) error {
+	delay := sftpRetryBackoff
+	var err error
+	for attempt := 0; attempt <= s.opts.Retries; attempt++ {
+		if attempt > 0 {
+			logger.Log.Warn("Retrying sftp operation",
+				zap.String("Operation", name),
+				zap.Int("Attempt", attempt),
+				zap.Duration("Backoff", delay),
+				zap.Error(err))
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-s.opts.Context.Done():
+				timer.Stop()
+				return err
+			}
+			delay *= 2
+		}
+
+		var client *sftp.Client
+		if client, err = s.session(); err != nil {
+			continue
+		}
+		if err = op(client); err == nil {
+			return nil
+		}
+		var status *sftp.StatusError
+		if errors.As(err, &status) || errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+			return err
+		}
+		s.drop(client)
+	}
+	return err
+}
+
+// RemotePath arma la ruta remota de key según PathTemplate. Variables:
+// {key} (clave completa), {folder} y {file} (carpeta y nombre de la clave),
+// {date} (inicio de la conversación como yymmdd) y {conversationId}; estas
+// dos salen de la grabación de Scope y quedan "unknown" sin ella.
+func (s *SFTP) RemotePath(key string) string {
+	folder, file := path.Split(key)
+	folder = strings.TrimSuffix(folder, "/")
+	date, conversationID := "unknown", "unknown"
+	if !s.rec.Start.IsZero() {
+		date = s.rec.Start.In(s.opts.Location).Format("060102")
+	}
+	if s.rec.ConversationID != "" {
+		conversationID = layout.Sanitize(s.rec.ConversationID)
+	}
+
+	remote := strings.NewReplacer(
+		"{key}", key,
+		"{folder}", folder,
+		"{file}", file,
+		"{date}", date,
+		"{conversationId}", conversationID,
+	).Replace(s.opts.PathTemplate)
+	return path.Clean(remote)
+}
+
+func (s *SFTP) Create(key string) (Object, error) {
+	return &sftpObject{backend: s, path: s.RemotePath(key), digest: newDigest()}, nil
+}
+
+// WriteFile sube a "<ruta>.part" y lo renombra al terminar, como Create.
+func (s *SFTP) WriteFile(key string, data []byte) error {
+	remote := s.RemotePath(key)
+	return s.retry("write "+remote, func(client *sftp.Client) error {
+		if err := client.MkdirAll(path.Dir(remote)); err != nil {
+			return err
+		}
+		file, err := client.Create(remote + ".part")
+		if err != nil {
+			return err
+		}
+		if _, err := file.Write(data); err != nil {
+			file.Close()
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+		return rename(client, remote+".part", remote)
+	})
+}
+
+func (s *SFTP) Open(key string) (io.ReadCloser, error) {
+	var file *sftp.File
+	err := s.retry("open", func(client *sftp.Client) error {
+		var err error
+		file, err = client.Open(s.RemotePath(key))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (s *SFTP) Stat(key string) (int64, bool, error) {
+	var info os.FileInfo
+	err := s.retry("stat", func(client *sftp.Client) error {
+		var err error
+		info, err = client.Stat(s.RemotePath(key))
+		return err
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return info.Size(), true, nil
+}
+
+func (s *SFTP) Remove(key string) error {
+	return s.retry("remove", func(client *sftp.Client) error {
+		return client.Remove(s.RemotePath(key))
+	})
+}
+
+// sftpObject sube el contenido a "<ruta>.part" a medida que se escribe y lo
+// renombra al hacer Commit, de modo que el receptor nunca ve un archivo a
+// medias con el nombre definitivo.
+type sftpObject struct {
+	*digest
+	backend *SFTP
+	path    string
+	client  *sftp.Client
+	file    *sftp.File
+}
+
+func (o *sftpObject) partPath() string { return o.path + ".part" }
+
+func (o *sftpObject) open() error {
+	return o.backend.retry("create "+o.partPath(), func(client *sftp.Client) error {
+		if err := client.MkdirAll(path.Dir(o.path)); err != nil {
+			return err
+		}
+		file, err := client.Create(o.partPath())
+		if err != nil {
+			return err
+		}
+		o.client, o.file = client, file
+		return nil
+	})
+}
+
+func (o *sftpObject) Write(p []byte) (int, error) {
+	if o.file == nil {
+		if err := o.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := o.file.Write(p)
+	o.digest.Write(p[:n])
+	if err != nil {
+		// La sesión puede haberse caído: el próximo intento reconecta
+		o.backend.drop(o.client)
+	}
+	return n, err
+}
+
+// Offset siempre es 0: una subida cortada se vuelve a hacer completa.
+func (o *sftpObject) Offset() int64 { return 0 }
+
+func (o *sftpObject) Reset() error {
+	o.closeFile()
+	o.digest.reset()
+	return nil
+}
+
+func (o *sftpObject) closeFile() error {
+	if o.file == nil {
+		return nil
+	}
+	err := o.file.Close()
+	o.file = nil
+	return err
+}
+
+func (o *sftpObject) Commit() error {
+	if o.file == nil {
+		// Objeto vacío
+		if err := o.open(); err != nil {
+			return err
+		}
+	}
+	if err := o.closeFile(); err != nil {
+		o.backend.drop(o.client)
+		return err
+	}
+	return o.backend.retry("rename "+o.path, func(client *sftp.Client) error {
+		return rename(client, o.partPath(), o.path)
+	})
+}
+
+// rename mueve from a to reemplazando to si existe. PosixRename lo hace de
+// forma atómica; sin esa extensión se borra antes y se usa el rename
+// estándar.
+func rename(client *sftp.Client, from, to string) error {
+	if _, ok := client.HasExtension("posix-rename@openssh.com"); ok {
+		return client.PosixRename(from, to)
+	}
+	if err := client.Remove(to); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return client.Rename(from, to)
+}
+
+func (o *sftpObject) Close() error {
+	return o.Abort()
+}
+
+func (o *sftpObject) Abort() error {
+	o.closeFile()
+	return o.backend.retry("remove "+o.partPath(), func(client *sftp.Client) error {
+		err := client.Remove(o.partPath())
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	})
+}
@@ -0,0 +1,279 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goDownloadRecording/logger"
+	"github.com/pkg/sftp"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+)
+
+func init() {
+	if logger.Log == nil {
+		logger.Log = zap.NewNop()
+	}
+}
+
+func TestRemotePath(t *testing.T) {
+	start := time.Date(2025, 6, 2, 1, 30, 0, 0, time.UTC)
+	argentina := time.FixedZone("-03", -3*60*60)
+	tests := []struct {
+		name, template, key string
+		location            *time.Location
+		rec                 Recording
+		want                string
+	}{
+		{"default", DefaultSFTPPathTemplate, "250602-c1/r1.mp3", time.UTC, Recording{}, "250602-c1/r1.mp3"},
+		{"fecha y conversación", "/entrada/{date}/{conversationId}/{file}", "250602-c1/r1.mp3", time.UTC, Recording{ConversationID: "c1", Start: start}, "/entrada/250602/c1/r1.mp3"},
+		{"huso horario", "/entrada/{date}/{file}", "x/r1.mp3", argentina, Recording{ConversationID: "c1", Start: start}, "/entrada/250601/r1.mp3"},
+		{"carpeta de otra plantilla", "{date}/{conversationId}/{file}", "2025/06/01/ventas-norte/r1.mp3", time.UTC, Recording{ConversationID: "c1", Start: start}, "250602/c1/r1.mp3"},
+		{"sin grabación", "{date}/{conversationId}/{file}", "250602-c1/r1.mp3", time.UTC, Recording{}, "unknown/unknown/r1.mp3"},
+		{"conversación saneada", "{conversationId}/{file}", "x/r1.mp3", time.UTC, Recording{ConversationID: "../c1"}, "_c1/r1.mp3"},
+		{"clave completa", "/entrada/{key}", "2025/06/01/r1.mp3", time.UTC, Recording{}, "/entrada/2025/06/01/r1.mp3"},
+		{"carpeta", "/entrada/{folder}/meta/{file}", "250602-c1/r1.json", time.UTC, Recording{}, "/entrada/250602-c1/meta/r1.json"},
+		{"ruta limpia", "/entrada//{folder}/./{file}", "250602-c1/r1.mp3", time.UTC, Recording{}, "/entrada/250602-c1/r1.mp3"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend := &SFTP{sftpConn: &sftpConn{opts: SFTPOptions{PathTemplate: test.template, Location: test.location}}}
+			scoped := Scope(backend, test.rec).(*SFTP)
+			if got := scoped.RemotePath(test.key); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// sftpServer es un servidor SSH con el subsistema SFTP en el proceso, que
+// sirve el directorio de la prueba.
+type sftpServer struct {
+	addr    string
+	keyFile string
+
+	listener net.Listener
+	mu       sync.Mutex
+	conns    []net.Conn
+}
+
+func startSFTPServer(t *testing.T, root string) *sftpServer {
+	t.Helper()
+	_, hostPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(hostPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPublic, clientPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorized, err := ssh.NewPublicKey(clientPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(clientPrivate, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), authorized.Marshal()) {
+				return nil, errors.New("unknown key")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &sftpServer{addr: listener.Addr().String(), keyFile: keyFile, listener: listener}
+	t.Cleanup(server.stop)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.mu.Lock()
+			server.conns = append(server.conns, conn)
+			server.mu.Unlock()
+			go serveSSH(conn, config, root)
+		}
+	}()
+	return server
+}
+
+// dropConnections corta las sesiones abiertas, como una caída de la red.
+func (s *sftpServer) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *sftpServer) stop() {
+	s.listener.Close()
+	s.dropConnections()
+}
+
+func serveSSH(conn net.Conn, config *ssh.ServerConfig, root string) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for request := range requests {
+				ok := request.Type == "subsystem" && len(request.Payload) > 4 && string(request.Payload[4:]) == "sftp"
+				request.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+				go func() {
+					defer channel.Close()
+					server, err := sftp.NewServer(channel, sftp.WithServerWorkingDirectory(root))
+					if err != nil {
+						return
+					}
+					server.Serve()
+				}()
+			}
+		}()
+	}
+}
+
+func newTestSFTP(t *testing.T, ctx context.Context, server *sftpServer, template string) *SFTP {
+	t.Helper()
+	backend, err := NewSFTP(SFTPOptions{
+		Address:         server.addr,
+		User:            "test",
+		KeyFile:         server.keyFile,
+		InsecureHostKey: true,
+		PathTemplate:    template,
+		Location:        time.UTC,
+		Retries:         3,
+		Context:         ctx,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { backend.Close() })
+	return backend
+}
+
+func TestSFTP(t *testing.T) {
+	root := t.TempDir()
+	server := startSFTPServer(t, root)
+	testBackend(t, newTestSFTP(t, context.Background(), server, filepath.ToSlash(root)+"/{folder}/{file}"), "sftp")
+}
+
+func TestSFTPCommitRenamesPart(t *testing.T) {
+	root := t.TempDir()
+	server := startSFTPServer(t, root)
+	backend := newTestSFTP(t, context.Background(), server, filepath.ToSlash(root)+"/entrada/{date}/{conversationId}/{file}")
+	scoped := Scope(backend, Recording{ConversationID: "c1", Start: time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)})
+	remote := filepath.Join(root, "entrada", "250601", "c1", "r1.mp3")
+
+	obj, err := scoped.Create("2025/06/01/ventas/r1.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := obj.Write([]byte("audio")); err != nil {
+		t.Fatal(err)
+	}
+	// Mientras se sube, el receptor sólo ve el .part
+	if _, err := os.Stat(remote + ".part"); err != nil {
+		t.Fatalf("upload is not going to the .part file: %v", err)
+	}
+	if _, err := os.Stat(remote); !os.IsNotExist(err) {
+		t.Fatalf("final name visible before commit: %v", err)
+	}
+	if err := obj.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(remote)
+	if err != nil || string(data) != "audio" {
+		t.Fatalf("got %q, %v", data, err)
+	}
+	if _, err := os.Stat(remote + ".part"); !os.IsNotExist(err) {
+		t.Errorf("the .part file was left behind: %v", err)
+	}
+
+	// Un nuevo Commit reemplaza el archivo publicado
+	obj, err = scoped.Create("2025/06/01/ventas/r1.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj.Write([]byte("audio nuevo"))
+	if err := obj.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(remote); string(data) != "audio nuevo" {
+		t.Errorf("got %q after replacing", data)
+	}
+}
+
+func TestSFTPReconnects(t *testing.T) {
+	root := t.TempDir()
+	server := startSFTPServer(t, root)
+	backend := newTestSFTP(t, context.Background(), server, filepath.ToSlash(root)+"/{folder}/{file}")
+	if err := backend.WriteFile("c1/r1.json", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+
+	server.dropConnections()
+	if size, exists, err := backend.Stat("c1/r1.json"); err != nil || !exists || size != 2 {
+		t.Fatalf("after a dropped connection: got %d, %v, %v", size, exists, err)
+	}
+}
+
+func TestSFTPRetryCanceled(t *testing.T) {
+	root := t.TempDir()
+	server := startSFTPServer(t, root)
+	ctx, cancel := context.WithCancel(context.Background())
+	backend := newTestSFTP(t, ctx, server, filepath.ToSlash(root)+"/{folder}/{file}")
+
+	// Sin servidor, cada reintento esperaría el backoff (2s, 4s, 8s)
+	server.stop()
+	cancel()
+	started := time.Now()
+	if _, _, err := backend.Stat("c1/r1.json"); err == nil {
+		t.Fatal("expected an error without a server")
+	}
+	if elapsed := time.Since(started); elapsed > sftpRetryBackoff {
+		t.Errorf("canceled retry took %s", elapsed)
+	}
+}
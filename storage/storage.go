@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"time"
+)
+
+// Backend es el destino donde se guardan las grabaciones y su metadata. Las
+// claves usan "/" como separador y son relativas a la raíz del backend.
+type Backend interface {
+	// Name identifica el backend en logs y metadata (p.ej. "local", "s3").
+	Name() string
+	// Create abre un objeto para escribir key. El contenido sólo queda
+	// visible bajo key al llamar Commit.
+	Create(key string) (Object, error)
+	// WriteFile guarda un objeto chico (metadata) de una sola vez. Como con
+	// Create, el contenido queda visible completo o no queda.
+	WriteFile(key string, data []byte) error
+	// Open abre un objeto existente para lectura.
+	Open(key string) (io.ReadCloser, error)
+	// Stat devuelve el tamaño de key y si existe.
+	Stat(key string) (int64, bool, error)
+	// Remove borra key.
+	Remove(key string) error
+}
+
+// Recording describe la grabación a la que pertenecen los objetos que se
+// guardan, para los backends que la usan en sus rutas (ver SFTP.RemotePath).
+type Recording struct {
+	ConversationID string
+	// Start es el inicio de la conversación (cero si no se conoce)
+	Start time.Time
+}
+
+// Scoper es un backend cuyas rutas dependen de la grabación.
+type Scoper interface {
+	// Scope devuelve el mismo backend, con las rutas de los objetos de rec.
+	Scope(rec Recording) Backend
+}
+
+// Scope devuelve backend con las rutas de los objetos de rec, o backend
+// mismo si sus rutas no dependen de la grabación.
+func Scope(backend Backend, rec Recording) Backend {
+	if scoper, ok := backend.(Scoper); ok {
+		return scoper.Scope(rec)
+	}
+	return backend
+}
+
+// Object es un objeto en escritura. Calcula el SHA-256 de todo su contenido,
+// incluido lo que ya tuviera de un intento anterior.
+type Object interface {
+	io.Writer
+	// Offset devuelve cuántos bytes válidos tiene el objeto desde los que se
+	// puede continuar (0 si el backend no permite reanudar).
+	Offset() int64
+	// Reset descarta lo escrito para volver a empezar de cero.
+	Reset() error
+	// Size y SHA256 describen el contenido escrito hasta el momento.
+	Size() int64
+	SHA256() string
+	// Commit publica el objeto bajo su clave definitiva.
+	Commit() error
+	// Close libera el objeto sin publicarlo, conservando lo escrito si el
+	// backend puede reanudarlo más tarde.
+	Close() error
+	// Abort libera el objeto y descarta lo escrito.
+	Abort() error
+}
+
+// digest lleva la cuenta de tamaño y hash de lo escrito en un objeto.
+type digest struct {
+	hash hash.Hash
+	size int64
+}
+
+func newDigest() *digest {
+	return &digest{hash: sha256.New()}
+}
+
+func (d *digest) Write(p []byte) (int, error) {
+	d.hash.Write(p)
+	d.size += int64(len(p))
+	return len(p), nil
+}
+
+func (d *digest) reset() {
+	d.hash.Reset()
+	d.size = 0
+}
+
+func (d *digest) Size() int64 { return d.size }
+
+func (d *digest) SHA256() string { return hex.EncodeToString(d.hash.Sum(nil)) }
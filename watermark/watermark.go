@@ -0,0 +1,157 @@
+// Package watermark guarda hasta dónde llegó la sincronización incremental:
+// el fin del último intervalo descargado sin errores, y las grabaciones que
+// lo están reteniendo.
+package watermark
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// State es el contenido del archivo de watermark.
+type State struct {
+	// Watermark es el fin del último intervalo descargado completo.
+	Watermark time.Time `json:"watermark"`
+	// Interval es ese intervalo, en formato ISO-8601 (inicio/fin).
+	Interval      string    `json:"interval,omitempty"`
+	Conversations int       `json:"conversations"`
+	Recordings    int       `json:"recordings"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+	// Blocked cuenta, por grabación (o conversación sin metadata), los
+	// ciclos seguidos que quedó pendiente y retuvo el watermark.
+	Blocked map[string]int `json:"blocked,omitempty"`
+	// Skipped son las últimas grabaciones que el watermark dejó atrás sin
+	// descargar (ver Block).
+	Skipped []Skipped `json:"skipped,omitempty"`
+}
+
+// Skipped es una grabación (o una conversación, sin RecordingID) que quedó
+// pendiente en un ciclo.
+type Skipped struct {
+	RecordingID    string `json:"recordingId,omitempty"`
+	ConversationID string `json:"conversationId"`
+	// Start es el inicio de la conversación (cero si no se conoce)
+	Start     time.Time `json:"start,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Cycles    int       `json:"cycles,omitempty"`
+	SkippedAt time.Time `json:"skippedAt,omitempty"`
+}
+
+// maxSkipped acota la lista de Skipped que se guarda.
+const maxSkipped = 1000
+
+// Block registra un ciclo en el que quedó pendiente lo de pending: suma un
+// ciclo a cada elemento y olvida los que ya no están pendientes. Lo que ya
+// se salteó en un ciclo anterior (y se volvió a consultar porque el
+// watermark quedó detrás) no vuelve a retenerlo. Cada
+// elemento que llega a maxCycles ciclos (con maxCycles > 0) pasa a Skipped
+// y deja de retener el watermark; se devuelve en skipped. Los demás se
+// devuelven en blocking: el watermark sólo puede avanzar hasta el más
+// antiguo de ellos (ver Earliest).
+func (s *State) Block(pending []Skipped, maxCycles int, now time.Time) (skipped, blocking []Skipped) {
+	done := make(map[string]bool, len(s.Skipped))
+	for _, item := range s.Skipped {
+		done[item.key()] = true
+	}
+	blocked := make(map[string]int, len(pending))
+	for _, item := range pending {
+		if done[item.key()] {
+			continue
+		}
+		item.Cycles = s.Blocked[item.key()] + 1
+		if maxCycles > 0 && item.Cycles >= maxCycles {
+			item.SkippedAt = now
+			skipped = append(skipped, item)
+			continue
+		}
+		blocked[item.key()] = item.Cycles
+		blocking = append(blocking, item)
+	}
+	s.Blocked = blocked
+	if len(blocked) == 0 {
+		s.Blocked = nil
+	}
+	s.Skipped = append(s.Skipped, skipped...)
+	if len(s.Skipped) > maxSkipped {
+		s.Skipped = s.Skipped[len(s.Skipped)-maxSkipped:]
+	}
+	return skipped, blocking
+}
+
+// Earliest devuelve el inicio de conversación más antiguo de items. ok es
+// false si alguno no lo tiene: entonces no se sabe hasta dónde avanzar.
+func Earliest(items []Skipped) (earliest time.Time, ok bool) {
+	for _, item := range items {
+		if item.Start.IsZero() {
+			return time.Time{}, false
+		}
+		if earliest.IsZero() || item.Start.Before(earliest) {
+			earliest = item.Start
+		}
+	}
+	return earliest, true
+}
+
+func (item Skipped) key() string {
+	if item.RecordingID != "" {
+		return item.RecordingID
+	}
+	return item.ConversationID
+}
+
+// Load lee el watermark en path. Devuelve nil, nil si todavía no existe.
+func Load(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("parsing watermark %s: %w", path, err)
+	}
+	if state.Watermark.IsZero() {
+		return nil, fmt.Errorf("watermark %s has no watermark time", path)
+	}
+	return &state, nil
+}
+
+// Save guarda state en path. Se escribe y sincroniza un archivo temporal y
+// se renombra, de modo que un corte nunca deja un watermark a medio escribir.
+func Save(path string, state State) error {
+	if state.UpdatedAt.IsZero() {
+		state.UpdatedAt = time.Now().UTC()
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(data, '\n'))
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
@@ -0,0 +1,119 @@
+package watermark
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBlock(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	poison := Skipped{RecordingID: "r1", ConversationID: "c1", Error: "404"}
+	late := Skipped{RecordingID: "r2", ConversationID: "c2"}
+	conversation := Skipped{ConversationID: "c3"}
+
+	state := &State{}
+	for cycle := 1; cycle < 3; cycle++ {
+		if skipped, blocking := state.Block([]Skipped{poison}, 3, now); skipped != nil || len(blocking) != 1 {
+			t.Fatalf("cycle %d: skipped %+v, blocking %+v", cycle, skipped, blocking)
+		}
+		if state.Blocked["r1"] != cycle {
+			t.Fatalf("cycle %d: got blocked %v", cycle, state.Blocked)
+		}
+	}
+
+	// Cada elemento se saltea por su cuenta, aunque otro siga pendiente
+	skipped, blocking := state.Block([]Skipped{poison, late}, 3, now)
+	if len(skipped) != 1 || skipped[0].RecordingID != "r1" || skipped[0].Cycles != 3 || !skipped[0].SkippedAt.Equal(now) {
+		t.Fatalf("got skipped %+v", skipped)
+	}
+	if len(blocking) != 1 || blocking[0].RecordingID != "r2" || state.Blocked["r2"] != 1 || len(state.Blocked) != 1 {
+		t.Fatalf("got blocking %+v, blocked %v", blocking, state.Blocked)
+	}
+
+	// Lo ya salteado no vuelve a retener el watermark, y lo que ya no está
+	// pendiente se olvida
+	skipped, blocking = state.Block([]Skipped{poison, conversation}, 3, now)
+	if skipped != nil || len(blocking) != 1 || blocking[0].ConversationID != "c3" {
+		t.Fatalf("got skipped %+v, blocking %+v", skipped, blocking)
+	}
+	if _, ok := state.Blocked["r2"]; ok || state.Blocked["c3"] != 1 {
+		t.Fatalf("got blocked %v", state.Blocked)
+	}
+	state.Block([]Skipped{conversation}, 3, now)
+	state.Block([]Skipped{conversation}, 3, now)
+	if state.Blocked != nil || len(state.Skipped) != 2 || state.Skipped[0].Error != "404" || state.Skipped[1].ConversationID != "c3" {
+		t.Fatalf("got state %+v", state)
+	}
+}
+
+func TestBlockNewFailureEveryCycle(t *testing.T) {
+	// Una grabación que falla siempre y otra nueva en cada ciclo: el
+	// watermark avanza hasta la pendiente más vieja y las viejas se saltean
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	hour := func(h int) time.Time { return now.Add(time.Duration(h) * time.Hour) }
+	poison := Skipped{RecordingID: "r-poison", ConversationID: "c0", Start: hour(0)}
+
+	state := &State{Watermark: hour(0)}
+	pending := []Skipped{poison}
+	for cycle := 1; cycle <= 6; cycle++ {
+		pending = append(pending, Skipped{RecordingID: fmt.Sprintf("r%d", cycle), ConversationID: fmt.Sprintf("c%d", cycle), Start: hour(cycle)})
+		_, blocking := state.Block(pending, 3, hour(cycle))
+		limit, ok := Earliest(blocking)
+		if !ok || len(blocking) == 0 {
+			t.Fatalf("cycle %d: got blocking %+v", cycle, blocking)
+		}
+		if limit.After(state.Watermark) {
+			state.Watermark = limit
+		}
+		if cycle >= 3 && !state.Watermark.Equal(hour(cycle-1)) {
+			t.Fatalf("cycle %d: watermark %s did not advance", cycle, state.Watermark)
+		}
+	}
+	if len(state.Skipped) != 5 || state.Skipped[0].RecordingID != "r-poison" || len(state.Blocked) != 2 {
+		t.Errorf("got skipped %+v, blocked %v", state.Skipped, state.Blocked)
+	}
+}
+
+func TestBlockNeverSkips(t *testing.T) {
+	state := &State{}
+	for cycle := 0; cycle < 10; cycle++ {
+		if skipped, _ := state.Block([]Skipped{{RecordingID: "r1"}}, 0, time.Now()); skipped != nil {
+			t.Fatalf("skipped %+v with maxCycles 0", skipped)
+		}
+	}
+}
+
+func TestEarliest(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	earliest, ok := Earliest([]Skipped{{Start: now}, {Start: now.Add(-time.Hour)}, {Start: now.Add(time.Hour)}})
+	if !ok || !earliest.Equal(now.Add(-time.Hour)) {
+		t.Errorf("got %s, %v", earliest, ok)
+	}
+	if _, ok := Earliest([]Skipped{{Start: now}, {RecordingID: "r1"}}); ok {
+		t.Error("got ok with an item without start")
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "watermark.json")
+	if state, err := Load(path); state != nil || err != nil {
+		t.Fatalf("got %+v, %v without a watermark", state, err)
+	}
+	saved := State{
+		Watermark: time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC),
+		Blocked:   map[string]int{"r1": 2},
+		Skipped:   []Skipped{{RecordingID: "r0", ConversationID: "c0", Cycles: 3}},
+	}
+	if err := Save(path, saved); err != nil {
+		t.Fatal(err)
+	}
+	state, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.Watermark.Equal(saved.Watermark) || state.Blocked["r1"] != 2 || len(state.Skipped) != 1 || state.UpdatedAt.IsZero() {
+		t.Errorf("got %+v", state)
+	}
+}